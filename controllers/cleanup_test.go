@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/nirnanaaa/kube-readiness/pkg/cloud"
+	"github.com/nirnanaaa/kube-readiness/pkg/readiness"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var _ = Describe("State Cleanup", func() {
+	Context("Pod Controller", func() {
+		It("should keep the endpoint map bounded while pods churn", func() {
+			const generations = 20
+			const podsPerGeneration = 250
+			c := fake.NewFakeClientWithScheme(scheme.Scheme)
+			endpoints := readiness.NewEndpointPodMap()
+			reconciler := &PodReconciler{
+				Client:           c,
+				Log:              logf.NullLogger{},
+				EndpointPodMap:   endpoints,
+				EndpointPodMutex: new(sync.RWMutex),
+			}
+			for generation := 0; generation < generations; generation++ {
+				var pods []*v1.Pod
+				for i := 0; i < podsPerGeneration; i++ {
+					pod := &v1.Pod{
+						ObjectMeta: metav1.ObjectMeta{
+							Namespace: "default",
+							Name:      fmt.Sprintf("pod-%d-%d", generation, i),
+						},
+						Spec: v1.PodSpec{
+							Containers: []v1.Container{{
+								Name:  "test",
+								Ports: []v1.ContainerPort{{ContainerPort: 80}, {ContainerPort: 8080}},
+							}},
+						},
+						// ips get recycled by every generation
+						Status: v1.PodStatus{PodIP: fmt.Sprintf("10.0.%d.%d", i/250, i%250)},
+					}
+					Expect(c.Create(context.TODO(), pod)).To(Succeed())
+					_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}})
+					Expect(err).ToNot(HaveOccurred())
+					pods = append(pods, pod)
+				}
+				endpointCount, podCount := endpoints.Len()
+				Expect(endpointCount).To(Equal(2 * podsPerGeneration))
+				Expect(podCount).To(Equal(podsPerGeneration))
+
+				for _, pod := range pods {
+					Expect(c.Delete(context.TODO(), pod)).To(Succeed())
+					_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}})
+					Expect(err).ToNot(HaveOccurred())
+				}
+			}
+			endpointCount, podCount := endpoints.Len()
+			Expect(endpointCount).To(BeZero())
+			Expect(podCount).To(BeZero())
+		})
+	})
+
+	Context("Ingress Controller", func() {
+		newIngress := func(name string, services ...string) *extensionsv1beta1.Ingress {
+			ingress := &extensionsv1beta1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+				Spec: extensionsv1beta1.IngressSpec{
+					Rules: []extensionsv1beta1.IngressRule{{
+						IngressRuleValue: extensionsv1beta1.IngressRuleValue{
+							HTTP: &extensionsv1beta1.HTTPIngressRuleValue{},
+						},
+					}},
+				},
+				Status: extensionsv1beta1.IngressStatus{
+					LoadBalancer: v1.LoadBalancerStatus{
+						Ingress: []v1.LoadBalancerIngress{{Hostname: name + "-123.eu-west-1.elb.amazonaws.com"}},
+					},
+				},
+			}
+			for _, service := range services {
+				ingress.Spec.Rules[0].HTTP.Paths = append(ingress.Spec.Rules[0].HTTP.Paths, extensionsv1beta1.HTTPIngressPath{
+					Backend: extensionsv1beta1.IngressBackend{ServiceName: service, ServicePort: intstr.FromInt(80)},
+				})
+			}
+			return ingress
+		}
+
+		It("should drop the services of deleted ingresses", func() {
+			c := fake.NewFakeClientWithScheme(scheme.Scheme)
+			services := make(readiness.ServiceInfoMap)
+			reconciler := &IngressReconciler{
+				Client:              c,
+				Log:                 logf.NullLogger{},
+				EndpointGroupCache:  cloud.NewEndpointGroupCache(&cloud.Fake{}, 0, logf.NullLogger{}),
+				ServiceInfoMap:      services,
+				ServiceInfoMapMutex: new(sync.RWMutex),
+			}
+			var ingresses []*extensionsv1beta1.Ingress
+			for i := 0; i < 1000; i++ {
+				ingress := newIngress(fmt.Sprintf("ingress-%d", i), fmt.Sprintf("service-%d-a", i), fmt.Sprintf("service-%d-b", i))
+				Expect(c.Create(context.TODO(), ingress)).To(Succeed())
+				_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: ingress.Name}})
+				Expect(err).ToNot(HaveOccurred())
+				ingresses = append(ingresses, ingress)
+			}
+			Expect(services).To(HaveLen(2000))
+
+			By("removing a backend from an ingress")
+			updated := newIngress("ingress-0", "service-0-a")
+			updated.ResourceVersion = ingresses[0].ResourceVersion
+			Expect(c.Update(context.TODO(), updated)).To(Succeed())
+			_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "ingress-0"}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(services).To(HaveLen(1999))
+			Expect(services).ToNot(HaveKey(types.NamespacedName{Namespace: "default", Name: "service-0-b"}))
+
+			By("deleting all ingresses")
+			for _, ingress := range ingresses {
+				Expect(c.Delete(context.TODO(), ingress)).To(Succeed())
+				_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: ingress.Name}})
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(services).To(BeEmpty())
+		})
+
+		It("should keep a service while another ingress exposes it", func() {
+			c := fake.NewFakeClientWithScheme(scheme.Scheme)
+			sdk := &cloud.Fake{}
+			sdk.AddLoadBalancer("ingress-a-123.eu-west-1.elb.amazonaws.com", &cloud.EndpointGroup{Name: "group-a"})
+			sdk.AddLoadBalancer("ingress-b-123.eu-west-1.elb.amazonaws.com", &cloud.EndpointGroup{Name: "group-b"})
+			services := make(readiness.ServiceInfoMap)
+			reconciler := &IngressReconciler{
+				Client:              c,
+				Log:                 logf.NullLogger{},
+				EndpointGroupCache:  cloud.NewEndpointGroupCache(sdk, 0, logf.NullLogger{}),
+				ServiceInfoMap:      services,
+				ServiceInfoMapMutex: new(sync.RWMutex),
+			}
+			serviceName := types.NamespacedName{Namespace: "default", Name: "shared"}
+			groupNames := func() []string {
+				var names []string
+				for _, group := range services[serviceName].Endpoints {
+					names = append(names, group.Name)
+				}
+				return names
+			}
+			var ingresses []*extensionsv1beta1.Ingress
+			for _, name := range []string{"ingress-a", "ingress-b"} {
+				ingress := newIngress(name, serviceName.Name)
+				Expect(c.Create(context.TODO(), ingress)).To(Succeed())
+				_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: name}})
+				Expect(err).ToNot(HaveOccurred())
+				ingresses = append(ingresses, ingress)
+			}
+			Expect(services[serviceName].Ingresses).To(HaveLen(2))
+			Expect(groupNames()).To(ConsistOf("group-a", "group-b"))
+
+			By("deleting one of the ingresses")
+			Expect(c.Delete(context.TODO(), ingresses[0])).To(Succeed())
+			_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "ingress-a"}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(services).To(HaveKey(serviceName))
+			Expect(services[serviceName].Ingresses).To(HaveLen(1))
+			Expect(groupNames()).To(ConsistOf("group-b"))
+
+			By("deleting the last ingress")
+			Expect(c.Delete(context.TODO(), ingresses[1])).To(Succeed())
+			_, err = reconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "ingress-b"}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(services).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nirnanaaa/kube-readiness/pkg/cloud"
+	"github.com/nirnanaaa/kube-readiness/pkg/readiness"
+	"github.com/nirnanaaa/kube-readiness/pkg/readiness/alb"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var _ = Describe("Cloud Scenarios", func() {
+	name := types.NamespacedName{Namespace: "default", Name: "app"}
+	var (
+		c          client.Client
+		sdk        *cloud.Fake
+		now        time.Time
+		reconciler *PodReconciler
+	)
+	ready := func() v1.ConditionStatus {
+		var pod v1.Pod
+		Expect(c.Get(context.TODO(), name, &pod)).To(Succeed())
+		condition, _ := readiness.ReadinessConditionStatus(&pod)
+		return condition.Status
+	}
+	BeforeEach(func() {
+		now = time.Now()
+		sdk = &cloud.Fake{Now: func() time.Time { return now }}
+		c = fake.NewFakeClientWithScheme(scheme.Scheme, &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: name.Namespace, Name: name.Name, UID: "app-uid"},
+			Spec: v1.PodSpec{
+				Containers:     []v1.Container{{Name: "app", Ports: []v1.ContainerPort{{ContainerPort: 80}}}},
+				ReadinessGates: []v1.PodReadinessGate{{ConditionType: alb.ReadinessGate}},
+			},
+			Status: v1.PodStatus{PodIP: "10.0.0.1"},
+		})
+		reconciler = &PodReconciler{
+			Client:           c,
+			Log:              logf.NullLogger{},
+			CloudSDK:         sdk,
+			EndpointPodMap:   readiness.NewEndpointPodMap(),
+			EndpointPodMutex: new(sync.RWMutex),
+			ServiceInfoMap: readiness.ServiceInfoMap{
+				types.NamespacedName{Namespace: "default", Name: "app"}: readiness.IngressInfo{
+					Endpoints: []*cloud.EndpointGroup{{Name: "public"}, {Name: "internal"}},
+					Pods:      []readiness.PodRef{{Name: name}},
+				},
+			},
+			ServiceInfoMapMutex: new(sync.RWMutex),
+		}
+	})
+
+	It("should only mark a pod ready once it is healthy behind all load balancers", func() {
+		sdk.ScriptGroupHealth("internal", "10.0.0.1",
+			cloud.HealthStep{Healthy: false, For: 10 * time.Second},
+			cloud.HealthStep{Healthy: true, For: 5 * time.Second},
+			cloud.HealthStep{Healthy: false, For: 5 * time.Second},
+			cloud.HealthStep{Healthy: true})
+		for _, step := range []struct {
+			after  time.Duration
+			status v1.ConditionStatus
+		}{
+			{0, v1.ConditionFalse},
+			{12 * time.Second, v1.ConditionTrue},
+		} {
+			now = now.Add(step.after)
+			_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: name})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ready()).To(Equal(step.status))
+		}
+
+		By("not re-evaluating the pod once it is ready, even though the target flaps")
+		now = now.Add(5 * time.Second)
+		_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: name})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ready()).To(Equal(v1.ConditionTrue))
+		calls := sdk.Calls("IsEndpointHealthy")
+		Expect(calls).To(HaveLen(2))
+		Expect(calls[0].Groups).To(Equal([]string{"public", "internal"}))
+	})
+
+	It("should back off while the cloud provider throttles", func() {
+		sdk.Fail("IsEndpointHealthy", errors.New("Throttling: Rate exceeded"), 2)
+		r := rateLimited("pod", reconciler, logf.NullLogger{}, NewRateLimiter(10*time.Millisecond, time.Second, 100, 100))
+		var delays []time.Duration
+		for i := 0; i < 3; i++ {
+			result, err := r.Reconcile(ctrl.Request{NamespacedName: name})
+			Expect(err).ToNot(HaveOccurred())
+			delays = append(delays, result.RequeueAfter)
+		}
+		Expect(delays).To(Equal([]time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 0}))
+		Expect(ready()).To(Equal(v1.ConditionTrue))
+		Expect(sdk.Calls("IsEndpointHealthy")).To(HaveLen(3))
+	})
+})
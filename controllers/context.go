@@ -0,0 +1,46 @@
+/*
+Copyright 2019 Kube Readiness Maintainers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+)
+
+// managerContext is embedded into the reconcilers. The manager injects its stop channel when the
+// controller is set up, so that in-flight cloud calls get canceled once the manager shuts down.
+type managerContext struct {
+	ctx context.Context
+}
+
+// InjectStopChannel implements inject.Stoppable
+func (m *managerContext) InjectStopChannel(stop <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	m.ctx = ctx
+	return nil
+}
+
+// Context returns the context reconciles should be run with
+func (m *managerContext) Context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
+}
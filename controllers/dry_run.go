@@ -0,0 +1,119 @@
+/*
+Copyright 2019 Kube Readiness Maintainers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/nirnanaaa/kube-readiness/pkg/readiness"
+	"github.com/nirnanaaa/kube-readiness/pkg/readiness/alb"
+)
+
+var dryRunGateChanges = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name:      "gate_changes",
+		Namespace: "dry_run",
+		Help:      "Number of readiness gate changes recorded instead of patched in dry run mode by status",
+	},
+	[]string{"status"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(dryRunGateChanges)
+}
+
+// DryRunReason is the reason of the events recorded for readiness gate changes in dry run mode
+const DryRunReason = "DryRun"
+
+// DryRun records the readiness gate changes the pod controller would patch instead of patching them,
+// so that the controller can observe a cluster whose gates it does not own, yet. Pods behind services
+// exposed by an ingress are checked even without the readiness gate in their spec. Every change is
+// logged, counted and recorded as event of its pod once, repeated reconciles of the same outcome are
+// not reported again.
+type DryRun struct {
+	Log logr.Logger
+	// Recorder records the changes as events of the pods, no events are recorded if unset
+	Recorder record.EventRecorder
+
+	mu        sync.Mutex
+	decisions map[types.NamespacedName]dryRunDecision
+}
+
+// dryRunDecision is the last readiness gate status reported for a pod
+type dryRunDecision struct {
+	uid    types.UID
+	status corev1.ConditionStatus
+}
+
+// Enabled reports whether pods must not be patched. A missing dry run is disabled.
+func (d *DryRun) Enabled() bool {
+	return d != nil
+}
+
+// SetGate reports the condition the readiness gate of a pod would be set to, unless it got reported
+// before or the pod has it already
+func (d *DryRun) SetGate(pod *corev1.Pod, condition corev1.PodCondition) {
+	name := types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+	d.mu.Lock()
+	if d.decisions == nil {
+		d.decisions = map[types.NamespacedName]dryRunDecision{}
+	}
+	previous, ok := d.decisions[name]
+	if !ok || previous.uid != pod.UID {
+		current, _ := readiness.ReadinessConditionStatus(pod)
+		previous = dryRunDecision{uid: pod.UID, status: current.Status}
+	}
+	d.decisions[name] = dryRunDecision{uid: pod.UID, status: condition.Status}
+	d.mu.Unlock()
+	if previous.status == condition.Status {
+		return
+	}
+
+	d.Log.Info("dry run, not patching readiness gate", "pod", name, "from", previous.status, "to", condition.Status)
+	dryRunGateChanges.WithLabelValues(string(condition.Status)).Inc()
+	if d.Recorder != nil {
+		d.Recorder.Eventf(pod, corev1.EventTypeNormal, DryRunReason, "Would set readiness gate %s to %s", alb.ReadinessGate, condition.Status)
+	}
+}
+
+// Status returns the status last reported for the readiness gate of a pod, empty if none was
+func (d *DryRun) Status(pod *corev1.Pod) corev1.ConditionStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	decision, ok := d.decisions[types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}]
+	if !ok || decision.uid != pod.UID {
+		return ""
+	}
+	return decision.status
+}
+
+// Forget drops the last reported status of a pod which got deleted
+func (d *DryRun) Forget(name types.NamespacedName) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.decisions, name)
+}
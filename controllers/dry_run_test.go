@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"context"
+	"sync"
+
+	"github.com/nirnanaaa/kube-readiness/pkg/cloud"
+	"github.com/nirnanaaa/kube-readiness/pkg/readiness"
+	"github.com/nirnanaaa/kube-readiness/pkg/readiness/alb"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var _ = Describe("Dry Run", func() {
+	name := types.NamespacedName{Namespace: "default", Name: "app"}
+	var (
+		c          client.Client
+		sdk        *cloud.Fake
+		recorder   *record.FakeRecorder
+		reconciler *PodReconciler
+	)
+	newPod := func(uid types.UID) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: name.Namespace, Name: name.Name, UID: uid},
+			Spec: v1.PodSpec{
+				Containers:     []v1.Container{{Name: "app", Ports: []v1.ContainerPort{{ContainerPort: 80}}}},
+				ReadinessGates: []v1.PodReadinessGate{{ConditionType: alb.ReadinessGate}},
+			},
+			Status: v1.PodStatus{PodIP: "10.0.0.1"},
+		}
+	}
+	reconcile := func() {
+		_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: name})
+		Expect(err).ToNot(HaveOccurred())
+	}
+	gate := func() (v1.PodCondition, bool) {
+		var pod v1.Pod
+		Expect(c.Get(context.TODO(), name, &pod)).To(Succeed())
+		return readiness.ReadinessConditionStatus(&pod)
+	}
+	BeforeEach(func() {
+		sdk = &cloud.Fake{}
+		recorder = record.NewFakeRecorder(10)
+		c = fake.NewFakeClientWithScheme(scheme.Scheme, newPod("app-uid"))
+		reconciler = &PodReconciler{
+			Client:           c,
+			Log:              logf.NullLogger{},
+			CloudSDK:         sdk,
+			EndpointPodMap:   readiness.NewEndpointPodMap(),
+			EndpointPodMutex: new(sync.RWMutex),
+			ServiceInfoMap: readiness.ServiceInfoMap{
+				name: readiness.IngressInfo{
+					Endpoints: []*cloud.EndpointGroup{{Name: "public"}},
+					Pods:      []readiness.PodRef{{Name: name}},
+				},
+			},
+			ServiceInfoMapMutex: new(sync.RWMutex),
+			DryRun:              &DryRun{Log: logf.NullLogger{}, Recorder: recorder},
+		}
+	})
+
+	It("should record the gate changes instead of patching the pod", func() {
+		sdk.SetHealth("10.0.0.1", false)
+		reconcile()
+		Expect(recorder.Events).To(Receive(Equal("Normal DryRun Would set readiness gate " + alb.ReadinessGate + " to False")))
+		_, exists := gate()
+		Expect(exists).To(BeFalse())
+
+		By("not reporting the same outcome again")
+		reconcile()
+		Expect(recorder.Events).ToNot(Receive())
+
+		sdk.SetHealth("10.0.0.1", true)
+		reconcile()
+		Expect(recorder.Events).To(Receive(Equal("Normal DryRun Would set readiness gate " + alb.ReadinessGate + " to True")))
+		_, exists = gate()
+		Expect(exists).To(BeFalse())
+		Expect(sdk.Calls("IsEndpointHealthy")).To(HaveLen(3))
+	})
+
+	It("should report a replaced pod again", func() {
+		sdk.SetHealth("10.0.0.1", false)
+		reconcile()
+		Expect(recorder.Events).To(Receive())
+
+		Expect(c.Delete(context.TODO(), newPod("app-uid"))).To(Succeed())
+		reconcile()
+		Expect(c.Create(context.TODO(), newPod("new-uid"))).To(Succeed())
+		reconcile()
+		Expect(recorder.Events).To(Receive(ContainSubstring("to False")))
+	})
+
+	It("should not report the status a pod has already", func() {
+		pod := newPod("app-uid")
+		Expect(c.Get(context.TODO(), name, pod)).To(Succeed())
+		pod.Status.Conditions = []v1.PodCondition{{Type: alb.ReadinessGate, Status: v1.ConditionFalse}}
+		Expect(c.Update(context.TODO(), pod)).To(Succeed())
+		sdk.SetHealth("10.0.0.1", false)
+		reconcile()
+		Expect(recorder.Events).ToNot(Receive())
+	})
+
+	It("should check pods behind exposed services without the readiness gate", func() {
+		pod := newPod("app-uid")
+		Expect(c.Get(context.TODO(), name, pod)).To(Succeed())
+		pod.Spec.ReadinessGates = nil
+		Expect(c.Update(context.TODO(), pod)).To(Succeed())
+		other := newPod("other-uid")
+		other.Name, other.Spec.ReadinessGates = "other", nil
+		Expect(c.Create(context.TODO(), other)).To(Succeed())
+
+		sdk.SetHealth("10.0.0.1", false)
+		reconcile()
+		Expect(recorder.Events).To(Receive(ContainSubstring("to False")))
+		sdk.SetHealth("10.0.0.1", true)
+		reconcile()
+		Expect(recorder.Events).To(Receive(ContainSubstring("to True")))
+
+		By("not checking the pod again once it would be ready")
+		reconcile()
+		Expect(sdk.Calls("IsEndpointHealthy")).To(HaveLen(2))
+
+		By("skipping pods which are not behind an exposed service")
+		_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "other"}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(recorder.Events).ToNot(Receive())
+		Expect(sdk.Calls("IsEndpointHealthy")).To(HaveLen(2))
+	})
+
+	It("should patch the pod without a dry run", func() {
+		reconciler.DryRun = nil
+		reconcile()
+		condition, _ := gate()
+		Expect(condition.Status).To(Equal(v1.ConditionTrue))
+	})
+})
@@ -0,0 +1,131 @@
+/*
+Copyright 2019 Kube Readiness Maintainers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+// serviceNameLabel links an EndpointSlice to its service
+const serviceNameLabel = "kubernetes.io/service-name"
+
+// endpointSliceKinds lists the served EndpointSlice versions in order of preference. The slices are
+// handled as unstructured objects, as the vendored api does not know the discovery group.
+var endpointSliceKinds = []schema.GroupVersionKind{
+	{Group: "discovery.k8s.io", Version: "v1", Kind: "EndpointSlice"},
+	{Group: "discovery.k8s.io", Version: "v1beta1", Kind: "EndpointSlice"},
+}
+
+// endpointSlice holds the fields of a discovery.k8s.io EndpointSlice needed to resolve pods.
+// They are identical in v1beta1 and v1.
+type endpointSlice struct {
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Endpoints         []sliceEndpoint `json:"endpoints"`
+	Ports             []slicePort     `json:"ports"`
+}
+
+type sliceEndpoint struct {
+	Addresses  []string                `json:"addresses"`
+	Conditions sliceConditions         `json:"conditions"`
+	TargetRef  *corev1.ObjectReference `json:"targetRef,omitempty"`
+}
+
+// sliceConditions are tri-state, nil means unknown
+type sliceConditions struct {
+	Ready       *bool `json:"ready,omitempty"`
+	Serving     *bool `json:"serving,omitempty"`
+	Terminating *bool `json:"terminating,omitempty"`
+}
+
+type slicePort struct {
+	Port *int32 `json:"port,omitempty"`
+}
+
+// serving reports whether the endpoint receives traffic. Clusters without the serving condition
+// only report ready, which is never true for terminating endpoints.
+func (c sliceConditions) serving() bool {
+	if c.Serving != nil {
+		return *c.Serving
+	}
+	return c.Ready == nil || *c.Ready
+}
+
+func (c sliceConditions) terminating() bool {
+	return c.Terminating != nil && *c.Terminating
+}
+
+// managed reports whether the pod behind an endpoint belongs to the service. Pods which are not ready,
+// yet, are waiting for their readiness gate. Terminating pods are dropped once they stop serving.
+func (e sliceEndpoint) managed() bool {
+	return !e.Conditions.terminating() || e.Conditions.serving()
+}
+
+// servedEndpointSliceKind returns the preferred EndpointSlice version the cluster serves
+func servedEndpointSliceKind(mapper meta.RESTMapper) (schema.GroupVersionKind, bool) {
+	for _, gvk := range endpointSliceKinds {
+		if _, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version); err == nil {
+			return gvk, true
+		}
+	}
+	return schema.GroupVersionKind{}, false
+}
+
+func newEndpointSliceObject(gvk schema.GroupVersionKind) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	return obj
+}
+
+func newEndpointSliceList(gvk schema.GroupVersionKind) *unstructured.UnstructuredList {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+	return list
+}
+
+func endpointSlicesFromList(list *unstructured.UnstructuredList) ([]endpointSlice, error) {
+	slices := make([]endpointSlice, 0, len(list.Items))
+	for _, item := range list.Items {
+		var slice endpointSlice
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &slice); err != nil {
+			return nil, err
+		}
+		slices = append(slices, slice)
+	}
+	return slices, nil
+}
+
+// endpointSliceToService maps an EndpointSlice to the service it belongs to
+func endpointSliceToService(obj handler.MapObject) []ctrl.Request {
+	name := obj.Meta.GetLabels()[serviceNameLabel]
+	if name == "" {
+		return nil
+	}
+	return []ctrl.Request{{
+		NamespacedName: types.NamespacedName{
+			Namespace: obj.Meta.GetNamespace(),
+			Name:      name,
+		},
+	}}
+}
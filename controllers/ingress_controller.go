@@ -17,7 +17,6 @@ limitations under the License.
 package controllers
 
 import (
-	"context"
 	"sync"
 
 	"github.com/go-logr/logr"
@@ -26,69 +25,225 @@ import (
 	"github.com/nirnanaaa/kube-readiness/pkg/readiness"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
+	corev1 "k8s.io/api/core/v1"
 	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/util/workqueue"
 )
 
 // IngressReconciler reconciles a Ingress object
 type IngressReconciler struct {
 	client.Client
-	CloudSDK            cloud.SDK
+	managerContext
+	eventQueue
+	EndpointGroupCache  *cloud.EndpointGroupCache
 	Log                 logr.Logger
 	ServiceInfoMapMutex *sync.RWMutex
 	ServiceInfoMap      readiness.ServiceInfoMap
 	ServiceReconciler   *ServiceReconciler
+	PodReconciler       *PodReconciler
+	// Namespaces restricts the ingresses whose services are tracked
+	Namespaces *NamespaceFilter
+	// Roles selects the role the load balancer of an ingress is looked up with, the default role if unset
+	Roles *cloud.RoleMapping
+	// MaxConcurrentReconciles is the number of ingresses reconciled in parallel, 1 if unset
+	MaxConcurrentReconciles int
+	// RateLimiter delays failed and requeued reconciles, the default rate limiter of the workqueue if unset
+	RateLimiter workqueue.RateLimiter
 }
 
 // +kubebuilder:rbac:groups=extensions,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=extensions,resources=ingresses/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
 
 func (r *IngressReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	log := r.Log.WithValues("ingress", req.NamespacedName)
-	ctx := context.Background()
+	ctx := r.Context()
 	var ingress extensionsv1beta1.Ingress
 	if err := r.Get(ctx, req.NamespacedName, &ingress); err != nil {
 		if apierrors.IsNotFound(err) {
+			r.removeServices(req.NamespacedName)
 			return ctrl.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
 		return ctrl.Result{}, err
 	}
+	if ingress.DeletionTimestamp != nil {
+		r.removeServices(req.NamespacedName)
+		return ctrl.Result{}, nil
+	}
+	if ok, err := r.Namespaces.Matches(ctx, ingress.Namespace); err != nil {
+		return ctrl.Result{}, err
+	} else if !ok {
+		r.removeServices(req.NamespacedName)
+		return ctrl.Result{}, nil
+	}
 	log.V(5).Info("start evaluating ingress")
 	hostname, err := readiness.ExtractHostname(&ingress)
 	if err != nil {
-		return ctrl.Result{Requeue: true}, nil
+		// the load balancer is not provisioned (anymore). The status update enqueues the ingress again.
+		r.removeServices(req.NamespacedName)
+		return ctrl.Result{}, nil
+	}
+	role, err := r.Roles.Role(ingress.Namespace, ingress.Annotations)
+	if err != nil {
+		return ctrl.Result{}, err
 	}
-	endpointGroups, err := r.CloudSDK.GetEndpointGroupsByHostname(context.Background(), hostname)
+	endpointGroups, err := r.EndpointGroupCache.GetEndpointGroupsByHostname(cloud.WithRole(ctx, role), hostname)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
+	var services []types.NamespacedName
+	servicePorts := map[types.NamespacedName][]intstr.IntOrString{}
 	utils.TraverseIngressBackends(&ingress, func(id utils.ServicePortID) bool {
-		serviceName := types.NamespacedName{
-			Namespace: id.Service.Namespace,
-			Name:      id.Service.Name,
+		if _, ok := servicePorts[id.Service]; !ok {
+			services = append(services, id.Service)
 		}
-		serviceInfo := readiness.IngressInfo{
-			Endpoints: endpointGroups,
-			Name:      hostname,
+		for _, port := range servicePorts[id.Service] {
+			if port == id.Port {
+				return false
+			}
 		}
-		r.ServiceInfoMapMutex.Lock()
-		r.ServiceInfoMap.Add(serviceName, serviceInfo)
-		r.ServiceInfoMapMutex.Unlock()
-
-		// log.Info("queueing service", "namespace", id.Service.Namespace, "name", id.Service.Name)
-		r.ServiceReconciler.Reconcile(ctrl.Request{
-			NamespacedName: serviceName,
-		})
+		servicePorts[id.Service] = append(servicePorts[id.Service], id.Port)
 		return false
 	})
+	r.ServiceInfoMapMutex.Lock()
+	for _, serviceName := range services {
+		// keep the pods of the service, they are maintained by the service controller
+		serviceInfo := r.ServiceInfoMap[serviceName]
+		serviceInfo.SetIngress(req.NamespacedName, readiness.IngressBackend{
+			Endpoints:    endpointGroups,
+			ServicePorts: servicePorts[serviceName],
+		})
+		serviceInfo.Name = hostname
+		r.ServiceInfoMap.Add(serviceName, serviceInfo)
+	}
+	r.ServiceInfoMapMutex.Unlock()
+	// drop services which are not exposed by the ingress anymore
+	r.removeServices(req.NamespacedName, services...)
+	log.V(5).Info("queueing services", "services", services)
+	r.ServiceReconciler.Enqueue(services...)
 	return ctrl.Result{}, nil
 }
 
+// removeServices drops all services of an ingress except for the ones to keep and enqueues their pods
+func (r *IngressReconciler) removeServices(ingress types.NamespacedName, keep ...types.NamespacedName) {
+	r.ServiceInfoMapMutex.Lock()
+	pods := r.ServiceInfoMap.RemoveIngress(ingress, keep...)
+	r.ServiceInfoMapMutex.Unlock()
+	r.PodReconciler.Enqueue(pods...)
+}
+
 func (r *IngressReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	builder := ctrl.NewControllerManagedBy(mgr).
 		For(&extensionsv1beta1.Ingress{}).
-		Complete(r)
+		Watches(r.watchQueue()).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.MaxConcurrentReconciles,
+		}).
+		WithEventFilter(r.Namespaces.predicate()).
+		WithEventFilter(predicate.Funcs{
+			UpdateFunc: r.invalidateOnChange,
+			DeleteFunc: r.invalidateOnDelete,
+		})
+	if r.Namespaces != nil {
+		// namespaces entering or leaving the selector add or drop their ingresses
+		builder = builder.Watches(&source.Kind{Type: &corev1.Namespace{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.namespaceToIngresses),
+		})
+	}
+	return builder.Complete(rateLimited("ingress", r, r.Log, r.RateLimiter))
+}
+
+// Enqueue requests a reconcile of the given ingresses
+func (r *IngressReconciler) Enqueue(names ...types.NamespacedName) {
+	if r == nil {
+		return
+	}
+	r.enqueue(newIngressEvent, names...)
+}
+
+// EnqueueAll requests a reconcile of all ingresses, e.g. once the namespace filter changed
+func (r *IngressReconciler) EnqueueAll() error {
+	var ingresses extensionsv1beta1.IngressList
+	if err := r.List(r.Context(), &ingresses); err != nil {
+		return err
+	}
+	r.enqueue(newIngressEvent, ingressNames(ingresses.Items)...)
+	return nil
+}
+
+func newIngressEvent(name types.NamespacedName) event.GenericEvent {
+	ingress := &extensionsv1beta1.Ingress{ObjectMeta: metav1.ObjectMeta{Namespace: name.Namespace, Name: name.Name}}
+	return event.GenericEvent{Meta: ingress, Object: ingress}
+}
+
+func ingressNames(ingresses []extensionsv1beta1.Ingress) []types.NamespacedName {
+	names := make([]types.NamespacedName, 0, len(ingresses))
+	for _, ingress := range ingresses {
+		names = append(names, types.NamespacedName{Namespace: ingress.Namespace, Name: ingress.Name})
+	}
+	return names
+}
+
+// namespaceToIngresses maps a namespace to all ingresses within
+func (r *IngressReconciler) namespaceToIngresses(obj handler.MapObject) []ctrl.Request {
+	var ingresses extensionsv1beta1.IngressList
+	if err := r.List(r.Context(), &ingresses, client.InNamespace(obj.Meta.GetName())); err != nil {
+		r.Log.Error(err, "unable to list ingresses", "namespace", obj.Meta.GetName())
+		return nil
+	}
+	requests := make([]ctrl.Request, 0, len(ingresses.Items))
+	for _, name := range ingressNames(ingresses.Items) {
+		requests = append(requests, ctrl.Request{NamespacedName: name})
+	}
+	return requests
+}
+
+// invalidateOnChange drops the cached endpoint groups of an ingress whose spec or status changed.
+// It never filters any event.
+func (r *IngressReconciler) invalidateOnChange(e event.UpdateEvent) bool {
+	oldIngress, ok := e.ObjectOld.(*extensionsv1beta1.Ingress)
+	if !ok {
+		return true
+	}
+	newIngress, ok := e.ObjectNew.(*extensionsv1beta1.Ingress)
+	if !ok {
+		return true
+	}
+	if equality.Semantic.DeepEqual(oldIngress.Spec, newIngress.Spec) &&
+		equality.Semantic.DeepEqual(oldIngress.Status, newIngress.Status) {
+		return true
+	}
+	r.EndpointGroupCache.Invalidate(ingressHostnames(oldIngress, newIngress)...)
+	return true
+}
+
+func (r *IngressReconciler) invalidateOnDelete(e event.DeleteEvent) bool {
+	if ingress, ok := e.Object.(*extensionsv1beta1.Ingress); ok {
+		r.EndpointGroupCache.Invalidate(ingressHostnames(ingress)...)
+	}
+	return true
+}
+
+func ingressHostnames(ingresses ...*extensionsv1beta1.Ingress) []string {
+	var hostnames []string
+	for _, ingress := range ingresses {
+		for _, lb := range ingress.Status.LoadBalancer.Ingress {
+			if lb.Hostname != "" {
+				hostnames = append(hostnames, lb.Hostname)
+			}
+		}
+	}
+	return hostnames
 }
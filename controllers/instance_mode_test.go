@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"context"
+	"sync"
+
+	"github.com/nirnanaaa/kube-readiness/pkg/cloud"
+	"github.com/nirnanaaa/kube-readiness/pkg/readiness"
+	"github.com/nirnanaaa/kube-readiness/pkg/readiness/alb"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// targetRecorder records the targets whose health got checked
+type targetRecorder struct {
+	cloud.Fake
+	targets map[string][]int32
+}
+
+func (t *targetRecorder) IsEndpointHealthy(ctx context.Context, groups []*cloud.EndpointGroup, name string, ports []int32) (bool, error) {
+	t.targets[name] = ports
+	return t.Fake.IsEndpointHealthy(ctx, groups, name, ports)
+}
+
+var _ = Describe("Instance Mode", func() {
+	podName := types.NamespacedName{Namespace: "default", Name: "pod"}
+	serviceName := types.NamespacedName{Namespace: "default", Name: "service"}
+	var (
+		c          *targetRecorder
+		reconciler *PodReconciler
+	)
+	// setIngress exposes the service by the ingress of the given name
+	setIngress := func(name string, backend readiness.IngressBackend) {
+		info := reconciler.ServiceInfoMap[serviceName]
+		info.SetIngress(types.NamespacedName{Namespace: "default", Name: name}, backend)
+		reconciler.ServiceInfoMap[serviceName] = info
+	}
+	BeforeEach(func() {
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: podName.Namespace, Name: podName.Name},
+			Spec: v1.PodSpec{
+				NodeName:       "node",
+				Containers:     []v1.Container{{Name: "test", Ports: []v1.ContainerPort{{ContainerPort: 8080}}}},
+				ReadinessGates: []v1.PodReadinessGate{{ConditionType: alb.ReadinessGate}},
+			},
+			Status: v1.PodStatus{PodIP: "10.0.0.1"},
+		}
+		node := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node"},
+			Spec:       v1.NodeSpec{ProviderID: "aws:///eu-west-1a/i-0123456789abcdef0"},
+		}
+		c = &targetRecorder{targets: map[string][]int32{}}
+		reconciler = &PodReconciler{
+			Client:           fake.NewFakeClientWithScheme(scheme.Scheme, pod, node),
+			Log:              logf.NullLogger{},
+			CloudSDK:         c,
+			EndpointPodMap:   readiness.NewEndpointPodMap(),
+			EndpointPodMutex: new(sync.RWMutex),
+			ServiceInfoMap: readiness.ServiceInfoMap{
+				serviceName: readiness.IngressInfo{Pods: []readiness.PodRef{{Name: podName}}},
+			},
+			ServiceInfoMapMutex: new(sync.RWMutex),
+		}
+		setIngress("ingress", readiness.IngressBackend{
+			Endpoints:    []*cloud.EndpointGroup{{Name: "tg", TargetType: cloud.TargetTypeInstance}},
+			ServicePorts: []intstr.IntOrString{intstr.FromInt(80)},
+			NodePorts:    []int32{30080},
+		})
+	})
+
+	It("should check the node instance on the service node ports", func() {
+		_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: podName})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.targets).To(Equal(map[string][]int32{"i-0123456789abcdef0": {30080}}))
+
+		var pod v1.Pod
+		Expect(reconciler.Get(context.TODO(), podName, &pod)).To(Succeed())
+		condition, _ := readiness.ReadinessConditionStatus(&pod)
+		Expect(condition.Status).To(Equal(v1.ConditionTrue))
+	})
+
+	It("should check pod ip and node instance for mixed endpoint groups", func() {
+		setIngress("ingress-ip", readiness.IngressBackend{
+			Endpoints:    []*cloud.EndpointGroup{{Name: "tg-ip", TargetType: cloud.TargetTypeIP}},
+			ServicePorts: []intstr.IntOrString{intstr.FromInt(80)},
+		})
+		_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: podName})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.targets).To(Equal(map[string][]int32{
+			"i-0123456789abcdef0": {30080},
+			"10.0.0.1":            {8080},
+		}))
+	})
+
+	It("should not mark the pod ready before it is scheduled", func() {
+		var pod v1.Pod
+		Expect(reconciler.Get(context.TODO(), podName, &pod)).To(Succeed())
+		pod.Spec.NodeName = ""
+		Expect(reconciler.Update(context.TODO(), &pod)).To(Succeed())
+		_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: podName})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.targets).To(BeEmpty())
+		Expect(reconciler.Get(context.TODO(), podName, &pod)).To(Succeed())
+		condition, _ := readiness.ReadinessConditionStatus(&pod)
+		Expect(condition.Status).To(Equal(v1.ConditionFalse))
+	})
+	It("should check every ingress on the node port of its service port", func() {
+		setIngress("ingress-admin", readiness.IngressBackend{
+			Endpoints:    []*cloud.EndpointGroup{{Name: "tg-admin", TargetType: cloud.TargetTypeInstance}},
+			ServicePorts: []intstr.IntOrString{intstr.FromString("admin")},
+			NodePorts:    []int32{30090},
+		})
+		c.ScriptGroupHealth("tg-admin", "i-0123456789abcdef0", cloud.HealthStep{Healthy: false})
+		_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: podName})
+		Expect(err).ToNot(HaveOccurred())
+		var ports [][]int32
+		for _, call := range c.Calls("IsEndpointHealthy") {
+			ports = append(ports, call.Ports)
+		}
+		Expect(ports).To(ConsistOf([]int32{30080}, []int32{30090}))
+
+		var pod v1.Pod
+		Expect(reconciler.Get(context.TODO(), podName, &pod)).To(Succeed())
+		condition, _ := readiness.ReadinessConditionStatus(&pod)
+		Expect(condition.Status).To(Equal(v1.ConditionFalse), "the node is unhealthy in the group of the second ingress")
+	})
+})
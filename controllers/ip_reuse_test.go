@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nirnanaaa/kube-readiness/pkg/cloud"
+	"github.com/nirnanaaa/kube-readiness/pkg/readiness"
+	"github.com/nirnanaaa/kube-readiness/pkg/readiness/alb"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var _ = Describe("IP Reuse", func() {
+	endpoint := readiness.IngressEndpoint{IP: "10.0.0.1", Port: 80}
+	oldPod := readiness.PodRef{Name: types.NamespacedName{Namespace: "default", Name: "old"}, UID: "old-uid"}
+	newPod := readiness.PodRef{Name: types.NamespacedName{Namespace: "default", Name: "new"}, UID: "new-uid"}
+
+	It("should resolve a recycled ip to the pod which got it last", func() {
+		endpoints := readiness.NewEndpointPodMap()
+		endpoints.SetPod(oldPod, []readiness.IngressEndpoint{endpoint})
+		address := v1.EndpointAddress{IP: endpoint.IP}
+		pod, err := getPodFromEndpointMap(endpoints, address, v1.EndpointPort{Port: endpoint.Port})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pod).To(Equal(oldPod))
+
+		By("recording the new pod for the recycled ip")
+		endpoints.SetPod(newPod, []readiness.IngressEndpoint{endpoint})
+		pod, err = getPodFromEndpointMap(endpoints, address, v1.EndpointPort{Port: endpoint.Port})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pod).To(Equal(newPod))
+		endpointCount, podCount := endpoints.Len()
+		Expect(endpointCount).To(Equal(1))
+		Expect(podCount).To(Equal(1))
+	})
+
+	It("should not mark a pod ready on target health which predates its endpoint", func() {
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: newPod.Name.Namespace,
+				Name:      newPod.Name.Name,
+				UID:       newPod.UID,
+				// the pod got its ip long after it was created
+				CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Hour)),
+			},
+			Spec: v1.PodSpec{
+				Containers:     []v1.Container{{Name: "test", Ports: []v1.ContainerPort{{ContainerPort: endpoint.Port}}}},
+				ReadinessGates: []v1.PodReadinessGate{{ConditionType: alb.ReadinessGate}},
+			},
+			Status: v1.PodStatus{PodIP: endpoint.IP},
+		}
+		c := fake.NewFakeClientWithScheme(scheme.Scheme, pod)
+		services := readiness.ServiceInfoMap{
+			types.NamespacedName{Namespace: "default", Name: "service"}: readiness.IngressInfo{
+				Endpoints: []*cloud.EndpointGroup{{Name: "tg", HealthCheckInterval: 15 * time.Second, HealthyThreshold: 2}},
+				Pods:      []readiness.PodRef{{Name: newPod.Name}},
+			},
+		}
+		now := time.Now()
+		endpoints := readiness.NewEndpointPodMap()
+		endpoints.Now = func() time.Time { return now }
+		reconciler := &PodReconciler{
+			Client:              c,
+			Log:                 logf.NullLogger{},
+			CloudSDK:            &cloud.Fake{},
+			EndpointPodMap:      endpoints,
+			EndpointPodMutex:    new(sync.RWMutex),
+			ServiceInfoMap:      services,
+			ServiceInfoMapMutex: new(sync.RWMutex),
+		}
+		result, err := reconciler.Reconcile(ctrl.Request{NamespacedName: newPod.Name})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(30 * time.Second))
+
+		var fetched v1.Pod
+		Expect(c.Get(context.TODO(), newPod.Name, &fetched)).To(Succeed())
+		condition, _ := readiness.ReadinessConditionStatus(&fetched)
+		Expect(condition.Status).ToNot(Equal(v1.ConditionTrue))
+
+		By("checking again before the health checks ran after the endpoint got first seen")
+		now = now.Add(20 * time.Second)
+		result, err = reconciler.Reconcile(ctrl.Request{NamespacedName: newPod.Name})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(10 * time.Second))
+
+		By("checking again once they ran")
+		now = now.Add(10 * time.Second)
+		_, err = reconciler.Reconcile(ctrl.Request{NamespacedName: newPod.Name})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.Get(context.TODO(), newPod.Name, &fetched)).To(Succeed())
+		condition, _ = readiness.ReadinessConditionStatus(&fetched)
+		Expect(condition.Status).To(Equal(v1.ConditionTrue))
+	})
+
+	It("should not count a recreated pod of the same name behind the endpoints of its predecessor", func() {
+		podName := types.NamespacedName{Namespace: "default", Name: "web-0"}
+		serviceName := types.NamespacedName{Namespace: "default", Name: "web"}
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: podName.Namespace, Name: podName.Name, UID: "new-uid"},
+			Spec: v1.PodSpec{
+				Containers:     []v1.Container{{Name: "web", Ports: []v1.ContainerPort{{ContainerPort: 80}}}},
+				ReadinessGates: []v1.PodReadinessGate{{ConditionType: alb.ReadinessGate}},
+			},
+			Status: v1.PodStatus{PodIP: "10.0.0.2"},
+		}
+		// the endpoints still list the deleted pod of the StatefulSet
+		endpoints := &v1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Namespace: serviceName.Namespace, Name: serviceName.Name},
+			Subsets: []v1.EndpointSubset{{
+				Addresses: []v1.EndpointAddress{{IP: "10.0.0.1", TargetRef: &v1.ObjectReference{Kind: "Pod", Name: podName.Name, UID: "old-uid"}}},
+				Ports:     []v1.EndpointPort{{Port: 80}},
+			}},
+		}
+		service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: serviceName.Namespace, Name: serviceName.Name}}
+		c := fake.NewFakeClientWithScheme(scheme.Scheme, pod, endpoints, service)
+		services := readiness.ServiceInfoMap{
+			serviceName: readiness.IngressInfo{Endpoints: []*cloud.EndpointGroup{{Name: "tg"}}},
+		}
+		endpointMap := readiness.NewEndpointPodMap()
+		endpointMutex, servicesMutex := new(sync.RWMutex), new(sync.RWMutex)
+		podReconciler := &PodReconciler{
+			Client:              c,
+			Log:                 logf.NullLogger{},
+			CloudSDK:            &cloud.Fake{},
+			EndpointPodMap:      endpointMap,
+			EndpointPodMutex:    endpointMutex,
+			ServiceInfoMap:      services,
+			ServiceInfoMapMutex: servicesMutex,
+		}
+		serviceReconciler := &ServiceReconciler{
+			Client:              c,
+			Log:                 logf.NullLogger{},
+			EndpointPodMap:      endpointMap,
+			Lock:                endpointMutex,
+			ServiceInfoMap:      services,
+			ServiceInfoMapMutex: servicesMutex,
+			PodReconciler:       podReconciler,
+		}
+		reconcileBoth := func() {
+			_, err := serviceReconciler.Reconcile(ctrl.Request{NamespacedName: serviceName})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = podReconciler.Reconcile(ctrl.Request{NamespacedName: podName})
+			Expect(err).ToNot(HaveOccurred())
+		}
+		condition := func() v1.ConditionStatus {
+			var fetched v1.Pod
+			Expect(c.Get(context.TODO(), podName, &fetched)).To(Succeed())
+			condition, _ := readiness.ReadinessConditionStatus(&fetched)
+			return condition.Status
+		}
+
+		reconcileBoth()
+		Expect(services[serviceName].Pods).To(ConsistOf(readiness.PodRef{Name: podName, UID: "old-uid"}))
+		Expect(condition()).To(Equal(v1.ConditionUnknown))
+
+		By("listing the new pod in the endpoints")
+		endpoints.Subsets[0].Addresses = []v1.EndpointAddress{{IP: "10.0.0.2", TargetRef: &v1.ObjectReference{Kind: "Pod", Name: podName.Name, UID: "new-uid"}}}
+		Expect(c.Update(context.TODO(), endpoints)).To(Succeed())
+		reconcileBoth()
+		Expect(condition()).To(Equal(v1.ConditionTrue))
+	})
+})
@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nirnanaaa/kube-readiness/pkg/cloud"
+	"github.com/nirnanaaa/kube-readiness/pkg/readiness"
+	"github.com/nirnanaaa/kube-readiness/pkg/readiness/alb"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// newReplica wires the reconcilers of a single controller replica with empty state
+func newReplica(c client.Client) *PodReconciler {
+	endpointPodMutex := new(sync.RWMutex)
+	serviceInfoMutex := new(sync.RWMutex)
+	endpointPodMap := readiness.NewEndpointPodMap()
+	serviceInfoMap := readiness.ServiceInfoMap{}
+	sdk := &cloud.Fake{Strict: true}
+	sdk.AddLoadBalancer("lb-1234.eu-west-1.elb.amazonaws.com", &cloud.EndpointGroup{Name: "tg"})
+	podReconciler := &PodReconciler{
+		Client:              c,
+		Log:                 logf.NullLogger{},
+		CloudSDK:            sdk,
+		EndpointPodMap:      endpointPodMap,
+		EndpointPodMutex:    endpointPodMutex,
+		ServiceInfoMap:      serviceInfoMap,
+		ServiceInfoMapMutex: serviceInfoMutex,
+	}
+	serviceReconciler := &ServiceReconciler{
+		Client:              c,
+		Log:                 logf.NullLogger{},
+		EndpointPodMap:      endpointPodMap,
+		Lock:                endpointPodMutex,
+		ServiceInfoMap:      serviceInfoMap,
+		ServiceInfoMapMutex: serviceInfoMutex,
+		PodReconciler:       podReconciler,
+	}
+	podReconciler.Warmup = &Warmup{
+		Client: c,
+		Log:    logf.NullLogger{},
+		IngressReconciler: &IngressReconciler{
+			Client:              c,
+			Log:                 logf.NullLogger{},
+			EndpointGroupCache:  cloud.NewEndpointGroupCache(sdk, 0, logf.NullLogger{}),
+			ServiceInfoMap:      serviceInfoMap,
+			ServiceInfoMapMutex: serviceInfoMutex,
+			ServiceReconciler:   serviceReconciler,
+			PodReconciler:       podReconciler,
+		},
+		ServiceReconciler: serviceReconciler,
+		PodReconciler:     podReconciler,
+		RetryInterval:     10 * time.Millisecond,
+	}
+	return podReconciler
+}
+
+var _ = Describe("Leader Election", func() {
+	podName := types.NamespacedName{Namespace: "default", Name: "pod"}
+	var c client.Client
+
+	podCondition := func() v1.ConditionStatus {
+		var pod v1.Pod
+		Expect(c.Get(context.TODO(), podName, &pod)).To(Succeed())
+		condition, _ := readiness.ReadinessConditionStatus(&pod)
+		return condition.Status
+	}
+
+	BeforeEach(func() {
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: podName.Namespace, Name: podName.Name},
+			Spec: v1.PodSpec{
+				Containers:     []v1.Container{{Name: "test", Ports: []v1.ContainerPort{{ContainerPort: 80}}}},
+				ReadinessGates: []v1.PodReadinessGate{{ConditionType: alb.ReadinessGate}},
+			},
+			Status: v1.PodStatus{
+				PodIP: "10.0.0.1",
+				// set by the previous leader
+				Conditions: []v1.PodCondition{{Type: alb.ReadinessGate, Status: v1.ConditionFalse}},
+			},
+		}
+		service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "service"}}
+		endpoints := &v1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "service"},
+			Subsets: []v1.EndpointSubset{{
+				NotReadyAddresses: []v1.EndpointAddress{{IP: "10.0.0.1", TargetRef: &v1.ObjectReference{Kind: "Pod", Name: podName.Name}}},
+				Ports:             []v1.EndpointPort{{Port: 80}},
+			}},
+		}
+		ingress := &extensionsv1beta1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ingress"},
+			Spec: extensionsv1beta1.IngressSpec{
+				Backend: &extensionsv1beta1.IngressBackend{ServiceName: "service", ServicePort: intstr.FromInt(80)},
+			},
+			Status: extensionsv1beta1.IngressStatus{LoadBalancer: v1.LoadBalancerStatus{
+				Ingress: []v1.LoadBalancerIngress{{Hostname: "lb-1234.eu-west-1.elb.amazonaws.com"}},
+			}},
+		}
+		c = fake.NewFakeClientWithScheme(scheme.Scheme, pod, service, endpoints, ingress)
+	})
+
+	It("should mark pods unknown on a new leader without warm-up", func() {
+		replica := newReplica(c)
+		replica.Warmup = nil
+		_, err := replica.Reconcile(ctrl.Request{NamespacedName: podName})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(podCondition()).To(Equal(v1.ConditionUnknown))
+	})
+
+	It("should rebuild the state before patching pods after a handover", func() {
+		replica := newReplica(c)
+
+		By("reconciling the pod before the warm-up ran")
+		result, err := replica.Reconcile(ctrl.Request{NamespacedName: podName})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.RequeueAfter).ToNot(BeZero())
+		Expect(podCondition()).To(Equal(v1.ConditionFalse))
+		Expect(replica.Warmup.Elected()).To(BeFalse())
+
+		By("acquiring the leadership")
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			defer GinkgoRecover()
+			Expect(replica.Warmup.Start(stop)).To(Succeed())
+		}()
+		Eventually(replica.Warmup.Done).Should(BeTrue())
+		Expect(replica.Warmup.Elected()).To(BeTrue())
+		replica.ServiceInfoMapMutex.RLock()
+		Expect(readiness.PodNames(replica.ServiceInfoMap[types.NamespacedName{Namespace: "default", Name: "service"}].Pods)).To(ConsistOf(podName))
+		replica.ServiceInfoMapMutex.RUnlock()
+
+		By("reconciling the pod with the rebuilt state")
+		_, err = replica.Reconcile(ctrl.Request{NamespacedName: podName})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(podCondition()).To(Equal(v1.ConditionTrue))
+	})
+
+	It("should complete the warm-up despite an ingress whose load balancer does not exist", func() {
+		broken := &extensionsv1beta1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "broken"},
+			Spec: extensionsv1beta1.IngressSpec{
+				Backend: &extensionsv1beta1.IngressBackend{ServiceName: "other", ServicePort: intstr.FromInt(80)},
+			},
+			Status: extensionsv1beta1.IngressStatus{LoadBalancer: v1.LoadBalancerStatus{
+				Ingress: []v1.LoadBalancerIngress{{Hostname: "deleted-1234.eu-west-1.elb.amazonaws.com"}},
+			}},
+		}
+		Expect(c.Create(context.TODO(), broken)).To(Succeed())
+		replica := newReplica(c)
+
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			defer GinkgoRecover()
+			Expect(replica.Warmup.Start(stop)).To(Succeed())
+		}()
+		Eventually(replica.Warmup.Done).Should(BeTrue())
+		Expect(testutil.ToFloat64(warmupReconcileErrors.WithLabelValues("ingress"))).To(BeNumerically(">=", 1))
+
+		_, err := replica.Reconcile(ctrl.Request{NamespacedName: podName})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(podCondition()).To(Equal(v1.ConditionTrue))
+	})
+})
@@ -0,0 +1,105 @@
+/*
+Copyright 2019 Kube Readiness Maintainers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// NamespaceFilter restricts the reconcilers to a set of namespaces, so that the readiness gate can be
+// rolled out tenant by tenant. A nil filter matches all namespaces.
+type NamespaceFilter struct {
+	// Client reads the labels of namespaces, it is only needed with a selector
+	Client client.Reader
+	// Include lists the only namespaces to match, empty matches all
+	Include []string
+	// Exclude lists namespaces never to match, it takes precedence over Include and Selector
+	Exclude []string
+	// Selector matches the labels of namespaces, nil matches all
+	Selector labels.Selector
+
+	mu sync.RWMutex
+}
+
+// Update replaces the namespaces to match, e.g. after a configuration reload
+func (f *NamespaceFilter) Update(include, exclude []string, selector labels.Selector) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Include, f.Exclude, f.Selector = include, exclude, selector
+}
+
+// Matches reports whether objects of the namespace are reconciled
+func (f *NamespaceFilter) Matches(ctx context.Context, namespace string) (bool, error) {
+	if f == nil || namespace == "" {
+		return true, nil
+	}
+	f.mu.RLock()
+	include, exclude, selector := f.Include, f.Exclude, f.Selector
+	f.mu.RUnlock()
+	for _, excluded := range exclude {
+		if excluded == namespace {
+			return false, nil
+		}
+	}
+	if len(include) > 0 && !contains(include, namespace) {
+		return false, nil
+	}
+	if selector == nil || selector.Empty() {
+		return true, nil
+	}
+	var ns corev1.Namespace
+	if err := f.Client.Get(ctx, types.NamespacedName{Name: namespace}, &ns); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return selector.Matches(labels.Set(ns.Labels)), nil
+}
+
+// predicate drops events of objects in namespaces which do not match. Deletions always pass, so that
+// reconcilers clean up objects which left the filter. Cluster scoped objects always pass.
+func (f *NamespaceFilter) predicate() predicate.Funcs {
+	matches := func(namespace string) bool {
+		// lookup errors are retried by the reconciler
+		ok, err := f.Matches(context.Background(), namespace)
+		return ok || err != nil
+	}
+	return predicate.Funcs{
+		CreateFunc:  func(e event.CreateEvent) bool { return matches(e.Meta.GetNamespace()) },
+		UpdateFunc:  func(e event.UpdateEvent) bool { return matches(e.MetaNew.GetNamespace()) },
+		GenericFunc: func(e event.GenericEvent) bool { return matches(e.Meta.GetNamespace()) },
+	}
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
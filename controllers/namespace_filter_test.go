@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"context"
+	"sync"
+
+	"github.com/nirnanaaa/kube-readiness/pkg/cloud"
+	"github.com/nirnanaaa/kube-readiness/pkg/readiness"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var _ = Describe("Namespace Filter", func() {
+	var c client.Client
+	namespace := func(name string, labels map[string]string) *v1.Namespace {
+		return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+	BeforeEach(func() {
+		c = fake.NewFakeClientWithScheme(scheme.Scheme,
+			namespace("tenant-a", map[string]string{"readiness-gate": "enabled"}),
+			namespace("tenant-b", map[string]string{"readiness-gate": "enabled"}),
+			namespace("tenant-c", nil),
+		)
+	})
+
+	It("should match all namespaces without filter", func() {
+		var filter *NamespaceFilter
+		Expect(filter.Matches(context.TODO(), "tenant-c")).To(BeTrue())
+	})
+
+	It("should combine include, exclude and selector", func() {
+		filter := &NamespaceFilter{
+			Client:   c,
+			Include:  []string{"tenant-a", "tenant-b", "tenant-c"},
+			Exclude:  []string{"tenant-b"},
+			Selector: labels.SelectorFromSet(labels.Set{"readiness-gate": "enabled"}),
+		}
+		for namespace, expected := range map[string]bool{
+			"tenant-a": true,
+			"tenant-b": false, // excluded
+			"tenant-c": false, // not selected
+			"tenant-d": false, // not included
+			"":         true,  // cluster scoped
+		} {
+			Expect(filter.Matches(context.TODO(), namespace)).To(Equal(expected), namespace)
+		}
+		filter.Include = nil
+		Expect(filter.Matches(context.TODO(), "missing")).To(BeFalse())
+	})
+
+	It("should filter events but never deletions", func() {
+		filter := (&NamespaceFilter{Exclude: []string{"tenant-c"}}).predicate()
+		pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "tenant-c", Name: "pod"}}
+		Expect(filter.Create(event.CreateEvent{Meta: pod, Object: pod})).To(BeFalse())
+		Expect(filter.Update(event.UpdateEvent{MetaOld: pod, ObjectOld: pod, MetaNew: pod, ObjectNew: pod})).To(BeFalse())
+		Expect(filter.Generic(event.GenericEvent{Meta: pod, Object: pod})).To(BeFalse())
+		Expect(filter.Delete(event.DeleteEvent{Meta: pod, Object: pod})).To(BeTrue())
+		ns := namespace("tenant-c", nil)
+		Expect(filter.Update(event.UpdateEvent{MetaOld: ns, ObjectOld: ns, MetaNew: ns, ObjectNew: ns})).To(BeTrue())
+	})
+
+	It("should drop the services of an ingress once its namespace left the filter", func() {
+		ingressName := types.NamespacedName{Namespace: "tenant-a", Name: "ingress"}
+		Expect(c.Create(context.TODO(), &extensionsv1beta1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Namespace: ingressName.Namespace, Name: ingressName.Name},
+			Spec: extensionsv1beta1.IngressSpec{
+				Backend: &extensionsv1beta1.IngressBackend{ServiceName: "service", ServicePort: intstr.FromInt(80)},
+			},
+			Status: extensionsv1beta1.IngressStatus{LoadBalancer: v1.LoadBalancerStatus{
+				Ingress: []v1.LoadBalancerIngress{{Hostname: "lb-1234.eu-west-1.elb.amazonaws.com"}},
+			}},
+		})).To(Succeed())
+		services := readiness.ServiceInfoMap{}
+		reconciler := &IngressReconciler{
+			Client:              c,
+			Log:                 logf.NullLogger{},
+			EndpointGroupCache:  cloud.NewEndpointGroupCache(&cloud.Fake{}, 0, logf.NullLogger{}),
+			ServiceInfoMap:      services,
+			ServiceInfoMapMutex: new(sync.RWMutex),
+			Namespaces: &NamespaceFilter{
+				Client:   c,
+				Selector: labels.SelectorFromSet(labels.Set{"readiness-gate": "enabled"}),
+			},
+		}
+		_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: ingressName})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(services).To(HaveKey(types.NamespacedName{Namespace: "tenant-a", Name: "service"}))
+		Expect(reconciler.namespaceToIngresses(handler.MapObject{Meta: namespace("tenant-a", nil)})).To(ConsistOf(ctrl.Request{NamespacedName: ingressName}))
+
+		By("removing the namespace label")
+		Expect(c.Update(context.TODO(), namespace("tenant-a", nil))).To(Succeed())
+		_, err = reconciler.Reconcile(ctrl.Request{NamespacedName: ingressName})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(services).To(BeEmpty())
+	})
+})
@@ -0,0 +1,230 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nirnanaaa/kube-readiness/pkg/cloud"
+	"github.com/nirnanaaa/kube-readiness/pkg/readiness"
+	"github.com/nirnanaaa/kube-readiness/pkg/readiness/alb"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// pipelineIPs hands out a distinct pod ip per pod, the fake scripts health by ip
+var pipelineIPs = 0
+
+// pipeline creates the objects an ingress exposes a pod with, the reconcilers of the suite pick them up
+type pipeline struct {
+	name     types.NamespacedName
+	ip       string
+	hostname string
+	group    string
+	pod      *v1.Pod
+}
+
+func newPipeline() *pipeline {
+	pipelineIPs++
+	id := string(uuid.NewUUID())
+	return &pipeline{
+		name:     types.NamespacedName{Namespace: "default", Name: "app-" + id},
+		ip:       fmt.Sprintf("10.10.%d.%d", pipelineIPs/250, pipelineIPs%250),
+		hostname: "lb-" + id + ".eu-west-1.elb.amazonaws.com",
+		group:    "tg-" + id,
+	}
+}
+
+func (p *pipeline) createPod() {
+	p.pod = &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: p.name.Namespace, Name: p.name.Name},
+		Spec: v1.PodSpec{
+			Containers:     []v1.Container{{Name: "app", Image: "nginx", Ports: []v1.ContainerPort{{ContainerPort: 80}}}},
+			ReadinessGates: []v1.PodReadinessGate{{ConditionType: alb.ReadinessGate}},
+		},
+	}
+	Expect(k8sClient.Create(context.TODO(), p.pod)).To(Succeed())
+	Expect(patchPodStatus(p.pod, v1.PodStatus{PodIP: p.ip, Phase: v1.PodRunning})).To(Succeed())
+}
+
+func (p *pipeline) createService() {
+	Expect(k8sClient.Create(context.TODO(), &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: p.name.Namespace, Name: p.name.Name},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{{Port: 80, TargetPort: intstr.FromInt(80)}},
+		},
+	})).To(Succeed())
+	Expect(k8sClient.Create(context.TODO(), &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Namespace: p.name.Namespace, Name: p.name.Name},
+		Subsets: []v1.EndpointSubset{{
+			NotReadyAddresses: []v1.EndpointAddress{{
+				IP:        p.ip,
+				TargetRef: &v1.ObjectReference{Kind: "Pod", Namespace: p.name.Namespace, Name: p.name.Name, UID: p.pod.UID},
+			}},
+			Ports: []v1.EndpointPort{{Port: 80}},
+		}},
+	})).To(Succeed())
+}
+
+// createIngress exposes the service and reports the load balancer in its status, the way the
+// ingress controller does once the load balancer is provisioned
+func (p *pipeline) createIngress() {
+	ingress := &extensionsv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Namespace: p.name.Namespace, Name: p.name.Name},
+		Spec: extensionsv1beta1.IngressSpec{
+			Backend: &extensionsv1beta1.IngressBackend{ServiceName: p.name.Name, ServicePort: intstr.FromInt(80)},
+		},
+	}
+	Expect(k8sClient.Create(context.TODO(), ingress)).To(Succeed())
+	ingress.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{Hostname: p.hostname}}
+	Expect(k8sClient.Status().Update(context.TODO(), ingress)).To(Succeed())
+}
+
+func (p *pipeline) deleteIngress() {
+	Expect(k8sClient.Delete(context.TODO(), &extensionsv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Namespace: p.name.Namespace, Name: p.name.Name},
+	})).To(Succeed())
+}
+
+// gate returns the status of the readiness gate of the pod
+func (p *pipeline) gate() v1.ConditionStatus {
+	var pod v1.Pod
+	Expect(k8sClient.Get(context.TODO(), p.name, &pod)).To(Succeed())
+	condition, _ := readiness.ReadinessConditionStatus(&pod)
+	return condition.Status
+}
+
+// exposed reports whether the service is known to be exposed by a load balancer
+func (p *pipeline) exposed() bool {
+	serviceReconciler.ServiceInfoMapMutex.RLock()
+	defer serviceReconciler.ServiceInfoMapMutex.RUnlock()
+	_, ok := serviceInfoMap[p.name]
+	return ok
+}
+
+// tracked reports whether the pod is known by its endpoint
+func (p *pipeline) tracked() bool {
+	podReconciler.EndpointPodMutex.RLock()
+	defer podReconciler.EndpointPodMutex.RUnlock()
+	_, ok := endpointPodMap.Get(readiness.IngressEndpoint{IP: p.ip, Port: 80})
+	return ok
+}
+
+// checked reports whether the health of the pod was checked in the endpoint group of its load balancer
+func (p *pipeline) checked() bool {
+	for _, call := range cloudsdk.Calls("IsEndpointHealthy") {
+		if call.Endpoint == p.ip && len(call.Groups) == 1 && call.Groups[0] == p.group {
+			return true
+		}
+	}
+	return false
+}
+
+var _ = Describe("Ingress to Pod Pipeline", func() {
+	const timeout = time.Second * 10
+	const interval = time.Millisecond * 100
+	var p *pipeline
+
+	BeforeEach(func() {
+		p = newPipeline()
+		cloudsdk.AddLoadBalancer(p.hostname, &cloud.EndpointGroup{Name: p.group, TargetType: cloud.TargetTypeIP})
+		cloudsdk.SetHealth(p.ip, false)
+	})
+	AfterEach(func() {
+		k8sClient.Delete(context.TODO(), &extensionsv1beta1.Ingress{ObjectMeta: metav1.ObjectMeta{Namespace: p.name.Namespace, Name: p.name.Name}})
+		k8sClient.Delete(context.TODO(), &v1.Endpoints{ObjectMeta: metav1.ObjectMeta{Namespace: p.name.Namespace, Name: p.name.Name}})
+		k8sClient.Delete(context.TODO(), &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: p.name.Namespace, Name: p.name.Name}})
+		k8sClient.Delete(context.TODO(), &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: p.name.Namespace, Name: p.name.Name}})
+	})
+
+	It("should gate a pod until its target is healthy behind the load balancer of its ingress", func() {
+		p.createPod()
+		By("waiting for an ingress to expose the pod")
+		Eventually(p.gate, timeout, interval).Should(Equal(v1.ConditionUnknown))
+		Consistently(p.checked, time.Second, interval).Should(BeFalse())
+
+		p.createService()
+		p.createIngress()
+		By("checking the target in the endpoint group of the load balancer")
+		Eventually(p.gate, timeout, interval).Should(Equal(v1.ConditionFalse))
+		Expect(p.checked()).To(BeTrue())
+
+		By("marking the pod ready once the target passed its health checks")
+		cloudsdk.SetHealth(p.ip, true)
+		Eventually(p.gate, timeout, interval).Should(Equal(v1.ConditionTrue))
+	})
+
+	It("should resolve the pipeline regardless of the order the objects get created in", func() {
+		p.createIngress()
+		Eventually(p.exposed, timeout, interval).Should(BeTrue())
+		p.createPod()
+		p.createService()
+		Eventually(p.gate, timeout, interval).Should(Equal(v1.ConditionFalse))
+		cloudsdk.SetHealth(p.ip, true)
+		Eventually(p.gate, timeout, interval).Should(Equal(v1.ConditionTrue))
+	})
+
+	It("should drop the services of a deleted ingress", func() {
+		p.createPod()
+		p.createService()
+		p.createIngress()
+		Eventually(p.gate, timeout, interval).Should(Equal(v1.ConditionFalse))
+		Expect(p.exposed()).To(BeTrue())
+
+		p.deleteIngress()
+		Eventually(p.exposed, timeout, interval).Should(BeFalse())
+		By("resetting the gate of pods which are not exposed anymore")
+		Eventually(p.gate, timeout, interval).Should(Equal(v1.ConditionUnknown))
+	})
+
+	It("should forget deleted pods", func() {
+		p.createPod()
+		p.createService()
+		p.createIngress()
+		Eventually(p.tracked, timeout, interval).Should(BeTrue())
+		Eventually(p.gate, timeout, interval).Should(Equal(v1.ConditionFalse))
+
+		Expect(k8sClient.Delete(context.TODO(), p.pod)).To(Succeed())
+		Eventually(p.tracked, timeout, interval).Should(BeFalse())
+	})
+
+	It("should recover once the load balancer of an ingress resolves", func() {
+		cloudsdk.RemoveLoadBalancer(p.hostname)
+		cloudsdk.SetHealth(p.ip, true)
+		p.createPod()
+		p.createService()
+		p.createIngress()
+		Eventually(func() int {
+			var lookups int
+			for _, call := range cloudsdk.Calls("GetEndpointGroupsByHostname") {
+				if call.Hostname == p.hostname {
+					lookups++
+				}
+			}
+			return lookups
+		}, timeout, interval).Should(BeNumerically(">", 1))
+		Expect(p.exposed()).To(BeFalse())
+		Eventually(p.gate, timeout, interval).Should(Equal(v1.ConditionUnknown))
+
+		By("retrying the ingress until the load balancer is provisioned")
+		cloudsdk.AddLoadBalancer(p.hostname, &cloud.EndpointGroup{Name: p.group, TargetType: cloud.TargetTypeIP})
+		Eventually(p.gate, timeout, interval).Should(Equal(v1.ConditionTrue))
+		Expect(p.checked()).To(BeTrue())
+	})
+
+	It("should retry health checks the cloud provider failed", func() {
+		cloudsdk.SetHealth(p.ip, true)
+		cloudsdk.Fail("IsEndpointHealthy", errors.New("Throttling: Rate exceeded"), 3)
+		p.createPod()
+		p.createService()
+		p.createIngress()
+		Eventually(p.gate, timeout, interval).Should(Equal(v1.ConditionTrue))
+	})
+})
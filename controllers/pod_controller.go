@@ -19,18 +19,25 @@ package controllers
 import (
 	"context"
 	"errors"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	"github.com/nirnanaaa/kube-readiness/pkg/cloud"
 	"github.com/nirnanaaa/kube-readiness/pkg/readiness"
+	"github.com/nirnanaaa/kube-readiness/pkg/sharding"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
 )
 
 var (
@@ -40,63 +47,120 @@ var (
 // PodReconciler reconciles a Pod object
 type PodReconciler struct {
 	client.Client
+	managerContext
+	eventQueue
 	Log                 logr.Logger
 	CloudSDK            cloud.SDK
 	EndpointPodMutex    *sync.RWMutex
 	ServiceInfoMapMutex *sync.RWMutex
-	EndpointPodMap      readiness.EndpointPodMap
+	EndpointPodMap      *readiness.EndpointPodMap
 	ServiceInfoMap      readiness.ServiceInfoMap
+	// Warmup delays all reconciles until the state got rebuilt after becoming leader
+	Warmup *Warmup
+	// Namespaces restricts the pods whose readiness gate is maintained
+	Namespaces *NamespaceFilter
+	// MaxConcurrentReconciles is the number of pods reconciled in parallel, 20 if unset
+	MaxConcurrentReconciles int
+	// RateLimiter delays failed and requeued reconciles, the default rate limiter of the workqueue if unset
+	RateLimiter workqueue.RateLimiter
+	// Shard limits the health checks to the pods owned by this replica. All replicas keep the full state.
+	Shard *sharding.Coordinator
+	// DryRun records the readiness gate changes instead of patching the pods if set
+	DryRun *DryRun
 }
 
 // +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=pods/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
 func (r *PodReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	log := r.Log.WithValues("pod", req.NamespacedName)
-	ctx := context.Background()
+	ctx := r.Context()
+	if !r.Warmup.Done() {
+		log.V(4).Info("state is not rebuilt, yet")
+		return ctrl.Result{RequeueAfter: time.Second}, nil
+	}
 	namespacedName := req.NamespacedName
 	var pod corev1.Pod
 	if err := r.Get(ctx, namespacedName, &pod); err != nil {
-		return ctrl.Result{}, client.IgnoreNotFound(err)
+		if apierrors.IsNotFound(err) {
+			r.removePodMapEndpoint(namespacedName)
+			r.DryRun.Forget(namespacedName)
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
 	}
 	if pod.DeletionTimestamp != nil {
 		log.V(4).Info("pod is in deletion, not reconciling")
 		return ctrl.Result{}, nil
 	}
+	if ok, err := r.Namespaces.Matches(ctx, pod.Namespace); err != nil || !ok {
+		return ctrl.Result{}, err
+	}
 	if pod.Status.PodIP == "" {
 		return ctrl.Result{Requeue: true}, nil
 	}
 	r.writePodMapEndpoint(&pod, namespacedName)
-	if !readiness.ReadinessGateEnabled(&pod) {
+	if !r.Shard.Owns(&pod) {
+		// another replica checks the health of the pod
+		return ctrl.Result{}, nil
+	}
+	// in dry run pods are checked before they get the readiness gate, so that the gate can be rolled
+	// out once the reported changes look right
+	gated := readiness.ReadinessGateEnabled(&pod)
+	if !gated && !r.DryRun.Enabled() {
 		return ctrl.Result{}, nil
 	}
 
 	status, _ := readiness.ReadinessConditionStatus(&pod)
+	if !gated {
+		status.Status = r.DryRun.Status(&pod)
+	}
 
 	if status.Status == corev1.ConditionTrue {
 		return ctrl.Result{}, nil
 	}
-	r.ServiceInfoMapMutex.Lock()
-	defer r.ServiceInfoMapMutex.Unlock()
-	serviceInfo, err := r.ServiceInfoMap.GetServiceInfoForPod(req.NamespacedName)
+	// the service info is a copy, the lock must not be held during the calls into the cloud
+	r.ServiceInfoMapMutex.RLock()
+	serviceInfo, err := r.ServiceInfoMap.GetServiceInfoForPod(req.NamespacedName, pod.UID)
+	r.ServiceInfoMapMutex.RUnlock()
 	if err != nil {
+		if !gated {
+			// the pod is not behind a service exposed by an ingress
+			return ctrl.Result{}, nil
+		}
 		status.Status = corev1.ConditionUnknown
-		if err := readiness.PatchPodStatus(r, ctx, &pod, status); err != nil {
+		if err := r.patchGate(ctx, &pod, status); err != nil {
 			return ctrl.Result{}, err
 		}
 		return ctrl.Result{Requeue: true}, nil
 	}
 
-	ports := getContainerPortsForPod(&pod)
-	healthy, err := r.CloudSDK.IsEndpointHealthy(ctx, serviceInfo.Endpoints, pod.Status.PodIP, ports)
+	ipGroups, instanceGroups := splitEndpointGroups(serviceInfo.Endpoints)
+	healthy, err := r.isInstanceHealthy(ctx, &pod, serviceInfo.Ingresses)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
+	if healthy && (len(ipGroups) > 0 || len(instanceGroups) == 0) {
+		healthy, err = r.CloudSDK.IsEndpointHealthy(ctx, ipGroups, pod.Status.PodIP, getContainerPortsForPod(&pod))
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if healthy {
+		// a target reported healthy before it could have passed its health checks after its endpoint
+		// got first seen was registered for a previous pod with the same ip
+		if wait := minHealthyAge(ipGroups) - r.endpointAge(&pod); wait > 0 {
+			log.Info("target health predates the endpoint of the pod, waiting for fresh health checks", "wait", wait)
+			return ctrl.Result{RequeueAfter: wait}, nil
+		}
+	}
 	if !healthy {
 		log.Info("pod is not healthy, yet")
 		status.Status = corev1.ConditionFalse
 		status.LastProbeTime = metav1.Now()
-		if err := readiness.PatchPodStatus(r, ctx, &pod, status); err != nil {
+		if err := r.patchGate(ctx, &pod, status); err != nil {
 			return ctrl.Result{}, err
 		}
 		return ctrl.Result{Requeue: true}, nil
@@ -104,30 +168,192 @@ func (r *PodReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	log.Info("pod transitioned to state ready")
 	status.Status = corev1.ConditionTrue
 	status.LastTransitionTime = metav1.Now()
-	return ctrl.Result{}, readiness.PatchPodStatus(r, ctx, &pod, status)
+	return ctrl.Result{}, r.patchGate(ctx, &pod, status)
+}
+
+// patchGate sets the readiness gate of a pod, in dry run mode the change is only recorded
+func (r *PodReconciler) patchGate(ctx context.Context, pod *corev1.Pod, condition corev1.PodCondition) error {
+	if r.DryRun.Enabled() {
+		r.DryRun.SetGate(pod, condition)
+		return nil
+	}
+	return readiness.PatchPodStatus(r, ctx, pod, condition)
 }
 
 func (r *PodReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&corev1.Pod{}).
+		Watches(r.watchQueue()).
 		WithOptions(controller.Options{
-			MaxConcurrentReconciles: 20,
+			MaxConcurrentReconciles: r.maxConcurrentReconciles(),
 		}).
-		Complete(r)
+		WithEventFilter(r.Namespaces.predicate()).
+		WithEventFilter(predicate.Funcs{
+			UpdateFunc: r.invalidateOnStateChange,
+		}).
+		Complete(rateLimited("pod", r, r.Log, r.RateLimiter))
+}
+
+func (r *PodReconciler) maxConcurrentReconciles() int {
+	if r.MaxConcurrentReconciles == 0 {
+		return 20
+	}
+	return r.MaxConcurrentReconciles
+}
+
+// Enqueue requests a reconcile of the given pods
+func (r *PodReconciler) Enqueue(names ...types.NamespacedName) {
+	if r == nil {
+		return
+	}
+	r.enqueue(func(name types.NamespacedName) event.GenericEvent {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: name.Namespace, Name: name.Name}}
+		return event.GenericEvent{Meta: pod, Object: pod}
+	}, names...)
+}
+
+// EnqueueAll requests a reconcile of all pods, e.g. once the pods owned by this replica changed
+func (r *PodReconciler) EnqueueAll() error {
+	var pods corev1.PodList
+	if err := r.List(r.Context(), &pods); err != nil {
+		return err
+	}
+	names := make([]types.NamespacedName, 0, len(pods.Items))
+	for _, pod := range pods.Items {
+		names = append(names, types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name})
+	}
+	r.Enqueue(names...)
+	return nil
+}
+
+// invalidateOnStateChange drops cached target health of a pod whose state changed, so that the next
+// reconcile sees fresh data. It never filters any event.
+func (r *PodReconciler) invalidateOnStateChange(e event.UpdateEvent) bool {
+	oldPod, ok := e.ObjectOld.(*corev1.Pod)
+	if !ok {
+		return true
+	}
+	newPod, ok := e.ObjectNew.(*corev1.Pod)
+	if !ok {
+		return true
+	}
+	if podStateChanged(oldPod, newPod) && oldPod.Status.PodIP != "" {
+		r.CloudSDK.InvalidateEndpoint(oldPod.Status.PodIP)
+	}
+	return true
+}
+
+func podStateChanged(oldPod, newPod *corev1.Pod) bool {
+	if oldPod.Status.PodIP != newPod.Status.PodIP || oldPod.Status.Phase != newPod.Status.Phase {
+		return true
+	}
+	if (oldPod.DeletionTimestamp == nil) != (newPod.DeletionTimestamp == nil) {
+		return true
+	}
+	return podConditionStatus(oldPod, corev1.ContainersReady) != podConditionStatus(newPod, corev1.ContainersReady)
+}
+
+func podConditionStatus(pod *corev1.Pod, conditionType corev1.PodConditionType) corev1.ConditionStatus {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == conditionType {
+			return condition.Status
+		}
+	}
+	return corev1.ConditionUnknown
 }
 
 func (r *PodReconciler) writePodMapEndpoint(pod *corev1.Pod, namespacedName types.NamespacedName) {
-	r.EndpointPodMutex.Lock()
+	var endpoints []readiness.IngressEndpoint
 	for _, port := range getContainerPortsForPod(pod) {
-		endpoint := readiness.IngressEndpoint{
+		endpoints = append(endpoints, readiness.IngressEndpoint{
 			IP:   pod.Status.PodIP,
 			Port: port,
-		}
-		r.EndpointPodMap[endpoint] = namespacedName
+		})
 	}
+	r.EndpointPodMutex.Lock()
+	r.EndpointPodMap.SetPod(readiness.PodRef{Name: namespacedName, UID: pod.UID}, endpoints)
+	r.EndpointPodMutex.Unlock()
+}
+
+// endpointAge returns the time since the endpoints of a pod got first seen, pods without endpoints
+// are as old as the pod
+func (r *PodReconciler) endpointAge(pod *corev1.Pod) time.Duration {
+	r.EndpointPodMutex.RLock()
+	age, ok := r.EndpointPodMap.Age(types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name})
+	r.EndpointPodMutex.RUnlock()
+	if !ok {
+		return time.Since(pod.CreationTimestamp.Time)
+	}
+	return age
+}
+
+func (r *PodReconciler) removePodMapEndpoint(namespacedName types.NamespacedName) {
+	r.EndpointPodMutex.Lock()
+	r.EndpointPodMap.RemovePod(namespacedName)
 	r.EndpointPodMutex.Unlock()
 }
 
+// isInstanceHealthy checks the node of a pod in instance mode endpoint groups. The node forwards its
+// node ports to the pods of the service, so its health is the closest the load balancer gets to the pod.
+// The groups of every ingress are checked on the node ports of the service ports the ingress routes to.
+func (r *PodReconciler) isInstanceHealthy(ctx context.Context, pod *corev1.Pod, ingresses map[types.NamespacedName]readiness.IngressBackend) (bool, error) {
+	// check the ingresses in a stable order, the first unhealthy one ends the check
+	names := make([]types.NamespacedName, 0, len(ingresses))
+	for name := range ingresses {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i].String() < names[j].String() })
+	var instanceID string
+	for _, name := range names {
+		backend := ingresses[name]
+		_, groups := splitEndpointGroups(backend.Endpoints)
+		if len(groups) == 0 {
+			continue
+		}
+		if pod.Spec.NodeName == "" || len(backend.NodePorts) == 0 {
+			return false, nil
+		}
+		if instanceID == "" {
+			var node corev1.Node
+			if err := r.Get(ctx, types.NamespacedName{Name: pod.Spec.NodeName}, &node); err != nil {
+				return false, err
+			}
+			id, err := r.CloudSDK.InstanceID(node.Spec.ProviderID)
+			if err != nil {
+				return false, err
+			}
+			instanceID = id
+		}
+		healthy, err := r.CloudSDK.IsEndpointHealthy(ctx, groups, instanceID, backend.NodePorts)
+		if err != nil || !healthy {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// splitEndpointGroups separates ip mode from instance mode endpoint groups
+func splitEndpointGroups(groups []*cloud.EndpointGroup) (ipGroups, instanceGroups []*cloud.EndpointGroup) {
+	for _, group := range groups {
+		if group.IsInstance() {
+			instanceGroups = append(instanceGroups, group)
+		} else {
+			ipGroups = append(ipGroups, group)
+		}
+	}
+	return ipGroups, instanceGroups
+}
+
+func minHealthyAge(groups []*cloud.EndpointGroup) time.Duration {
+	var age time.Duration
+	for _, group := range groups {
+		if groupAge := group.MinHealthyAge(); groupAge > age {
+			age = groupAge
+		}
+	}
+	return age
+}
+
 func getContainerPortsForPod(pod *corev1.Pod) []int32 {
 	var ports []int32
 	for _, container := range pod.Spec.Containers {
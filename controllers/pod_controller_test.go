@@ -18,15 +18,6 @@ import (
 
 var dummyPod *v1.Pod
 
-var podStatus = v1.PodStatus{
-	Conditions: []v1.PodCondition{
-		{
-			Type:   alb.ReadinessGate,
-			Status: v1.ConditionUnknown,
-		},
-	},
-}
-
 func patchPodStatus(pod *v1.Pod, status v1.PodStatus) (err error) {
 	depPatch := client.MergeFrom(pod.DeepCopy())
 	pod.Status = status
@@ -86,7 +77,7 @@ var _ = Describe("Readiness Types", func() {
 		}
 	})
 	AfterEach(func() {
-		podReconciler.CloudSDK = &cloud.Fake{}
+		podReconciler.CloudSDK = cloudsdk
 		k8sClient.Get(context.TODO(), name, pod)
 		if pod != nil {
 			k8sClient.Delete(context.TODO(), pod)
@@ -178,10 +169,10 @@ var _ = Describe("Readiness Types", func() {
 				Endpoints: []*cloud.EndpointGroup{
 					&cloud.EndpointGroup{Name: "test"},
 				},
-				Pods: []types.NamespacedName{{
+				Pods: []readiness.PodRef{{Name: types.NamespacedName{
 					Namespace: "default",
 					Name:      podName,
-				}},
+				}}},
 			})
 			podReconciler.CloudSDK = &cloud.Fake{
 				Unhealthy: false,
@@ -208,10 +199,10 @@ var _ = Describe("Readiness Types", func() {
 				Endpoints: []*cloud.EndpointGroup{
 					&cloud.EndpointGroup{Name: "test1"},
 				},
-				Pods: []types.NamespacedName{{
+				Pods: []readiness.PodRef{{Name: types.NamespacedName{
 					Namespace: "default",
 					Name:      podName,
-				}},
+				}}},
 			})
 			podReconciler.CloudSDK = &cloud.Fake{
 				Unhealthy: true,
@@ -232,92 +223,5 @@ var _ = Describe("Readiness Types", func() {
 				return validConditions.Status
 			}, timeout, interval).Should(Equal(v1.ConditionFalse))
 		})
-		// It("should recheck periodically when a pod is not ready, yet", func() {
-
-		// var fetchedPod v1.Pod
-		// Eventually(func() error {
-		// 	return k8sClient.Get(context.TODO(), name, &fetchedPod)
-		// }, timeout, interval).ShouldNot(HaveOccurred())
-		// 	var expectedPod v1.Pod
-
-		// Eventually(func() v1.ConditionStatus {
-		// 	err := k8sClient.Get(context.TODO(), name, &expectedPod)
-		// 	Expect(err).To(BeNil())
-		// 	validConditions, _ := readiness.ReadinessConditionStatus(&expectedPod)
-		// 	return validConditions.Status
-		// }, timeout, interval).Should(Equal(v1.ConditionUnknown))
-
-		// })
-		// It("should have falsey health conditions for non-ready endpoints", func() {
-		// 	healthy := false
-		// 	podReconciler.CloudSDK = &cloud.Fake{Healthy: &healthy}
-		// 	var fetchedPod v1.Pod
-		// 	Eventually(func() error {
-		// 		return k8sClient.Get(context.TODO(), name, &fetchedPod)
-		// 	}, timeout, interval).ShouldNot(HaveOccurred())
-
-		// 	Eventually(func() string {
-		// 		err := k8sClient.Get(context.TODO(), name, &fetchedPod)
-		// 		Expect(err).NotTo(HaveOccurred())
-		// 		return fetchedPod.Status.PodIP
-		// 	}, timeout, interval).ShouldNot(BeEmpty())
-
-		// 	Eventually(func() error {
-		// 		err := k8sClient.Get(context.TODO(), name, &fetchedPod)
-		// 		Expect(err).NotTo(HaveOccurred())
-		// 		fetchedPod.Status = podStatus
-		// 		return k8sClient.Status().Update(context.TODO(), &fetchedPod)
-		// 	}, timeout, interval).ShouldNot(HaveOccurred())
-
-		// 	ingressSetting := ingressSet.Ensure(name)
-		// 	ingressSetting.IngressEndpoints.Insert(readiness.IngressEndpoint{
-		// 		IP:   fetchedPod.Status.PodIP,
-		// 		Port: 1234,
-		// 	})
-		// 	ingressSetting.LoadBalancer.Hostname = "test1234"
-		// 	var expectedPod v1.Pod
-
-		// 	Eventually(func() v1.ConditionStatus {
-		// 		err := k8sClient.Get(context.TODO(), name, &expectedPod)
-		// 		Expect(err).To(BeNil())
-		// 		validConditions, _ := readiness.ReadinessConditionStatus(&expectedPod)
-		// 		return validConditions.Status
-		// 	}, timeout, interval).Should(Equal(v1.ConditionFalse))
-
-		// })
-		// It("should re-evaluate an already ready pod", func() {
-		// 	var fetchedPod v1.Pod
-		// 	Eventually(func() error {
-		// 		return k8sClient.Get(context.TODO(), name, &fetchedPod)
-		// 	}, timeout, interval).ShouldNot(HaveOccurred())
-
-		// 	Eventually(func() string {
-		// 		err := k8sClient.Get(context.TODO(), name, &fetchedPod)
-		// 		Expect(err).NotTo(HaveOccurred())
-		// 		return fetchedPod.Status.PodIP
-		// 	}, timeout, interval).ShouldNot(BeEmpty())
-
-		// 	Eventually(func() error {
-		// 		err := k8sClient.Get(context.TODO(), name, &fetchedPod)
-		// 		Expect(err).NotTo(HaveOccurred())
-		// 		fetchedPod.Status = podStatus
-		// 		return k8sClient.Status().Update(context.TODO(), &fetchedPod)
-		// 	}, timeout, interval).ShouldNot(HaveOccurred())
-
-		// 	ingressSetting := ingressSet.Ensure(name)
-		// 	ingressSetting.IngressEndpoints.Insert(readiness.IngressEndpoint{
-		// 		IP:   fetchedPod.Status.PodIP,
-		// 		Port: 1234,
-		// 	})
-		// 	ingressSetting.LoadBalancer.Hostname = "test1234"
-		// 	var expectedPod v1.Pod
-
-		// 	Eventually(func() v1.ConditionStatus {
-		// 		err := k8sClient.Get(context.TODO(), name, &expectedPod)
-		// 		Expect(err).To(BeNil())
-		// 		validConditions, _ := readiness.ReadinessConditionStatus(&expectedPod)
-		// 		return validConditions.Status
-		// 	}, timeout, interval).Should(Equal(v1.ConditionTrue))
-		// })
 	})
 })
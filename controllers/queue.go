@@ -0,0 +1,101 @@
+/*
+Copyright 2019 Kube Readiness Maintainers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// eventQueue is embedded into reconcilers which other reconcilers enqueue requests into
+type eventQueue struct {
+	once   sync.Once
+	events chan event.GenericEvent
+
+	mu sync.Mutex
+	// overflow holds the events which did not fit into the channel, a single goroutine feeds them
+	// into the channel while the controller drains it
+	overflow map[types.NamespacedName]event.GenericEvent
+	flushing bool
+}
+
+func (q *eventQueue) channel() chan event.GenericEvent {
+	q.once.Do(func() {
+		q.events = make(chan event.GenericEvent, 1024)
+	})
+	return q.events
+}
+
+// watchQueue returns the source and handler the owning controller has to watch
+func (q *eventQueue) watchQueue() (source.Source, handler.EventHandler) {
+	return &source.Channel{Source: q.channel()}, &handler.EnqueueRequestForObject{}
+}
+
+// enqueue sends an event for each of the given names. newObject creates an empty object of the
+// reconciled kind carrying the name. It never blocks the caller, e.g. a watch handler or another
+// reconciler, once the channel is full or the controller is not running on this replica.
+func (q *eventQueue) enqueue(newObject func(types.NamespacedName) event.GenericEvent, names ...types.NamespacedName) {
+	seen := map[types.NamespacedName]bool{}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		e := newObject(name)
+		if !q.flushing {
+			select {
+			case q.channel() <- e:
+				continue
+			default:
+			}
+		}
+		if q.overflow == nil {
+			q.overflow = map[types.NamespacedName]event.GenericEvent{}
+		}
+		q.overflow[name] = e
+		if !q.flushing {
+			q.flushing = true
+			go q.flush()
+		}
+	}
+}
+
+// flush feeds the overflow into the channel until it is empty
+func (q *eventQueue) flush() {
+	for {
+		q.mu.Lock()
+		if len(q.overflow) == 0 {
+			q.flushing = false
+			q.mu.Unlock()
+			return
+		}
+		var name types.NamespacedName
+		var e event.GenericEvent
+		for name, e = range q.overflow {
+			break
+		}
+		delete(q.overflow, name)
+		q.mu.Unlock()
+		q.channel() <- e
+	}
+}
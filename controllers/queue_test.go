@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+var _ = Describe("Event Queue", func() {
+	var q *eventQueue
+	newEvent := func(name types.NamespacedName) event.GenericEvent {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: name.Namespace, Name: name.Name}}
+		return event.GenericEvent{Meta: pod, Object: pod}
+	}
+	names := func(count int) []types.NamespacedName {
+		var result []types.NamespacedName
+		for i := 0; i < count; i++ {
+			result = append(result, types.NamespacedName{Namespace: "default", Name: fmt.Sprintf("pod-%d", i)})
+		}
+		return result
+	}
+	// drain receives count events and returns how often each name was received
+	drain := func(count int) map[types.NamespacedName]int {
+		received := map[types.NamespacedName]int{}
+		for i := 0; i < count; i++ {
+			var e event.GenericEvent
+			Eventually(q.channel()).Should(Receive(&e))
+			received[types.NamespacedName{Namespace: e.Meta.GetNamespace(), Name: e.Meta.GetName()}]++
+		}
+		Consistently(q.channel(), "50ms").ShouldNot(Receive())
+		return received
+	}
+	BeforeEach(func() {
+		q = &eventQueue{}
+	})
+
+	It("should send each name once per call", func() {
+		pods := names(2)
+		q.enqueue(newEvent, pods[0], pods[1], pods[0])
+		Expect(drain(2)).To(Equal(map[types.NamespacedName]int{pods[0]: 1, pods[1]: 1}))
+	})
+
+	It("should not block once the channel is full", func() {
+		pods := names(cap(q.channel()) + 100)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			q.enqueue(newEvent, pods...)
+			// names which are waiting for the channel are not sent twice
+			q.enqueue(newEvent, pods[len(pods)-1])
+		}()
+		Eventually(done).Should(BeClosed())
+
+		received := drain(len(pods))
+		Expect(received).To(HaveLen(len(pods)))
+		for _, name := range pods {
+			Expect(received[name]).To(Equal(1), name.String())
+		}
+	})
+
+	It("should send names again once the overflow got drained", func() {
+		pods := names(cap(q.channel()) + 1)
+		q.enqueue(newEvent, pods...)
+		drain(len(pods))
+		q.enqueue(newEvent, pods[len(pods)-1])
+		Expect(drain(1)).To(Equal(map[types.NamespacedName]int{pods[len(pods)-1]: 1}))
+	})
+})
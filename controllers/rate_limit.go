@@ -0,0 +1,98 @@
+/*
+Copyright 2019 Kube Readiness Maintainers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+)
+
+// reconcileErrors counts the errors the rate limited reconcilers turn into delayed requeues, the
+// controllers do not see them and so do not count them in controller_runtime_reconcile_errors_total
+var reconcileErrors = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name:      "reconcile_errors_total",
+		Namespace: "rate_limited",
+		Help:      "Total number of reconciliation errors per controller which got requeued by the rate limiter",
+	},
+	[]string{"controller"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(reconcileErrors)
+}
+
+// NewRateLimiter returns a workqueue rate limiter which backs off exponentially per request from
+// baseDelay up to maxDelay and limits all retries of the controller to qps with bursts of burst.
+// Every controller needs its own rate limiter.
+func NewRateLimiter(baseDelay, maxDelay time.Duration, qps float64, burst int) workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(baseDelay, maxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(qps), burst)},
+	)
+}
+
+// rateLimitedReconciler delays failed and requeued reconciles by its own rate limiter. The
+// controller does not allow to replace the rate limiter of its workqueue, so the delay is returned
+// as RequeueAfter, which the workqueue applies as is.
+type rateLimitedReconciler struct {
+	reconcile.Reconciler
+	name    string
+	log     logr.Logger
+	limiter workqueue.RateLimiter
+}
+
+// rateLimited wraps the reconciler of the named controller if a rate limiter is given
+func rateLimited(name string, r reconcile.Reconciler, log logr.Logger, limiter workqueue.RateLimiter) reconcile.Reconciler {
+	if limiter == nil {
+		return r
+	}
+	return &rateLimitedReconciler{Reconciler: r, name: name, log: log, limiter: limiter}
+}
+
+func (r *rateLimitedReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	result, err := r.Reconciler.Reconcile(req)
+	switch {
+	case err != nil:
+		reconcileErrors.WithLabelValues(r.name).Inc()
+		r.log.Error(err, "Reconciler error", "controller", r.name, "request", req.NamespacedName)
+		return ctrl.Result{RequeueAfter: r.limiter.When(req)}, nil
+	case result.RequeueAfter > 0:
+		r.limiter.Forget(req)
+		return result, nil
+	case result.Requeue:
+		return ctrl.Result{RequeueAfter: r.limiter.When(req)}, nil
+	}
+	r.limiter.Forget(req)
+	return result, nil
+}
+
+// InjectStopChannel passes the stop channel of the manager on to the wrapped reconciler
+func (r *rateLimitedReconciler) InjectStopChannel(stop <-chan struct{}) error {
+	if s, ok := r.Reconciler.(inject.Stoppable); ok {
+		return s.InjectStopChannel(stop)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+)
+
+type resultReconciler struct {
+	managerContext
+	result ctrl.Result
+	err    error
+}
+
+func (r *resultReconciler) Reconcile(ctrl.Request) (ctrl.Result, error) {
+	return r.result, r.err
+}
+
+var _ = Describe("Rate limited reconciler", func() {
+	var (
+		inner *resultReconciler
+		r     reconcile.Reconciler
+	)
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "app"}}
+	BeforeEach(func() {
+		inner = &resultReconciler{}
+		r = rateLimited("test", inner, logf.Log, NewRateLimiter(10*time.Millisecond, 40*time.Millisecond, 100, 100))
+	})
+
+	It("should back off failed reconciles exponentially up to the max delay", func() {
+		inner.err = errors.New("cloud unavailable")
+		var delays []time.Duration
+		for i := 0; i < 4; i++ {
+			result, err := r.Reconcile(req)
+			Expect(err).ToNot(HaveOccurred())
+			delays = append(delays, result.RequeueAfter)
+		}
+		Expect(delays).To(Equal([]time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 40 * time.Millisecond}))
+	})
+
+	It("should count the errors it requeues", func() {
+		inner.err = errors.New("cloud unavailable")
+		before := testutil.ToFloat64(reconcileErrors.WithLabelValues("test"))
+		r.Reconcile(req)
+		r.Reconcile(req)
+		Expect(testutil.ToFloat64(reconcileErrors.WithLabelValues("test"))).To(Equal(before + 2))
+	})
+
+	It("should reset the backoff after a successful reconcile", func() {
+		inner.result = ctrl.Result{Requeue: true}
+		r.Reconcile(req)
+		r.Reconcile(req)
+		inner.result = ctrl.Result{}
+		Expect(r.Reconcile(req)).To(Equal(ctrl.Result{}))
+		inner.result = ctrl.Result{Requeue: true}
+		Expect(r.Reconcile(req)).To(Equal(ctrl.Result{RequeueAfter: 10 * time.Millisecond}))
+	})
+
+	It("should keep explicit delays", func() {
+		inner.result = ctrl.Result{RequeueAfter: time.Second}
+		Expect(r.Reconcile(req)).To(Equal(ctrl.Result{RequeueAfter: time.Second}))
+	})
+
+	It("should pass the stop channel on", func() {
+		stop := make(chan struct{})
+		Expect(r.(inject.Stoppable).InjectStopChannel(stop)).To(Succeed())
+		close(stop)
+		Eventually(inner.Context().Done()).Should(BeClosed())
+	})
+
+	It("should not wrap without rate limiter", func() {
+		Expect(rateLimited("test", inner, logf.Log, nil)).To(BeIdenticalTo(inner))
+	})
+})
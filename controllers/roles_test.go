@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"context"
+	"sync"
+
+	"github.com/nirnanaaa/kube-readiness/pkg/cloud"
+	"github.com/nirnanaaa/kube-readiness/pkg/readiness"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// roleSDK returns a single endpoint group carrying the role it was looked up with
+type roleSDK struct {
+	cloud.Fake
+}
+
+func (s *roleSDK) GetEndpointGroupsByHostname(ctx context.Context, hostname string) ([]*cloud.EndpointGroup, error) {
+	return []*cloud.EndpointGroup{{Name: hostname, Role: cloud.RoleFrom(ctx)}}, nil
+}
+
+var _ = Describe("Ingress Roles", func() {
+	const networkingRole = "arn:aws:iam::210987654321:role/readiness"
+	ingress := func(namespace string, annotations map[string]string) *extensionsv1beta1.Ingress {
+		return &extensionsv1beta1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "ingress", Annotations: annotations},
+			Spec: extensionsv1beta1.IngressSpec{
+				Backend: &extensionsv1beta1.IngressBackend{ServiceName: "service", ServicePort: intstr.FromInt(80)},
+			},
+			Status: extensionsv1beta1.IngressStatus{LoadBalancer: v1.LoadBalancerStatus{
+				Ingress: []v1.LoadBalancerIngress{{Hostname: "lb-1234.us-east-2.elb.amazonaws.com"}},
+			}},
+		}
+	}
+	var (
+		services   readiness.ServiceInfoMap
+		reconciler *IngressReconciler
+	)
+	BeforeEach(func() {
+		services = readiness.ServiceInfoMap{}
+		reconciler = &IngressReconciler{
+			Client: fake.NewFakeClientWithScheme(scheme.Scheme,
+				ingress("tenant-a", nil),
+				ingress("tenant-b", map[string]string{cloud.RoleAnnotation: "networking"}),
+				ingress("tenant-c", nil),
+				ingress("tenant-d", map[string]string{cloud.RoleAnnotation: "unknown"}),
+			),
+			Log:                 logf.NullLogger{},
+			EndpointGroupCache:  cloud.NewEndpointGroupCache(&roleSDK{}, 0, logf.NullLogger{}),
+			ServiceInfoMap:      services,
+			ServiceInfoMapMutex: new(sync.RWMutex),
+			Roles: &cloud.RoleMapping{
+				Roles:      map[string]string{"networking": networkingRole},
+				Namespaces: map[string]string{"tenant-a": "networking"},
+			},
+		}
+	})
+
+	It("should look up load balancers as the role of the namespace or annotation", func() {
+		for namespace, role := range map[string]string{"tenant-a": networkingRole, "tenant-b": networkingRole, "tenant-c": ""} {
+			_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: "ingress"}})
+			Expect(err).ToNot(HaveOccurred())
+			serviceInfo := services[types.NamespacedName{Namespace: namespace, Name: "service"}]
+			Expect(serviceInfo.Endpoints).To(HaveLen(1))
+			Expect(serviceInfo.Endpoints[0].Role).To(Equal(role), namespace)
+		}
+	})
+
+	It("should fail for roles which are not configured", func() {
+		_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "tenant-d", Name: "ingress"}})
+		Expect(err).To(MatchError(ContainSubstring("not configured")))
+	})
+})
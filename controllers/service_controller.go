@@ -24,9 +24,18 @@ import (
 	"github.com/go-logr/logr"
 	"github.com/nirnanaaa/kube-readiness/pkg/readiness"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	corev1 "k8s.io/api/core/v1"
 )
@@ -34,88 +43,280 @@ import (
 // ServiceReconciler reconciles a Service object
 type ServiceReconciler struct {
 	client.Client
+	managerContext
+	eventQueue
 	ServiceInfoMapMutex *sync.RWMutex
 	ServiceInfoMap      readiness.ServiceInfoMap
-	EndpointPodMap      readiness.EndpointPodMap
+	EndpointPodMap      *readiness.EndpointPodMap
 	Log                 logr.Logger
 	Lock                *sync.RWMutex
+	PodReconciler       *PodReconciler
+	// Namespaces restricts the services whose pods are tracked
+	Namespaces *NamespaceFilter
+	// EndpointSlices resolves pods from EndpointSlices instead of Endpoints if the cluster serves them
+	EndpointSlices bool
+	// MaxConcurrentReconciles is the number of services reconciled in parallel, 1 if unset
+	MaxConcurrentReconciles int
+	// RateLimiter delays failed and requeued reconciles, the default rate limiter of the workqueue if unset
+	RateLimiter workqueue.RateLimiter
+
+	endpointSliceKind *schema.GroupVersionKind
+	// endpointSliceReader lists the endpoint slices, the client if unset
+	endpointSliceReader client.Reader
 }
 
 // +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=services/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=core,resources=endpoints,verbs=get;list;watch
+// +kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=get;list;watch
 
 func (r *ServiceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
-	ctx := context.Background()
+	ctx := r.Context()
 	_ = r.Log.WithValues("service", req.NamespacedName)
 	var service corev1.Service
 	if err := r.Get(ctx, req.NamespacedName, &service); err != nil {
 		if apierrors.IsNotFound(err) {
-			r.ServiceInfoMapMutex.Lock()
-			defer r.ServiceInfoMapMutex.Unlock()
-			r.ServiceInfoMap.Remove(req.NamespacedName)
+			r.removeService(req.NamespacedName)
 			return ctrl.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
 		return ctrl.Result{}, err
 	}
-	var endpoints corev1.Endpoints
-	if err := r.Get(ctx, req.NamespacedName, &endpoints); err != nil {
-		return ctrl.Result{}, client.IgnoreNotFound(err)
+	if ok, err := r.Namespaces.Matches(ctx, service.Namespace); err != nil {
+		return ctrl.Result{}, err
+	} else if !ok {
+		r.removeService(req.NamespacedName)
+		return ctrl.Result{}, nil
+	}
+	pods, err := r.getPods(ctx, req.NamespacedName)
+	if err != nil {
+		return ctrl.Result{}, err
 	}
-	// get pods for service
-	pods := r.getPodsForService(&endpoints)
 	r.ServiceInfoMapMutex.Lock()
-	defer r.ServiceInfoMapMutex.Unlock()
 	serviceInfo, ok := r.ServiceInfoMap[req.NamespacedName]
 	if !ok {
-		return ctrl.Result{Requeue: true}, nil
+		// the service is not exposed by any ingress, yet. The ingress controller enqueues it once it is.
+		r.ServiceInfoMapMutex.Unlock()
+		return ctrl.Result{}, nil
 	}
+	previousPods := serviceInfo.Pods
 	serviceInfo.Pods = pods
-
+	serviceInfo.ResolveNodePorts(func(port intstr.IntOrString) (int32, bool) {
+		return getNodePort(&service, port)
+	})
 	r.ServiceInfoMap.Add(req.NamespacedName, serviceInfo)
+	r.ServiceInfoMapMutex.Unlock()
 
+	r.PodReconciler.Enqueue(readiness.PodNames(append(previousPods, pods...))...)
 	return ctrl.Result{}, nil
 }
 
+// removeService drops the pods of a service and enqueues them. The service stays exposed by its
+// ingresses, which own the entry, so that a service created after its ingress gets picked up.
+func (r *ServiceReconciler) removeService(name types.NamespacedName) {
+	r.ServiceInfoMapMutex.Lock()
+	serviceInfo, ok := r.ServiceInfoMap[name]
+	if ok {
+		r.ServiceInfoMap.Add(name, readiness.IngressInfo{
+			Name:      serviceInfo.Name,
+			Endpoints: serviceInfo.Endpoints,
+			Ingresses: serviceInfo.Ingresses,
+		})
+	}
+	r.ServiceInfoMapMutex.Unlock()
+	r.PodReconciler.Enqueue(readiness.PodNames(serviceInfo.Pods)...)
+}
+
 func (r *ServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	builder := ctrl.NewControllerManagedBy(mgr).
 		For(&corev1.Service{}).
-		Complete(r)
+		Watches(r.watchQueue()).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.MaxConcurrentReconciles,
+		}).
+		WithEventFilter(r.Namespaces.predicate())
+	r.setupEndpointSlices(mgr.GetRESTMapper(), mgr.GetCache())
+	if r.endpointSliceKind != nil {
+		r.Log.Info("resolving pods from endpoint slices", "version", r.endpointSliceKind.GroupVersion())
+		builder = builder.Watches(&source.Kind{Type: newEndpointSliceObject(*r.endpointSliceKind)}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(endpointSliceToService),
+		})
+	} else {
+		builder = builder.Watches(&source.Kind{Type: &corev1.Endpoints{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(endpointsToService),
+		})
+	}
+	return builder.Complete(rateLimited("service", r, r.Log, r.RateLimiter))
 }
 
-func (r *ServiceReconciler) getPodsForService(endpoints *corev1.Endpoints) []types.NamespacedName {
-	r.Lock.RLock()
-	defer r.Lock.RUnlock()
-	var podNames []types.NamespacedName
+// setupEndpointSlices selects the EndpointSlice version to resolve pods from, if endpoint slices are
+// enabled and served by the cluster. The slices are unstructured, which the client of the manager
+// reads from the api server directly. They are read from the informer cache instead, the watch of the
+// slices keeps it in sync.
+func (r *ServiceReconciler) setupEndpointSlices(mapper meta.RESTMapper, cache client.Reader) {
+	if !r.EndpointSlices {
+		return
+	}
+	gvk, ok := servedEndpointSliceKind(mapper)
+	if !ok {
+		r.Log.Info("endpoint slices are not served by the cluster, falling back to endpoints")
+		return
+	}
+	r.endpointSliceKind = &gvk
+	r.endpointSliceReader = cache
+}
+
+// endpointsToService maps endpoints to the service of the same name
+func endpointsToService(obj handler.MapObject) []ctrl.Request {
+	return []ctrl.Request{{
+		NamespacedName: types.NamespacedName{
+			Namespace: obj.Meta.GetNamespace(),
+			Name:      obj.Meta.GetName(),
+		},
+	}}
+}
+
+// Enqueue requests a reconcile of the given services
+func (r *ServiceReconciler) Enqueue(names ...types.NamespacedName) {
+	if r == nil {
+		return
+	}
+	r.enqueue(func(name types.NamespacedName) event.GenericEvent {
+		service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: name.Namespace, Name: name.Name}}
+		return event.GenericEvent{Meta: service, Object: service}
+	}, names...)
+}
+
+// getNodePort resolves a port of a service, referred to by number or name, to its node port
+func getNodePort(service *corev1.Service, servicePort intstr.IntOrString) (int32, bool) {
+	for _, port := range service.Spec.Ports {
+		if port.NodePort == 0 {
+			continue
+		}
+		if servicePort.Type == intstr.String && port.Name == servicePort.StrVal ||
+			servicePort.Type == intstr.Int && port.Port == servicePort.IntVal {
+			return port.NodePort, true
+		}
+	}
+	return 0, false
+}
+
+// getPods resolves the pods of a service, a service without endpoints does not have any
+func (r *ServiceReconciler) getPods(ctx context.Context, name types.NamespacedName) ([]readiness.PodRef, error) {
+	if r.endpointSliceKind != nil {
+		reader := r.endpointSliceReader
+		if reader == nil {
+			reader = r.Client
+		}
+		list := newEndpointSliceList(*r.endpointSliceKind)
+		if err := reader.List(ctx, list, client.InNamespace(name.Namespace), client.MatchingLabels{serviceNameLabel: name.Name}); err != nil {
+			return nil, err
+		}
+		slices, err := endpointSlicesFromList(list)
+		if err != nil {
+			return nil, err
+		}
+		return r.getPodsForEndpointSlices(name.Namespace, slices), nil
+	}
+	var endpoints corev1.Endpoints
+	if err := r.Get(ctx, name, &endpoints); client.IgnoreNotFound(err) != nil {
+		return nil, err
+	}
+	return r.getPodsForService(&endpoints), nil
+}
+
+// getPodsForService resolves the pods behind the endpoints of a service. Addresses refer to their pod
+// directly, only addresses without a pod reference are looked up by ip and port.
+func (r *ServiceReconciler) getPodsForService(endpoints *corev1.Endpoints) []readiness.PodRef {
+	seen := map[readiness.PodRef]bool{}
+	var pods []readiness.PodRef
+	add := func(pod readiness.PodRef) {
+		if !seen[pod] {
+			seen[pod] = true
+			pods = append(pods, pod)
+		}
+	}
 	for _, endpointSubset := range endpoints.Subsets {
-		for _, port := range endpointSubset.Ports {
-			for _, address := range endpointSubset.NotReadyAddresses {
-				name, err := getPodFromEndpointMap(r.EndpointPodMap, address, port)
-				if err != nil {
-					continue
-				}
-				podNames = append(podNames, name)
+		addresses := append(append([]corev1.EndpointAddress{}, endpointSubset.NotReadyAddresses...), endpointSubset.Addresses...)
+		for _, address := range addresses {
+			if name, ok := podFromTargetRef(endpoints.Namespace, address.TargetRef); ok {
+				add(name)
+				continue
 			}
-			for _, address := range endpointSubset.Addresses {
-				name, err := getPodFromEndpointMap(r.EndpointPodMap, address, port)
+			for _, port := range endpointSubset.Ports {
+				name, err := r.getPodFromEndpointMap(address, port)
 				if err != nil {
 					continue
 				}
-				podNames = append(podNames, name)
+				add(name)
+			}
+		}
+	}
+	return pods
+}
 
+// getPodsForEndpointSlices resolves the pods behind the endpoint slices of a service the same way
+// getPodsForService does for endpoints. Terminating endpoints which stopped serving are skipped.
+func (r *ServiceReconciler) getPodsForEndpointSlices(namespace string, slices []endpointSlice) []readiness.PodRef {
+	seen := map[readiness.PodRef]bool{}
+	var pods []readiness.PodRef
+	add := func(pod readiness.PodRef) {
+		if !seen[pod] {
+			seen[pod] = true
+			pods = append(pods, pod)
+		}
+	}
+	for _, slice := range slices {
+		for _, endpoint := range slice.Endpoints {
+			if !endpoint.managed() {
+				continue
+			}
+			if name, ok := podFromTargetRef(namespace, endpoint.TargetRef); ok {
+				add(name)
+				continue
+			}
+			for _, ip := range endpoint.Addresses {
+				for _, port := range slice.Ports {
+					if port.Port == nil {
+						continue
+					}
+					name, err := r.getPodFromEndpointMap(corev1.EndpointAddress{IP: ip}, corev1.EndpointPort{Port: *port.Port})
+					if err != nil {
+						continue
+					}
+					add(name)
+				}
 			}
 		}
 	}
-	return podNames
+	return pods
+}
+
+// podFromTargetRef returns the pod an endpoint address refers to. The UID of the reference tells a
+// pod apart from a previous one of the same name whose endpoint was not removed, yet.
+func podFromTargetRef(namespace string, ref *corev1.ObjectReference) (readiness.PodRef, bool) {
+	if ref == nil || ref.Kind != "Pod" || ref.Name == "" {
+		return readiness.PodRef{}, false
+	}
+	if ref.Namespace != "" {
+		namespace = ref.Namespace
+	}
+	return readiness.PodRef{Name: types.NamespacedName{Namespace: namespace, Name: ref.Name}, UID: ref.UID}, true
+}
+
+func (r *ServiceReconciler) getPodFromEndpointMap(address corev1.EndpointAddress, port corev1.EndpointPort) (readiness.PodRef, error) {
+	r.Lock.RLock()
+	defer r.Lock.RUnlock()
+	return getPodFromEndpointMap(r.EndpointPodMap, address, port)
 }
 
-func getPodFromEndpointMap(endpointMap readiness.EndpointPodMap, address corev1.EndpointAddress, port corev1.EndpointPort) (podName types.NamespacedName, err error) {
+func getPodFromEndpointMap(endpointMap *readiness.EndpointPodMap, address corev1.EndpointAddress, port corev1.EndpointPort) (readiness.PodRef, error) {
 	endpoint := readiness.IngressEndpoint{
 		IP:   address.IP,
 		Port: port.Port,
 	}
-	if pod, ok := endpointMap[endpoint]; ok {
+	if pod, ok := endpointMap.Get(endpoint); ok {
 		return pod, nil
 	}
-	return types.NamespacedName{}, errors.New("no pod for service found")
+	return readiness.PodRef{}, errors.New("no pod for service found")
 }
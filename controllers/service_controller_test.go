@@ -0,0 +1,288 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/nirnanaaa/kube-readiness/pkg/readiness"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var _ = Describe("Service Controller", func() {
+	serviceName := types.NamespacedName{Namespace: "default", Name: "service"}
+	var (
+		endpointPods *readiness.EndpointPodMap
+		services     readiness.ServiceInfoMap
+		reconciler   *ServiceReconciler
+	)
+	newReconciler := func(endpoints *v1.Endpoints) *ServiceReconciler {
+		service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: serviceName.Namespace, Name: serviceName.Name}}
+		return &ServiceReconciler{
+			Client:              fake.NewFakeClientWithScheme(scheme.Scheme, service, endpoints),
+			Log:                 logf.NullLogger{},
+			EndpointPodMap:      endpointPods,
+			Lock:                new(sync.RWMutex),
+			ServiceInfoMap:      services,
+			ServiceInfoMapMutex: new(sync.RWMutex),
+		}
+	}
+	BeforeEach(func() {
+		endpointPods = readiness.NewEndpointPodMap()
+		services = readiness.ServiceInfoMap{serviceName: readiness.IngressInfo{Name: "lb"}}
+	})
+
+	It("should resolve pods from the endpoint target references", func() {
+		reconciler = newReconciler(&v1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Namespace: serviceName.Namespace, Name: serviceName.Name},
+			Subsets: []v1.EndpointSubset{{
+				Addresses: []v1.EndpointAddress{
+					{IP: "10.0.0.1", TargetRef: &v1.ObjectReference{Kind: "Pod", Name: "ready"}},
+				},
+				NotReadyAddresses: []v1.EndpointAddress{
+					{IP: "10.0.0.2", TargetRef: &v1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "not-ready"}},
+				},
+				Ports: []v1.EndpointPort{{Port: 80}, {Port: 8080}},
+			}},
+		})
+		_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: serviceName})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(readiness.PodNames(services[serviceName].Pods)).To(ConsistOf(
+			types.NamespacedName{Namespace: "default", Name: "ready"},
+			types.NamespacedName{Namespace: "default", Name: "not-ready"},
+		))
+	})
+
+	It("should fall back to the endpoint map for addresses without target reference", func() {
+		pod := readiness.PodRef{Name: types.NamespacedName{Namespace: "default", Name: "manual"}}
+		endpointPods.SetPod(pod, []readiness.IngressEndpoint{{IP: "10.0.0.3", Port: 80}})
+		reconciler = newReconciler(&v1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Namespace: serviceName.Namespace, Name: serviceName.Name},
+			Subsets: []v1.EndpointSubset{{
+				Addresses: []v1.EndpointAddress{{IP: "10.0.0.3"}, {IP: "10.0.0.4"}},
+				Ports:     []v1.EndpointPort{{Port: 80}},
+			}},
+		})
+		_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: serviceName})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(readiness.PodNames(services[serviceName].Pods)).To(ConsistOf(pod.Name))
+	})
+
+	It("should resolve the node ports of the service ports the ingresses route to", func() {
+		info := services[serviceName]
+		info.SetIngress(types.NamespacedName{Namespace: "default", Name: "web"}, readiness.IngressBackend{
+			ServicePorts: []intstr.IntOrString{intstr.FromInt(80)},
+		})
+		info.SetIngress(types.NamespacedName{Namespace: "default", Name: "admin"}, readiness.IngressBackend{
+			ServicePorts: []intstr.IntOrString{intstr.FromString("admin"), intstr.FromString("missing")},
+		})
+		services[serviceName] = info
+		reconciler = newReconciler(&v1.Endpoints{ObjectMeta: metav1.ObjectMeta{Namespace: serviceName.Namespace, Name: serviceName.Name}})
+		var service v1.Service
+		Expect(reconciler.Get(context.TODO(), serviceName, &service)).To(Succeed())
+		service.Spec.Ports = []v1.ServicePort{
+			{Name: "http", Port: 80, NodePort: 30080},
+			{Name: "admin", Port: 9090, NodePort: 30090},
+			{Name: "metrics", Port: 9100, NodePort: 30100},
+		}
+		Expect(reconciler.Update(context.TODO(), &service)).To(Succeed())
+
+		_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: serviceName})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(services[serviceName].Ingresses[types.NamespacedName{Namespace: "default", Name: "web"}].NodePorts).To(Equal([]int32{30080}))
+		Expect(services[serviceName].Ingresses[types.NamespacedName{Namespace: "default", Name: "admin"}].NodePorts).To(Equal([]int32{30090}))
+	})
+
+	It("should keep a service exposed by an ingress while the service does not exist", func() {
+		reconciler = newReconciler(&v1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Namespace: serviceName.Namespace, Name: serviceName.Name},
+			Subsets: []v1.EndpointSubset{{
+				Addresses: []v1.EndpointAddress{{IP: "10.0.0.1", TargetRef: &v1.ObjectReference{Kind: "Pod", Name: "ready"}}},
+				Ports:     []v1.EndpointPort{{Port: 80}},
+			}},
+		})
+		_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: serviceName})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(services[serviceName].Pods).To(HaveLen(1))
+
+		Expect(reconciler.Delete(context.TODO(), &v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: serviceName.Namespace, Name: serviceName.Name}})).To(Succeed())
+		_, err = reconciler.Reconcile(ctrl.Request{NamespacedName: serviceName})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(services).To(HaveKey(serviceName))
+		Expect(services[serviceName].Name).To(Equal("lb"))
+		Expect(services[serviceName].Pods).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Service Controller with endpoint slices", func() {
+	var (
+		endpointPods *readiness.EndpointPodMap
+		reconciler   *ServiceReconciler
+	)
+	boolPtr := func(b bool) *bool { return &b }
+	BeforeEach(func() {
+		endpointPods = readiness.NewEndpointPodMap()
+		reconciler = &ServiceReconciler{
+			Log:            logf.NullLogger{},
+			EndpointPodMap: endpointPods,
+			Lock:           new(sync.RWMutex),
+		}
+	})
+
+	It("should decode slices and honor their conditions", func() {
+		list := &unstructured.UnstructuredList{Items: []unstructured.Unstructured{{Object: map[string]interface{}{
+			"apiVersion": "discovery.k8s.io/v1",
+			"kind":       "EndpointSlice",
+			"metadata": map[string]interface{}{
+				"namespace": "default",
+				"name":      "service-abcde",
+				"labels":    map[string]interface{}{serviceNameLabel: "service"},
+			},
+			"endpoints": []interface{}{
+				map[string]interface{}{
+					"addresses":  []interface{}{"10.0.0.1"},
+					"conditions": map[string]interface{}{"ready": true},
+					"targetRef":  map[string]interface{}{"kind": "Pod", "name": "ready"},
+				},
+				map[string]interface{}{
+					"addresses":  []interface{}{"10.0.0.2"},
+					"conditions": map[string]interface{}{"ready": false, "serving": false, "terminating": false},
+					"targetRef":  map[string]interface{}{"kind": "Pod", "name": "starting"},
+				},
+				map[string]interface{}{
+					"addresses":  []interface{}{"10.0.0.3"},
+					"conditions": map[string]interface{}{"ready": false, "serving": true, "terminating": true},
+					"targetRef":  map[string]interface{}{"kind": "Pod", "name": "draining"},
+				},
+				map[string]interface{}{
+					"addresses":  []interface{}{"10.0.0.4"},
+					"conditions": map[string]interface{}{"ready": false, "serving": false, "terminating": true},
+					"targetRef":  map[string]interface{}{"kind": "Pod", "name": "gone"},
+				},
+			},
+			"ports": []interface{}{map[string]interface{}{"port": int64(80)}},
+		}}}}
+		slices, err := endpointSlicesFromList(list)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(slices).To(HaveLen(1))
+		Expect(*slices[0].Ports[0].Port).To(Equal(int32(80)))
+		Expect(readiness.PodNames(reconciler.getPodsForEndpointSlices("default", slices))).To(ConsistOf(
+			types.NamespacedName{Namespace: "default", Name: "ready"},
+			types.NamespacedName{Namespace: "default", Name: "starting"},
+			types.NamespacedName{Namespace: "default", Name: "draining"},
+		))
+	})
+
+	It("should treat missing conditions like the v1beta1 defaults", func() {
+		slices := []endpointSlice{{Endpoints: []sliceEndpoint{
+			{TargetRef: &v1.ObjectReference{Kind: "Pod", Name: "unknown"}},
+			{Conditions: sliceConditions{Ready: boolPtr(false), Terminating: boolPtr(true)}, TargetRef: &v1.ObjectReference{Kind: "Pod", Name: "terminating"}},
+		}}}
+		Expect(readiness.PodNames(reconciler.getPodsForEndpointSlices("default", slices))).To(ConsistOf(
+			types.NamespacedName{Namespace: "default", Name: "unknown"},
+		))
+	})
+
+	It("should fall back to the endpoint map for endpoints without target reference", func() {
+		pod := readiness.PodRef{Name: types.NamespacedName{Namespace: "default", Name: "manual"}}
+		endpointPods.SetPod(pod, []readiness.IngressEndpoint{{IP: "10.0.0.5", Port: 8080}})
+		port := int32(8080)
+		slices := []endpointSlice{
+			{Endpoints: []sliceEndpoint{{Addresses: []string{"10.0.0.5", "10.0.0.6"}}}, Ports: []slicePort{{Port: &port}, {}}},
+			{Endpoints: []sliceEndpoint{{Addresses: []string{"10.0.0.5"}}}, Ports: []slicePort{{Port: &port}}},
+		}
+		Expect(readiness.PodNames(reconciler.getPodsForEndpointSlices("default", slices))).To(Equal([]types.NamespacedName{pod.Name}))
+	})
+
+	It("should fall back to endpoints if the cluster does not serve endpoint slices", func() {
+		serviceName := types.NamespacedName{Namespace: "default", Name: "service"}
+		services := readiness.ServiceInfoMap{serviceName: readiness.IngressInfo{Name: "lb"}}
+		reconciler.Client = fake.NewFakeClientWithScheme(scheme.Scheme,
+			&v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: serviceName.Namespace, Name: serviceName.Name}},
+			&v1.Endpoints{
+				ObjectMeta: metav1.ObjectMeta{Namespace: serviceName.Namespace, Name: serviceName.Name},
+				Subsets: []v1.EndpointSubset{{
+					Addresses: []v1.EndpointAddress{{IP: "10.0.0.1", TargetRef: &v1.ObjectReference{Kind: "Pod", Name: "ready"}}},
+					Ports:     []v1.EndpointPort{{Port: 80}},
+				}},
+			},
+		)
+		reconciler.ServiceInfoMap = services
+		reconciler.ServiceInfoMapMutex = new(sync.RWMutex)
+		reconciler.EndpointSlices = true
+		mapper := meta.NewDefaultRESTMapper(nil)
+		mapper.Add(v1.SchemeGroupVersion.WithKind("Endpoints"), meta.RESTScopeNamespace)
+		reconciler.setupEndpointSlices(mapper, &sliceReader{})
+		Expect(reconciler.endpointSliceKind).To(BeNil())
+
+		_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: serviceName})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(readiness.PodNames(services[serviceName].Pods)).To(ConsistOf(types.NamespacedName{Namespace: "default", Name: "ready"}))
+	})
+
+	It("should list the slices from the cache", func() {
+		serviceName := types.NamespacedName{Namespace: "default", Name: "service"}
+		services := readiness.ServiceInfoMap{serviceName: readiness.IngressInfo{Name: "lb"}}
+		reconciler.Client = fake.NewFakeClientWithScheme(scheme.Scheme,
+			&v1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: serviceName.Namespace, Name: serviceName.Name}},
+		)
+		reconciler.ServiceInfoMap = services
+		reconciler.ServiceInfoMapMutex = new(sync.RWMutex)
+		reconciler.EndpointSlices = true
+		mapper := meta.NewDefaultRESTMapper(nil)
+		mapper.Add(schema.GroupVersionKind{Group: "discovery.k8s.io", Version: "v1beta1", Kind: "EndpointSlice"}, meta.RESTScopeNamespace)
+		cache := &sliceReader{items: []unstructured.Unstructured{{Object: map[string]interface{}{
+			"metadata": map[string]interface{}{"namespace": "default", "name": "service-abcde"},
+			"endpoints": []interface{}{map[string]interface{}{
+				"addresses": []interface{}{"10.0.0.1"},
+				"targetRef": map[string]interface{}{"kind": "Pod", "name": "ready"},
+			}},
+		}}}}
+		reconciler.setupEndpointSlices(mapper, cache)
+		Expect(reconciler.endpointSliceKind.Version).To(Equal("v1beta1"))
+
+		_, err := reconciler.Reconcile(ctrl.Request{NamespacedName: serviceName})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cache.lists).To(Equal(1))
+		Expect(readiness.PodNames(services[serviceName].Pods)).To(ConsistOf(types.NamespacedName{Namespace: "default", Name: "ready"}))
+	})
+
+	It("should map slices to the service they belong to", func() {
+		slice := &metav1.ObjectMeta{Namespace: "default", Name: "service-abcde", Labels: map[string]string{serviceNameLabel: "service"}}
+		Expect(endpointSliceToService(handler.MapObject{Meta: slice})).To(Equal([]ctrl.Request{{
+			NamespacedName: types.NamespacedName{Namespace: "default", Name: "service"},
+		}}))
+		Expect(endpointSliceToService(handler.MapObject{Meta: &metav1.ObjectMeta{Namespace: "default", Name: "orphan"}})).To(BeEmpty())
+	})
+})
+
+// sliceReader serves endpoint slices the way the informer cache does
+type sliceReader struct {
+	items []unstructured.Unstructured
+	lists int
+}
+
+func (r *sliceReader) Get(context.Context, client.ObjectKey, runtime.Object) error {
+	return errors.New("not implemented")
+}
+
+func (r *sliceReader) List(_ context.Context, list runtime.Object, _ ...client.ListOption) error {
+	r.lists++
+	list.(*unstructured.UnstructuredList).Items = r.items
+	return nil
+}
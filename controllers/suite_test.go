@@ -48,12 +48,12 @@ var cfg *rest.Config
 var k8sClient client.Client
 var k8sManager ctrl.Manager
 var testEnv *envtest.Environment
-var endpointPodMap readiness.EndpointPodMap
+var endpointPodMap *readiness.EndpointPodMap
 var serviceInfoMap readiness.ServiceInfoMap
 var cloudsdk *cloud.Fake
+var endpointGroupCache *cloud.EndpointGroupCache
 var podReconciler *PodReconciler
 var serviceReconciler *ServiceReconciler
-var endpointsReconciler *EndpointsReconciler
 var ingressReconciler *IngressReconciler
 var responseDataMap map[string]bool
 
@@ -69,7 +69,7 @@ var _ = BeforeSuite(func(done Done) {
 	logf.SetLogger(zap.LoggerTo(GinkgoWriter, true))
 
 	cloudsdk = &cloud.Fake{}
-	endpointPodMap = make(readiness.EndpointPodMap)
+	endpointPodMap = readiness.NewEndpointPodMap()
 	serviceInfoMap = make(readiness.ServiceInfoMap)
 
 	By("bootstrapping test environment")
@@ -108,6 +108,19 @@ var _ = BeforeSuite(func(done Done) {
 	k8sClient = k8sManager.GetClient() //.New(cfg, client.Options{Scheme: scheme.Scheme})
 	Expect(k8sClient).ToNot(BeNil())
 
+	podReconciler = &PodReconciler{
+		Client:              k8sClient,
+		Log:                 ctrl.Log.WithName("controllers").WithName("PodScope"),
+		CloudSDK:            cloudsdk,
+		EndpointPodMap:      endpointPodMap,
+		EndpointPodMutex:    endpointLock,
+		ServiceInfoMap:      serviceInfoMap,
+		ServiceInfoMapMutex: serviceLock,
+		RateLimiter:         NewRateLimiter(5*time.Millisecond, 500*time.Millisecond, 100, 100),
+	}
+	err = (podReconciler).SetupWithManager(k8sManager)
+	Expect(err).ToNot(HaveOccurred())
+
 	serviceReconciler = &ServiceReconciler{
 		Client:              k8sClient,
 		Log:                 ctrl.Log.WithName("controllers").WithName("ServiceScope"),
@@ -115,39 +128,38 @@ var _ = BeforeSuite(func(done Done) {
 		Lock:                endpointLock,
 		ServiceInfoMap:      serviceInfoMap,
 		ServiceInfoMapMutex: serviceLock,
+		PodReconciler:       podReconciler,
+		RateLimiter:         NewRateLimiter(5*time.Millisecond, 500*time.Millisecond, 100, 100),
 	}
 	err = (serviceReconciler).SetupWithManager(k8sManager)
-
 	Expect(err).ToNot(HaveOccurred())
 
-	endpointsReconciler = &EndpointsReconciler{
-		Client:            k8sClient,
-		Log:               ctrl.Log.WithName("controllers").WithName("ServiceScope"),
-		ServiceReconciler: serviceReconciler,
-	}
-	err = (endpointsReconciler).SetupWithManager(k8sManager)
+	endpointGroupCache = cloud.NewEndpointGroupCache(cloudsdk, 500*time.Millisecond, ctrl.Log.WithName("cloud"))
+	err = k8sManager.Add(endpointGroupCache)
 	Expect(err).ToNot(HaveOccurred())
+
 	ingressReconciler = &IngressReconciler{
 		Client:              k8sClient,
-		Log:                 ctrl.Log.WithName("controllers").WithName("ServiceScope"),
-		CloudSDK:            cloudsdk,
+		Log:                 ctrl.Log.WithName("controllers").WithName("IngressScope"),
+		EndpointGroupCache:  endpointGroupCache,
 		ServiceInfoMap:      serviceInfoMap,
 		ServiceInfoMapMutex: serviceLock,
 		ServiceReconciler:   serviceReconciler,
+		PodReconciler:       podReconciler,
+		RateLimiter:         NewRateLimiter(5*time.Millisecond, 500*time.Millisecond, 100, 100),
 	}
 	err = (ingressReconciler).SetupWithManager(k8sManager)
 	Expect(err).ToNot(HaveOccurred())
-	podReconciler = &PodReconciler{
-		Client:              k8sClient,
-		Log:                 ctrl.Log.WithName("controllers").WithName("PodScope"),
-		CloudSDK:            cloudsdk,
-		EndpointPodMap:      endpointPodMap,
-		EndpointPodMutex:    new(sync.RWMutex),
-		ServiceInfoMap:      serviceInfoMap,
-		ServiceInfoMapMutex: serviceLock,
-	}
-	err = (podReconciler).SetupWithManager(k8sManager)
 
+	podReconciler.Warmup = &Warmup{
+		Client:            k8sClient,
+		Log:               ctrl.Log.WithName("controllers").WithName("Warmup"),
+		IngressReconciler: ingressReconciler,
+		ServiceReconciler: serviceReconciler,
+		PodReconciler:     podReconciler,
+		RetryInterval:     100 * time.Millisecond,
+	}
+	err = k8sManager.Add(podReconciler.Warmup)
 	Expect(err).ToNot(HaveOccurred())
 
 	go func() {
@@ -0,0 +1,189 @@
+/*
+Copyright 2019 Kube Readiness Maintainers.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var warmupReconcileErrors = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name:      "reconcile_errors_total",
+		Namespace: "warmup",
+		Help:      "Total number of reconciles which failed while rebuilding the state and were left to the workqueue by kind",
+	},
+	[]string{"kind"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(warmupReconcileErrors)
+}
+
+// Warmup rebuilds the in-memory state from the informer caches once the manager became leader.
+// Reconciles arriving in arbitrary order after a leadership handover would otherwise find pods
+// whose service is not known, yet, and mark them Unknown. The pod reconciler does not patch any
+// pod before the warm-up is done.
+type Warmup struct {
+	client.Client
+	managerContext
+	Log               logr.Logger
+	IngressReconciler *IngressReconciler
+	ServiceReconciler *ServiceReconciler
+	PodReconciler     *PodReconciler
+	// RetryInterval is the time between two attempts of a failed rebuild
+	RetryInterval time.Duration
+
+	once    sync.Once
+	done    chan struct{}
+	elected chan struct{}
+}
+
+func (w *Warmup) init() {
+	w.once.Do(func() {
+		w.done = make(chan struct{})
+		w.elected = make(chan struct{})
+	})
+}
+
+func (w *Warmup) doneChannel() chan struct{} {
+	w.init()
+	return w.done
+}
+
+// Elected reports whether the manager acquired the leadership, the reconcilers only run on the leader.
+// A missing warm-up is always elected.
+func (w *Warmup) Elected() bool {
+	if w == nil {
+		return true
+	}
+	w.init()
+	select {
+	case <-w.elected:
+		return true
+	default:
+		return false
+	}
+}
+
+// Done reports whether the state got rebuilt. A missing warm-up is always done.
+func (w *Warmup) Done() bool {
+	if w == nil {
+		return true
+	}
+	select {
+	case <-w.doneChannel():
+		return true
+	default:
+		return false
+	}
+}
+
+// Start rebuilds the state and retries until it succeeds. It is started by the manager once the
+// caches are synced and the leadership is acquired.
+func (w *Warmup) Start(stop <-chan struct{}) error {
+	w.init()
+	close(w.elected)
+	interval := w.RetryInterval
+	if interval == 0 {
+		interval = 5 * time.Second
+	}
+	start := time.Now()
+	err := wait.PollImmediateUntil(interval, func() (bool, error) {
+		if err := w.rebuild(w.Context()); err != nil {
+			w.Log.Error(err, "unable to rebuild state, retrying", "interval", interval)
+			return false, nil
+		}
+		return true, nil
+	}, stop)
+	if err != nil {
+		// stopped before the state got rebuilt
+		return nil
+	}
+	w.Log.Info("state rebuilt", "duration", time.Since(start))
+	close(w.doneChannel())
+	<-stop
+	return nil
+}
+
+// rebuild replays the reconciles the state consists of in dependency order: pods for the endpoint
+// map, ingresses for the services they expose, services for the pods they consist of. Failed
+// reconciles of single ingresses or services, e.g. of a load balancer which does not exist anymore,
+// are left to the rate limited workqueue of their controller, only failed lists abort the rebuild.
+func (w *Warmup) rebuild(ctx context.Context) error {
+	var pods corev1.PodList
+	if err := w.List(ctx, &pods); err != nil {
+		return err
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.PodIP == "" || pod.DeletionTimestamp != nil {
+			continue
+		}
+		name := types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+		// like the pod reconciler all replicas track the pods of all shards, but only of matching namespaces
+		if ok, err := w.PodReconciler.Namespaces.Matches(ctx, pod.Namespace); err != nil {
+			w.failed("pod", name, err)
+			w.PodReconciler.Enqueue(name)
+			continue
+		} else if !ok {
+			continue
+		}
+		w.PodReconciler.writePodMapEndpoint(pod, name)
+	}
+	var ingresses extensionsv1beta1.IngressList
+	if err := w.List(ctx, &ingresses); err != nil {
+		return err
+	}
+	for _, ingress := range ingresses.Items {
+		name := types.NamespacedName{Namespace: ingress.Namespace, Name: ingress.Name}
+		if _, err := w.IngressReconciler.Reconcile(ctrl.Request{NamespacedName: name}); err != nil {
+			w.failed("ingress", name, err)
+			w.IngressReconciler.Enqueue(name)
+		}
+	}
+	w.ServiceReconciler.ServiceInfoMapMutex.RLock()
+	services := make([]types.NamespacedName, 0, len(w.ServiceReconciler.ServiceInfoMap))
+	for name := range w.ServiceReconciler.ServiceInfoMap {
+		services = append(services, name)
+	}
+	w.ServiceReconciler.ServiceInfoMapMutex.RUnlock()
+	for _, name := range services {
+		if _, err := w.ServiceReconciler.Reconcile(ctrl.Request{NamespacedName: name}); err != nil {
+			w.failed("service", name, err)
+			w.ServiceReconciler.Enqueue(name)
+		}
+	}
+	return nil
+}
+
+// failed logs and counts a reconcile which failed during the rebuild
+func (w *Warmup) failed(kind string, name types.NamespacedName, err error) {
+	warmupReconcileErrors.WithLabelValues(kind).Inc()
+	w.Log.Error(err, "unable to rebuild state, leaving it to the workqueue", "kind", kind, "name", name)
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// app serves greetings and lets tests control how it behaves through its admin api
+type app struct {
+	mu sync.Mutex
+	// healthy is the answer of /health, the liveness and readiness probe
+	healthy bool
+	// ready overrides the answer of /readiness, the load balancer health check, if set
+	ready *bool
+	// latency delays every greeting
+	latency time.Duration
+	// errorRate is the share of greetings answered with errorStatus
+	errorRate   float64
+	errorStatus int
+	// readyAt is the time /readiness succeeds from, to simulate a slow start
+	readyAt time.Time
+
+	terminated     bool
+	signalReceived time.Time
+	counters       counters
+	now            func() time.Time
+}
+
+// counters of the requests an app served
+type counters struct {
+	RequestsBeforeTermination uint64 `json:"requestsBeforeTermination"`
+	RequestsAfterTermination  uint64 `json:"requestsAfterTermination"`
+	// LastRequestAfterTermination is the time between the signal and the last request in seconds
+	LastRequestAfterTermination float64        `json:"lastRequestAfterTermination"`
+	Responses                   map[int]uint64 `json:"responses"`
+	HealthChecks                map[int]uint64 `json:"healthChecks"`
+	ReadinessChecks             map[int]uint64 `json:"readinessChecks"`
+}
+
+// state of an app as reported by the admin api
+type state struct {
+	Healthy     bool     `json:"healthy"`
+	Ready       *bool    `json:"ready"`
+	Latency     string   `json:"latency"`
+	ErrorRate   float64  `json:"errorRate"`
+	ErrorStatus int      `json:"errorStatus"`
+	SlowStart   string   `json:"slowStart"`
+	Terminated  bool     `json:"terminated"`
+	Counters    counters `json:"counters"`
+}
+
+func newApp(slowStart time.Duration) *app {
+	a := &app{now: time.Now}
+	a.reset()
+	if slowStart > 0 {
+		a.readyAt = a.now().Add(slowStart)
+	}
+	return a
+}
+
+// reset restores the behaviour of a fresh app, the counters and the termination are kept
+func (a *app) reset() {
+	a.healthy = true
+	a.ready = nil
+	a.latency = 0
+	a.errorRate = 0
+	a.errorStatus = http.StatusInternalServerError
+	a.readyAt = time.Time{}
+	if a.counters.Responses == nil {
+		a.counters = counters{Responses: map[int]uint64{}, HealthChecks: map[int]uint64{}, ReadinessChecks: map[int]uint64{}}
+	}
+}
+
+// terminate makes the readiness check fail, the app keeps serving requests until it shuts down
+func (a *app) terminate() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.terminated = true
+	a.signalReceived = a.now()
+}
+
+func (a *app) router() *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/", a.handler)
+	r.HandleFunc("/health", a.healthHandler)
+	r.HandleFunc("/readiness", a.readinessHandler)
+	r.HandleFunc("/metrics", a.metricsHandler).Methods(http.MethodGet)
+
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.HandleFunc("/state", a.stateHandler).Methods(http.MethodGet)
+	admin.HandleFunc("/counters", a.countersHandler).Methods(http.MethodGet)
+	admin.HandleFunc("/health", a.setHealthHandler).Methods(http.MethodPost).Queries("healthy", "{healthy}")
+	admin.HandleFunc("/readiness", a.setReadinessHandler).Methods(http.MethodPost).Queries("ready", "{ready}")
+	admin.HandleFunc("/latency", a.setLatencyHandler).Methods(http.MethodPost).Queries("duration", "{duration}")
+	admin.HandleFunc("/errors", a.setErrorsHandler).Methods(http.MethodPost).Queries("rate", "{rate}")
+	admin.HandleFunc("/slow-start", a.setSlowStartHandler).Methods(http.MethodPost).Queries("duration", "{duration}")
+	admin.HandleFunc("/reset", a.resetHandler).Methods(http.MethodPost)
+	return r
+}
+
+func (a *app) handler(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	if !a.terminated {
+		a.counters.RequestsBeforeTermination++
+	} else {
+		a.counters.RequestsAfterTermination++
+		if since := a.now().Sub(a.signalReceived).Seconds(); since > a.counters.LastRequestAfterTermination {
+			a.counters.LastRequestAfterTermination = since
+		}
+	}
+	latency, status := a.latency, http.StatusOK
+	if a.errorRate > 0 && rand.Float64() < a.errorRate {
+		status = a.errorStatus
+	}
+	a.counters.Responses[status]++
+	a.mu.Unlock()
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	if status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = "Guest"
+	}
+	w.Write([]byte(fmt.Sprintf("Hello, %s\n ", name)))
+}
+
+func (a *app) healthHandler(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	status := http.StatusOK
+	if !a.healthy {
+		status = http.StatusServiceUnavailable
+	}
+	a.counters.HealthChecks[status]++
+	a.mu.Unlock()
+	w.WriteHeader(status)
+}
+
+func (a *app) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	ready := !a.terminated && !a.now().Before(a.readyAt)
+	if a.ready != nil {
+		ready = *a.ready
+	}
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	a.counters.ReadinessChecks[status]++
+	a.mu.Unlock()
+	w.WriteHeader(status)
+}
+
+func (a *app) stateHandler(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	s := state{
+		Healthy:     a.healthy,
+		Ready:       a.ready,
+		Latency:     a.latency.String(),
+		ErrorRate:   a.errorRate,
+		ErrorStatus: a.errorStatus,
+		SlowStart:   "0s",
+		Terminated:  a.terminated,
+		Counters:    a.counters.copy(),
+	}
+	if remaining := a.readyAt.Sub(a.now()); remaining > 0 {
+		s.SlowStart = remaining.String()
+	}
+	a.mu.Unlock()
+	writeJSON(w, s)
+}
+
+func (a *app) countersHandler(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	c := a.counters.copy()
+	a.mu.Unlock()
+	writeJSON(w, c)
+}
+
+func (a *app) setHealthHandler(w http.ResponseWriter, r *http.Request) {
+	healthy, err := strconv.ParseBool(mux.Vars(r)["healthy"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.mu.Lock()
+	a.healthy = healthy
+	a.mu.Unlock()
+	a.stateHandler(w, r)
+}
+
+// setReadinessHandler overrides the readiness check, auto lets it follow the slow start and termination again
+func (a *app) setReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	var ready *bool
+	if value := mux.Vars(r)["ready"]; value != "auto" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ready = &parsed
+	}
+	a.mu.Lock()
+	a.ready = ready
+	a.mu.Unlock()
+	a.stateHandler(w, r)
+}
+
+func (a *app) setLatencyHandler(w http.ResponseWriter, r *http.Request) {
+	latency, err := time.ParseDuration(mux.Vars(r)["duration"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.mu.Lock()
+	a.latency = latency
+	a.mu.Unlock()
+	a.stateHandler(w, r)
+}
+
+// setErrorsHandler answers a share of the greetings with an error, 500 unless the status is given
+func (a *app) setErrorsHandler(w http.ResponseWriter, r *http.Request) {
+	rate, err := strconv.ParseFloat(mux.Vars(r)["rate"], 64)
+	if err != nil || rate < 0 || rate > 1 {
+		http.Error(w, "rate has to be between 0 and 1", http.StatusBadRequest)
+		return
+	}
+	status := http.StatusInternalServerError
+	if value := r.URL.Query().Get("status"); value != "" {
+		status, err = strconv.Atoi(value)
+		if err != nil || status < 400 || status > 599 {
+			http.Error(w, "status has to be an error status", http.StatusBadRequest)
+			return
+		}
+	}
+	a.mu.Lock()
+	a.errorRate = rate
+	a.errorStatus = status
+	a.mu.Unlock()
+	a.stateHandler(w, r)
+}
+
+// setSlowStartHandler fails the readiness check for the duration from now on
+func (a *app) setSlowStartHandler(w http.ResponseWriter, r *http.Request) {
+	duration, err := time.ParseDuration(mux.Vars(r)["duration"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.mu.Lock()
+	a.readyAt = a.now().Add(duration)
+	a.mu.Unlock()
+	a.stateHandler(w, r)
+}
+
+func (a *app) resetHandler(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	a.reset()
+	a.mu.Unlock()
+	a.stateHandler(w, r)
+}
+
+// metricsHandler exposes the counters in the prometheus text format
+func (a *app) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	c := a.counters.copy()
+	healthy, terminated := a.healthy, a.terminated
+	a.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP app_requests_total Greetings served by termination phase.")
+	fmt.Fprintln(w, "# TYPE app_requests_total counter")
+	fmt.Fprintf(w, "app_requests_total{phase=\"before_termination\"} %d\n", c.RequestsBeforeTermination)
+	fmt.Fprintf(w, "app_requests_total{phase=\"after_termination\"} %d\n", c.RequestsAfterTermination)
+	writeStatusCounter(w, "app_responses_total", "Greetings served by status code.", c.Responses)
+	writeStatusCounter(w, "app_health_checks_total", "Health checks by status code.", c.HealthChecks)
+	writeStatusCounter(w, "app_readiness_checks_total", "Readiness checks by status code.", c.ReadinessChecks)
+	fmt.Fprintln(w, "# HELP app_last_request_after_termination_seconds Time between the termination signal and the last greeting.")
+	fmt.Fprintln(w, "# TYPE app_last_request_after_termination_seconds gauge")
+	fmt.Fprintf(w, "app_last_request_after_termination_seconds %g\n", c.LastRequestAfterTermination)
+	fmt.Fprintln(w, "# HELP app_healthy Whether the health check succeeds.")
+	fmt.Fprintln(w, "# TYPE app_healthy gauge")
+	fmt.Fprintf(w, "app_healthy %d\n", boolValue(healthy))
+	fmt.Fprintln(w, "# HELP app_terminated Whether the termination signal got received.")
+	fmt.Fprintln(w, "# TYPE app_terminated gauge")
+	fmt.Fprintf(w, "app_terminated %d\n", boolValue(terminated))
+}
+
+func writeStatusCounter(w http.ResponseWriter, name, help string, values map[int]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	codes := make([]int, 0, len(values))
+	for code := range values {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "%s{code=\"%d\"} %d\n", name, code, values[code])
+	}
+}
+
+func (c counters) copy() counters {
+	result := c
+	result.Responses, result.HealthChecks, result.ReadinessChecks = copyCounts(c.Responses), copyCounts(c.HealthChecks), copyCounts(c.ReadinessChecks)
+	return result
+}
+
+func copyCounts(counts map[int]uint64) map[int]uint64 {
+	result := make(map[int]uint64, len(counts))
+	for code, count := range counts {
+		result[code] = count
+	}
+	return result
+}
+
+func boolValue(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("App", func() {
+	var (
+		a      *app
+		now    time.Time
+		server *httptest.Server
+	)
+	BeforeEach(func() {
+		now = time.Now()
+		a = newApp(0)
+		a.now = func() time.Time { return now }
+		server = httptest.NewServer(a.router())
+	})
+	AfterEach(func() {
+		server.Close()
+	})
+
+	request := func(method, path string) (int, string) {
+		req, err := http.NewRequest(method, server.URL+path, nil)
+		Expect(err).ToNot(HaveOccurred())
+		response, err := http.DefaultClient.Do(req)
+		Expect(err).ToNot(HaveOccurred())
+		defer response.Body.Close()
+		body, err := ioutil.ReadAll(response.Body)
+		Expect(err).ToNot(HaveOccurred())
+		return response.StatusCode, string(body)
+	}
+	status := func(path string) int {
+		code, _ := request(http.MethodGet, path)
+		return code
+	}
+	admin := func(path string) state {
+		code, body := request(http.MethodPost, "/admin"+path)
+		Expect(code).To(Equal(http.StatusOK), body)
+		var s state
+		Expect(json.Unmarshal([]byte(body), &s)).To(Succeed())
+		return s
+	}
+
+	It("should greet and pass its checks by default", func() {
+		code, body := request(http.MethodGet, "/?name=Kube")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(ContainSubstring("Hello, Kube"))
+		Expect(status("/health")).To(Equal(http.StatusOK))
+		Expect(status("/readiness")).To(Equal(http.StatusOK))
+	})
+
+	It("should toggle the health check", func() {
+		Expect(admin("/health?healthy=false").Healthy).To(BeFalse())
+		Expect(status("/health")).To(Equal(http.StatusServiceUnavailable))
+		Expect(status("/readiness")).To(Equal(http.StatusOK))
+		admin("/health?healthy=true")
+		Expect(status("/health")).To(Equal(http.StatusOK))
+	})
+
+	It("should override the readiness check until it is set to auto", func() {
+		admin("/readiness?ready=false")
+		Expect(status("/readiness")).To(Equal(http.StatusServiceUnavailable))
+		admin("/readiness?ready=true")
+		a.terminate()
+		Expect(status("/readiness")).To(Equal(http.StatusOK))
+		Expect(admin("/readiness?ready=auto").Ready).To(BeNil())
+		Expect(status("/readiness")).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("should fail the readiness check during a slow start", func() {
+		Expect(admin("/slow-start?duration=30s").SlowStart).To(Equal("30s"))
+		Expect(status("/readiness")).To(Equal(http.StatusServiceUnavailable))
+		now = now.Add(30 * time.Second)
+		Expect(status("/readiness")).To(Equal(http.StatusOK))
+	})
+
+	It("should answer greetings with the injected errors", func() {
+		Expect(admin("/errors?rate=1&status=503").ErrorStatus).To(Equal(http.StatusServiceUnavailable))
+		Expect(status("/")).To(Equal(http.StatusServiceUnavailable))
+		admin("/errors?rate=0")
+		Expect(status("/")).To(Equal(http.StatusOK))
+		code, _ := request(http.MethodPost, "/admin/errors?rate=2")
+		Expect(code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("should delay greetings by the injected latency", func() {
+		admin("/latency?duration=50ms")
+		start := time.Now()
+		Expect(status("/")).To(Equal(http.StatusOK))
+		Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+	})
+
+	It("should restore the default behaviour on reset but keep the counters", func() {
+		admin("/health?healthy=false")
+		admin("/errors?rate=1")
+		status("/")
+		s := admin("/reset")
+		Expect(s.Healthy).To(BeTrue())
+		Expect(s.ErrorRate).To(BeZero())
+		Expect(s.Counters.Responses).To(Equal(map[int]uint64{http.StatusInternalServerError: 1}))
+	})
+
+	It("should count the requests around the termination", func() {
+		status("/")
+		status("/health")
+		a.terminate()
+		now = now.Add(2 * time.Second)
+		status("/")
+		status("/readiness")
+
+		code, body := request(http.MethodGet, "/admin/counters")
+		Expect(code).To(Equal(http.StatusOK))
+		var c counters
+		Expect(json.Unmarshal([]byte(body), &c)).To(Succeed())
+		Expect(c).To(Equal(counters{
+			RequestsBeforeTermination:   1,
+			RequestsAfterTermination:    1,
+			LastRequestAfterTermination: 2,
+			Responses:                   map[int]uint64{http.StatusOK: 2},
+			HealthChecks:                map[int]uint64{http.StatusOK: 1},
+			ReadinessChecks:             map[int]uint64{http.StatusServiceUnavailable: 1},
+		}))
+
+		code, body = request(http.MethodGet, "/metrics")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body).To(ContainSubstring(`app_requests_total{phase="after_termination"} 1`))
+		Expect(body).To(ContainSubstring(`app_responses_total{code="200"} 2`))
+		Expect(body).To(ContainSubstring(`app_readiness_checks_total{code="503"} 1`))
+		Expect(body).To(ContainSubstring("app_last_request_after_termination_seconds 2\n"))
+		Expect(body).To(ContainSubstring("app_terminated 1\n"))
+	})
+})
@@ -1,82 +1,55 @@
+// Command kube-readiness-app is the test app of the e2e tests. It greets on /, answers the
+// liveness and readiness probes on /health and the load balancer health check on /readiness,
+// which fails once a termination signal got received. The app keeps serving for the term delay
+// after the signal.
+//
+// Tests control its behaviour through the admin api:
+//
+//	GET  /admin/state                         current behaviour and counters
+//	GET  /admin/counters                      counters of served requests and checks
+//	POST /admin/health?healthy=false          fail /health
+//	POST /admin/readiness?ready=false         fail /readiness, true to pass it, auto to follow slow start and termination
+//	POST /admin/latency?duration=200ms        delay every greeting
+//	POST /admin/errors?rate=0.5&status=503    answer a share of the greetings with an error
+//	POST /admin/slow-start?duration=30s       fail /readiness for the duration from now on
+//	POST /admin/reset                         restore the default behaviour, counters are kept
+//
+// The counters are exposed in the prometheus format on /metrics as well.
 package main
 
 import (
 	"context"
 	"flag"
-	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
-	"sync"
-	"sync/atomic"
 	"syscall"
 	"time"
-
-	"github.com/gorilla/mux"
-)
-
-var (
-	termDelay                 *int
-	terminated                bool
-	signalReceived            time.Time
-	lastRequest               time.Duration
-	requestsBeforeTermination uint64
-	requestsAfterTermination  uint64
-	mu                        sync.Mutex
 )
 
-func handler(w http.ResponseWriter, r *http.Request) {
-
-	if !terminated {
-		atomic.AddUint64(&requestsBeforeTermination, 1)
-	} else {
-		atomic.AddUint64(&requestsAfterTermination, 1)
-		since := time.Since(signalReceived)
-		if since > lastRequest {
-			mu.Lock()
-			lastRequest = since
-			mu.Unlock()
-		}
-	}
-
-	query := r.URL.Query()
-	name := query.Get("name")
-	if name == "" {
-		name = "Guest"
-	}
-	// log.Printf("Received request for %s (%d/%d)\n", name, requestsBeforeTermination, requestsAfterTermination)
-	w.Write([]byte(fmt.Sprintf("Hello, %s\n ", name)))
-
-}
-
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-}
-
-func readinessHandler(w http.ResponseWriter, r *http.Request) {
-	if terminated {
-		w.WriteHeader(http.StatusServiceUnavailable)
-	} else {
-		w.WriteHeader(http.StatusOK)
-	}
-}
-
 func main() {
-
-	termDelay = flag.Int("term-delay", 15, "The amount of delay before shutdown.")
+	var (
+		addr      string
+		termDelay int
+		slowStart time.Duration
+		latency   time.Duration
+		errorRate float64
+	)
+	flag.StringVar(&addr, "addr", ":8080", "The address the app binds to.")
+	flag.IntVar(&termDelay, "term-delay", 15, "The amount of delay before shutdown.")
+	flag.DurationVar(&slowStart, "slow-start", 0, "The time /readiness fails after the start.")
+	flag.DurationVar(&latency, "latency", 0, "The delay of every greeting.")
+	flag.Float64Var(&errorRate, "error-rate", 0, "The share of greetings answered with an internal server error.")
 	flag.Parse()
 
-	// Create Server and Route Handlers
-	r := mux.NewRouter()
-
-	r.HandleFunc("/", handler)
-	r.HandleFunc("/health", healthHandler)
-	r.HandleFunc("/readiness", readinessHandler)
+	a := newApp(slowStart)
+	a.latency = latency
+	a.errorRate = errorRate
 
 	srv := &http.Server{
-		Handler:      r,
-		Addr:         ":8080",
+		Handler:      a.router(),
+		Addr:         addr,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
@@ -84,16 +57,16 @@ func main() {
 	// Start Server
 	go func() {
 		log.Println("Starting Server")
-		if err := srv.ListenAndServe(); err != nil {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatal(err)
 		}
 	}()
 
 	// Graceful Shutdown
-	waitForShutdown(srv)
+	waitForShutdown(srv, a, time.Duration(termDelay)*time.Second)
 }
 
-func waitForShutdown(srv *http.Server) {
+func waitForShutdown(srv *http.Server, a *app, termDelay time.Duration) {
 	interruptChan := make(chan os.Signal, 1)
 	signal.Notify(interruptChan, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
@@ -101,12 +74,10 @@ func waitForShutdown(srv *http.Server) {
 	<-interruptChan
 
 	log.Printf("Caught TERM signal, initiating shutdown...")
-	signalReceived = time.Now()
-
-	terminated = true
+	a.terminate()
 
-	log.Printf("Waiting %d seconds for remaining connections...", *termDelay)
-	time.Sleep(time.Duration(*termDelay) * time.Second)
+	log.Printf("Waiting %s for remaining connections...", termDelay)
+	time.Sleep(termDelay)
 
 	log.Println("Gracefully shutting down the webserver...")
 	// Create a deadline to wait for.
@@ -114,9 +85,11 @@ func waitForShutdown(srv *http.Server) {
 	defer cancel()
 	srv.Shutdown(ctx)
 
-	before := atomic.LoadUint64(&requestsBeforeTermination)
-	after := atomic.LoadUint64(&requestsAfterTermination)
-	log.Printf("requests before signal: %d, after signal: %d, last request time since term: %s", before, after, lastRequest.String())
+	a.mu.Lock()
+	c := a.counters
+	a.mu.Unlock()
+	log.Printf("requests before signal: %d, after signal: %d, last request time since term: %s",
+		c.RequestsBeforeTermination, c.RequestsAfterTermination, time.Duration(c.LastRequestAfterTermination*float64(time.Second)))
 
 	log.Println("All done, bye!")
 	os.Exit(0)
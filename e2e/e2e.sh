@@ -5,16 +5,16 @@
 # 1. Create an EKS cluster with eksctl, based on the cluster.yaml file
 # 2. Deploy the AWS ALB Ingress controller
 # 3. Deploy the kube-readiness app
-# 4. Perform a rolling update of the kube-readiness app
-# 5. Start a k6 load-test
+# 4. Start a load test which fails unless all requests get served
+# 5. Perform a rolling update of the kube-readiness app under load
 
 if ! [ -x "$(command -v eksctl)" ]; then
   echo 'Error: eksctl is not installed.' >&2
   exit 1
 fi
 
-if ! [ -x "$(command -v k6)" ]; then
-  echo 'Error: k6 is not installed.' >&2
+if ! [ -x "$(command -v go)" ]; then
+  echo 'Error: go is not installed.' >&2
   exit 1
 fi
 
@@ -109,11 +109,16 @@ until $(curl --output /dev/null --silent --head --fail http://$APP_LB_DNS); do
     sleep 2
 done
 
-# 4. Perform rolling upgrade of the kube-readiness appr (1 -> 2)
+# 4. Start the load test, the pods of the app are watched to correlate failed requests with the rollout
 echo
+echo "Starting load test"
+go run ./loadtest --url "http://$APP_LB_DNS" --duration 180s --namespace $NAMESPACE --selector app=kube-readiness --report "./load-report-$CLUSTER_NAME.json" &
+LOAD_PID=$!
+sleep 10
+
+# 5. Perform rolling upgrade of the kube-readiness app (1 -> 2)
 echo "Executing rolling upgrade"
 sed "s/:1/:2/g" app/k8s/deployment.yaml | kubectl apply -n $NAMESPACE -f - > /dev/null
+kubectl -n $NAMESPACE rollout status deployment/kube-readiness
 
-# 5. Start the k6 load test
-echo "Starting load test"
-APP_LB_DNS=$APP_LB_DNS k6 run k6.js
+wait $LOAD_PID
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/nirnanaaa/kube-readiness/pkg/cloud/aws/awstest"
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Annotations of the alb ingress controller the balancer understands
+const (
+	ingressClassAnnotation        = "kubernetes.io/ingress.class"
+	healthCheckPathAnnotation     = "alb.ingress.kubernetes.io/healthcheck-path"
+	healthCheckIntervalAnnotation = "alb.ingress.kubernetes.io/healthcheck-interval-seconds"
+	healthyThresholdAnnotation    = "alb.ingress.kubernetes.io/healthy-threshold-count"
+	targetGroupAttributes         = "alb.ingress.kubernetes.io/target-group-attributes"
+)
+
+// balancer provisions a load balancer in the elbv2 api for every ingress, registers the pods of
+// its backends as targets, checks their health and forwards requests to the healthy ones, the way
+// the alb ingress controller and an application load balancer do.
+type balancer struct {
+	client.Client
+	Log    logr.Logger
+	API    *awstest.Server
+	Region string
+	// Class restricts the ingresses to the ones of the ingress class, all ingresses if empty
+	Class string
+	// Defaults of the settings of target groups, annotations of an ingress take precedence
+	Defaults awstest.TargetGroup
+	// HealthCheckTimeout is the time a target has to answer a health check
+	HealthCheckTimeout time.Duration
+
+	transport http.RoundTripper
+	mu        sync.Mutex
+	// loadBalancers by ingress
+	loadBalancers map[types.NamespacedName]*loadBalancer
+	next          int
+}
+
+type loadBalancer struct {
+	*awstest.LoadBalancer
+	routes []route
+	// groups by backend, e.g. default/web:80
+	groups map[string]*targetGroup
+}
+
+// route forwards the requests of a host and path prefix to a target group, empty values match all
+type route struct {
+	host  string
+	path  string
+	group *targetGroup
+}
+
+type targetGroup struct {
+	*awstest.TargetGroup
+	healthPath string
+	// targets registered by the balancer
+	targets map[target]bool
+}
+
+type target struct {
+	ip   string
+	port int64
+}
+
+func newBalancer() *balancer {
+	return &balancer{
+		transport:     &http.Transport{Proxy: nil, MaxIdleConnsPerHost: 100, IdleConnTimeout: 30 * time.Second},
+		loadBalancers: map[types.NamespacedName]*loadBalancer{},
+	}
+}
+
+// Sync provisions the load balancers of all ingresses and registers the pods of their backends
+func (b *balancer) Sync(ctx context.Context) error {
+	var ingresses extensionsv1beta1.IngressList
+	if err := b.List(ctx, &ingresses); err != nil {
+		return err
+	}
+	seen := map[types.NamespacedName]bool{}
+	for i := range ingresses.Items {
+		ingress := &ingresses.Items[i]
+		if b.Class != "" && ingress.Annotations[ingressClassAnnotation] != b.Class {
+			continue
+		}
+		name := types.NamespacedName{Namespace: ingress.Namespace, Name: ingress.Name}
+		seen[name] = true
+		if err := b.syncIngress(ctx, ingress); err != nil {
+			b.Log.Error(err, "unable to sync ingress", "ingress", name)
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for name, lb := range b.loadBalancers {
+		if seen[name] {
+			continue
+		}
+		b.Log.Info("ingress is gone, deregistering its targets", "ingress", name)
+		for _, group := range lb.groups {
+			b.setTargets(group, nil)
+		}
+		delete(b.loadBalancers, name)
+	}
+	return nil
+}
+
+func (b *balancer) syncIngress(ctx context.Context, ingress *extensionsv1beta1.Ingress) error {
+	name := types.NamespacedName{Namespace: ingress.Namespace, Name: ingress.Name}
+	b.mu.Lock()
+	lb, ok := b.loadBalancers[name]
+	if !ok {
+		lb = &loadBalancer{
+			LoadBalancer: b.API.AddLoadBalancer(b.Region, loadBalancerName(name)),
+			groups:       map[string]*targetGroup{},
+		}
+		b.loadBalancers[name] = lb
+		b.Log.Info("provisioned load balancer", "ingress", name, "hostname", lb.DNSName)
+	}
+	b.mu.Unlock()
+
+	if hostname, _ := ingressHostname(ingress); hostname != lb.DNSName {
+		ingress.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{Hostname: lb.DNSName}}
+		if err := b.Status().Update(ctx, ingress); err != nil {
+			return err
+		}
+	}
+
+	var routes []route
+	targets := map[*targetGroup][]target{}
+	for _, backend := range ingressBackends(ingress) {
+		key := fmt.Sprintf("%s/%s:%s", ingress.Namespace, backend.ServiceName, backend.ServicePort.String())
+		b.mu.Lock()
+		group, ok := lb.groups[key]
+		if !ok {
+			group = b.newTargetGroup(lb, ingress.Annotations)
+			lb.groups[key] = group
+		}
+		b.mu.Unlock()
+		routes = append(routes, route{host: backend.host, path: backend.path, group: group})
+		resolved, err := b.resolveTargets(ctx, ingress.Namespace, backend.ServiceName, backend.ServicePort)
+		if err != nil {
+			return err
+		}
+		targets[group] = append(targets[group], resolved...)
+	}
+	// the most specific route wins
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].host)+len(routes[i].path) > len(routes[j].host)+len(routes[j].path)
+	})
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	lb.routes = routes
+	for _, group := range lb.groups {
+		b.setTargets(group, targets[group])
+	}
+	return nil
+}
+
+func (b *balancer) newTargetGroup(lb *loadBalancer, annotations map[string]string) *targetGroup {
+	settings := b.Defaults
+	if seconds, err := strconv.Atoi(annotations[healthCheckIntervalAnnotation]); err == nil {
+		settings.HealthCheckInterval = time.Duration(seconds) * time.Second
+	}
+	if count, err := strconv.Atoi(annotations[healthyThresholdAnnotation]); err == nil {
+		settings.HealthyThreshold = count
+	}
+	for _, attribute := range strings.Split(annotations[targetGroupAttributes], ",") {
+		parts := strings.SplitN(strings.TrimSpace(attribute), "=", 2)
+		if len(parts) != 2 || parts[0] != "deregistration_delay.timeout_seconds" {
+			continue
+		}
+		if seconds, err := strconv.Atoi(parts[1]); err == nil {
+			settings.DeregistrationDelay = time.Duration(seconds) * time.Second
+		}
+	}
+	healthPath := annotations[healthCheckPathAnnotation]
+	if healthPath == "" {
+		healthPath = "/"
+	}
+	return &targetGroup{
+		TargetGroup: b.API.AddTargetGroup(lb.LoadBalancer, settings),
+		healthPath:  healthPath,
+		targets:     map[target]bool{},
+	}
+}
+
+// setTargets registers new targets and deregisters the ones which are gone. Targets deregistered by
+// someone else, e.g. the readiness controller, are not registered again while they stay.
+func (b *balancer) setTargets(group *targetGroup, targets []target) {
+	desired := map[target]bool{}
+	for _, t := range targets {
+		desired[t] = true
+		if group.targets[t] {
+			continue
+		}
+		// a new target fails its health checks until it passed the first one
+		b.API.SetHealthy(t.ip, false)
+		if err := b.API.RegisterTarget(group.ARN, t.ip, t.port); err != nil {
+			b.Log.Error(err, "unable to register target", "targetGroup", group.Name, "target", t)
+			continue
+		}
+		b.Log.Info("registered target", "targetGroup", group.Name, "ip", t.ip, "port", t.port)
+	}
+	for t := range group.targets {
+		if desired[t] {
+			continue
+		}
+		if err := b.API.DeregisterTarget(group.ARN, t.ip, t.port); err == nil {
+			b.Log.Info("deregistered target", "targetGroup", group.Name, "ip", t.ip, "port", t.port)
+		}
+	}
+	group.targets = desired
+}
+
+// resolveTargets returns the pod ips and ports behind a service port. Like the alb ingress
+// controller only ready addresses are registered and pods which are terminating are skipped.
+func (b *balancer) resolveTargets(ctx context.Context, namespace, serviceName string, servicePort intstr.IntOrString) ([]target, error) {
+	var service corev1.Service
+	if err := b.Get(ctx, types.NamespacedName{Namespace: namespace, Name: serviceName}, &service); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	var portName string
+	found := false
+	for _, port := range service.Spec.Ports {
+		if (servicePort.Type == intstr.Int && port.Port == servicePort.IntVal) ||
+			(servicePort.Type == intstr.String && port.Name == servicePort.StrVal) {
+			portName, found = port.Name, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("service %s/%s has no port %s", namespace, serviceName, servicePort.String())
+	}
+	var endpoints corev1.Endpoints
+	if err := b.Get(ctx, types.NamespacedName{Namespace: namespace, Name: serviceName}, &endpoints); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	var targets []target
+	for _, subset := range endpoints.Subsets {
+		for _, port := range subset.Ports {
+			if port.Name != portName {
+				continue
+			}
+			for _, address := range subset.Addresses {
+				terminating, err := b.terminating(ctx, address.TargetRef)
+				if err != nil {
+					return nil, err
+				}
+				if !terminating {
+					targets = append(targets, target{ip: address.IP, port: int64(port.Port)})
+				}
+			}
+		}
+	}
+	return targets, nil
+}
+
+func (b *balancer) terminating(ctx context.Context, ref *corev1.ObjectReference) (bool, error) {
+	if ref == nil || ref.Kind != "Pod" {
+		return false, nil
+	}
+	var pod corev1.Pod
+	if err := b.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, &pod); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	return pod.DeletionTimestamp != nil, nil
+}
+
+// CheckHealth checks the health of all targets once and reports the results to the elbv2 api
+func (b *balancer) CheckHealth(ctx context.Context) {
+	b.mu.Lock()
+	checks := map[target]string{}
+	for _, lb := range b.loadBalancers {
+		for _, group := range lb.groups {
+			for t := range group.targets {
+				checks[t] = group.healthPath
+			}
+		}
+	}
+	b.mu.Unlock()
+	httpClient := &http.Client{Transport: b.transport, Timeout: b.HealthCheckTimeout}
+	var wg sync.WaitGroup
+	for t, path := range checks {
+		wg.Add(1)
+		go func(t target, path string) {
+			defer wg.Done()
+			b.API.SetHealthy(t.ip, b.healthy(ctx, httpClient, t, path))
+		}(t, path)
+	}
+	wg.Wait()
+}
+
+func (b *balancer) healthy(ctx context.Context, httpClient *http.Client, t target, path string) bool {
+	request, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s%s", net.JoinHostPort(t.ip, strconv.FormatInt(t.port, 10)), path), nil)
+	if err != nil {
+		return false
+	}
+	response, err := httpClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return false
+	}
+	response.Body.Close()
+	return response.StatusCode >= 200 && response.StatusCode < 400
+}
+
+// ServeHTTP forwards a request to a healthy target of the load balancer its host header names. If
+// there is only a single load balancer, it receives all requests.
+func (b *balancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	group, ok := b.route(r)
+	if !ok {
+		http.Error(w, "no load balancer found", http.StatusNotFound)
+		return
+	}
+	var healthy []awstest.TargetHealth
+	for _, t := range b.API.Targets(group.ARN) {
+		if t.State == awstest.StateHealthy {
+			healthy = append(healthy, t)
+		}
+	}
+	if len(healthy) == 0 {
+		http.Error(w, "no healthy targets", http.StatusServiceUnavailable)
+		return
+	}
+	b.mu.Lock()
+	b.next++
+	t := healthy[b.next%len(healthy)]
+	b.mu.Unlock()
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{
+		Scheme: "http",
+		Host:   net.JoinHostPort(t.ID, strconv.FormatInt(t.Port, 10)),
+	})
+	proxy.Transport = b.transport
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		b.Log.Info("target failed", "ip", t.ID, "port", t.Port, "error", err.Error())
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+func (b *balancer) route(r *http.Request) (*targetGroup, bool) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var lb *loadBalancer
+	for _, candidate := range b.loadBalancers {
+		if candidate.DNSName == host || len(b.loadBalancers) == 1 {
+			lb = candidate
+			break
+		}
+	}
+	if lb == nil {
+		return nil, false
+	}
+	for _, route := range lb.routes {
+		if (route.host == "" || route.host == host) && strings.HasPrefix(r.URL.Path, route.path) {
+			return route.group, true
+		}
+	}
+	return nil, false
+}
+
+// backend is a service port of an ingress and the requests routed to it
+type backend struct {
+	extensionsv1beta1.IngressBackend
+	host string
+	path string
+}
+
+func ingressBackends(ingress *extensionsv1beta1.Ingress) []backend {
+	var backends []backend
+	if ingress.Spec.Backend != nil {
+		backends = append(backends, backend{IngressBackend: *ingress.Spec.Backend})
+	}
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			// paths of the alb ingress controller end in a wildcard, e.g. /*
+			backends = append(backends, backend{
+				IngressBackend: path.Backend,
+				host:           rule.Host,
+				path:           strings.TrimSuffix(path.Path, "*"),
+			})
+		}
+	}
+	return backends
+}
+
+func ingressHostname(ingress *extensionsv1beta1.Ingress) (string, bool) {
+	for _, lb := range ingress.Status.LoadBalancer.Ingress {
+		if lb.Hostname != "" {
+			return lb.Hostname, true
+		}
+	}
+	return "", false
+}
+
+// loadBalancerName derives a name from the ingress, names of application load balancers have at most 32 characters
+func loadBalancerName(ingress types.NamespacedName) string {
+	name := fmt.Sprintf("k8s-%s-%s", ingress.Namespace, ingress.Name)
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	return strings.TrimRight(name, "-")
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/nirnanaaa/kube-readiness/pkg/cloud/aws/awstest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var _ = Describe("Balancer", func() {
+	var (
+		pod      *httptest.Server
+		podPort  int32
+		c        client.Client
+		api      *awstest.Server
+		b        *balancer
+		ingress  = types.NamespacedName{Namespace: "default", Name: "web"}
+		healthy  bool
+		requests int
+	)
+	BeforeEach(func() {
+		healthy, requests = true, 0
+		pod = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/health" {
+				if !healthy {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+				return
+			}
+			requests++
+			w.Write([]byte("hello"))
+		}))
+		u, err := url.Parse(pod.URL)
+		Expect(err).ToNot(HaveOccurred())
+		port, err := strconv.Atoi(u.Port())
+		Expect(err).ToNot(HaveOccurred())
+		podPort = int32(port)
+
+		c = fake.NewFakeClientWithScheme(scheme.Scheme,
+			&extensionsv1beta1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{Namespace: ingress.Namespace, Name: ingress.Name, Annotations: map[string]string{
+					healthCheckPathAnnotation:     "/health",
+					healthCheckIntervalAnnotation: "10",
+					healthyThresholdAnnotation:    "2",
+					targetGroupAttributes:         "deregistration_delay.timeout_seconds=30",
+				}},
+				Spec: extensionsv1beta1.IngressSpec{
+					Backend: &extensionsv1beta1.IngressBackend{ServiceName: "web", ServicePort: intstr.FromInt(80)},
+				},
+			},
+			&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+				Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 80}}},
+			},
+			&corev1.Endpoints{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+				Subsets: []corev1.EndpointSubset{{
+					Addresses: []corev1.EndpointAddress{{IP: "127.0.0.1"}},
+					Ports:     []corev1.EndpointPort{{Name: "http", Port: podPort}},
+				}},
+			},
+		)
+		api = awstest.New()
+		b = newBalancer()
+		b.Client = c
+		b.Log = logf.NullLogger{}
+		b.API = api
+		b.Region = "eu-west-1"
+		b.Defaults = awstest.TargetGroup{HealthCheckInterval: time.Second, HealthyThreshold: 1, DeregistrationDelay: time.Second}
+		b.HealthCheckTimeout = time.Second
+	})
+	AfterEach(func() {
+		pod.Close()
+	})
+	get := func() int {
+		recorder := httptest.NewRecorder()
+		b.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://web/", nil))
+		if recorder.Code == http.StatusOK {
+			body, _ := ioutil.ReadAll(recorder.Body)
+			Expect(string(body)).To(Equal("hello"))
+		}
+		return recorder.Code
+	}
+	state := func() string {
+		var groups []*targetGroup
+		for _, lb := range b.loadBalancers {
+			for _, group := range lb.groups {
+				groups = append(groups, group)
+			}
+		}
+		Expect(groups).To(HaveLen(1))
+		return api.TargetState(groups[0].ARN, "127.0.0.1", int64(podPort))
+	}
+
+	It("should provision a load balancer and report it in the ingress status", func() {
+		Expect(b.Sync(context.TODO())).To(Succeed())
+		var fetched extensionsv1beta1.Ingress
+		Expect(c.Get(context.TODO(), ingress, &fetched)).To(Succeed())
+		Expect(fetched.Status.LoadBalancer.Ingress).To(HaveLen(1))
+		Expect(fetched.Status.LoadBalancer.Ingress[0].Hostname).To(HaveSuffix(".eu-west-1.elb.amazonaws.com"))
+		Expect(fetched.Status.LoadBalancer.Ingress[0].Hostname).To(HavePrefix("k8s-default-web-"))
+	})
+
+	It("should forward requests to targets once they passed their health checks", func() {
+		Expect(b.Sync(context.TODO())).To(Succeed())
+		b.CheckHealth(context.TODO())
+		Expect(state()).To(Equal(awstest.StateInitial))
+		Expect(get()).To(Equal(http.StatusServiceUnavailable))
+
+		api.Advance(20 * time.Second)
+		Expect(state()).To(Equal(awstest.StateHealthy))
+		Expect(get()).To(Equal(http.StatusOK))
+		Expect(requests).To(Equal(1))
+
+		healthy = false
+		b.CheckHealth(context.TODO())
+		Expect(state()).To(Equal(awstest.StateUnhealthy))
+		Expect(get()).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("should let targets drain which left the endpoints", func() {
+		Expect(b.Sync(context.TODO())).To(Succeed())
+		b.CheckHealth(context.TODO())
+		api.Advance(20 * time.Second)
+		Expect(get()).To(Equal(http.StatusOK))
+
+		Expect(c.Update(context.TODO(), &corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+		})).To(Succeed())
+		Expect(b.Sync(context.TODO())).To(Succeed())
+		Expect(state()).To(Equal(awstest.StateDraining))
+		Expect(get()).To(Equal(http.StatusServiceUnavailable))
+		api.Advance(30 * time.Second)
+		Expect(state()).To(Equal(awstest.StateUnused))
+	})
+
+	It("should skip terminating pods", func() {
+		now := metav1.Now()
+		Expect(c.Create(context.TODO(), &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web-1", DeletionTimestamp: &now},
+		})).To(Succeed())
+		Expect(c.Update(context.TODO(), &corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+			Subsets: []corev1.EndpointSubset{{
+				Addresses: []corev1.EndpointAddress{{IP: "127.0.0.1", TargetRef: &corev1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "web-1"}}},
+				Ports:     []corev1.EndpointPort{{Name: "http", Port: podPort}},
+			}},
+		})).To(Succeed())
+		Expect(b.Sync(context.TODO())).To(Succeed())
+		Expect(state()).To(Equal(awstest.StateUnused))
+	})
+})
@@ -0,0 +1,91 @@
+// Command fakelb stands in for the alb ingress controller and application load balancers in local
+// clusters, e.g. kind. It provisions a load balancer for every ingress, forwards requests to the
+// healthy pods of its backends and serves the elbv2 api the readiness controller checks the
+// target health with.
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/nirnanaaa/kube-readiness/pkg/cloud/aws/awstest"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func main() {
+	var (
+		apiAddr, addr, region, class string
+		syncPeriod                   time.Duration
+		healthCheckTimeout           time.Duration
+		defaults                     awstest.TargetGroup
+	)
+	flag.StringVar(&apiAddr, "api-addr", ":8080", "The address the elbv2 and sts apis bind to.")
+	flag.StringVar(&addr, "addr", ":8000", "The address the load balancers bind to. Requests are routed by their host header.")
+	flag.StringVar(&region, "region", "eu-west-1", "The region of the load balancers, it is part of their hostname.")
+	flag.StringVar(&class, "ingress-class", "", "Only provision load balancers for ingresses of this class, all ingresses if empty.")
+	flag.DurationVar(&syncPeriod, "sync-period", time.Second, "The time between two syncs of the ingresses and their targets.")
+	flag.DurationVar(&defaults.HealthCheckInterval, "health-check-interval", 2*time.Second, "The default time between two health checks of a target.")
+	flag.IntVar(&defaults.HealthyThreshold, "healthy-threshold", 2, "The default number of health checks before a new target is healthy.")
+	flag.DurationVar(&defaults.DeregistrationDelay, "deregistration-delay", 15*time.Second, "The default time a deregistered target drains.")
+	flag.DurationVar(&healthCheckTimeout, "health-check-timeout", time.Second, "The time a target has to answer a health check.")
+	flag.Parse()
+
+	ctrl.SetLogger(zap.Logger(true))
+	log := ctrl.Log.WithName("fakelb")
+
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+	if err != nil {
+		log.Error(err, "unable to create client")
+		os.Exit(1)
+	}
+	api := awstest.New()
+	api.Region = region
+	b := newBalancer()
+	b.Client = c
+	b.Log = log
+	b.API = api
+	b.Region = region
+	b.Class = class
+	b.Defaults = defaults
+	b.HealthCheckTimeout = healthCheckTimeout
+
+	stop := ctrl.SetupSignalHandler()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	go wait.Until(func() {
+		if err := b.Sync(ctx); err != nil {
+			log.Error(err, "unable to sync ingresses")
+		}
+	}, syncPeriod, stop)
+	go wait.Until(func() { b.CheckHealth(ctx) }, defaults.HealthCheckInterval, stop)
+
+	servers := []*http.Server{{Addr: apiAddr, Handler: api}, {Addr: addr, Handler: b}}
+	for _, server := range servers {
+		go func(server *http.Server) {
+			log.Info("listening", "addr", server.Addr)
+			if err := server.ListenAndServe(); err != http.ErrServerClosed {
+				log.Error(err, "unable to serve", "addr", server.Addr)
+				os.Exit(1)
+			}
+		}(server)
+	}
+	<-stop
+	shutdown, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelShutdown()
+	for _, server := range servers {
+		server.Shutdown(shutdown)
+	}
+}
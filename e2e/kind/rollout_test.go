@@ -0,0 +1,85 @@
+//go:build e2e
+// +build e2e
+
+package kind
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/nirnanaaa/kube-readiness/e2e/load"
+	"github.com/nirnanaaa/kube-readiness/pkg/readiness"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// loadBalancerURL is the node port of the fake provider mapped to the host by the cluster config
+const loadBalancerURL = "http://localhost:30080/"
+
+var _ = Describe("Rolling Update", func() {
+	const timeout = 3 * time.Minute
+	app := types.NamespacedName{Namespace: "default", Name: "kube-readiness-app"}
+
+	hostname := func() string {
+		var ingress extensionsv1beta1.Ingress
+		Expect(k8sClient.Get(context.TODO(), app, &ingress)).To(Succeed())
+		hostname, err := readiness.ExtractHostname(&ingress)
+		if err != nil {
+			return ""
+		}
+		return hostname
+	}
+	// gated returns the number of pods of the app whose readiness gate is not true
+	gated := func() int {
+		var pods corev1.PodList
+		Expect(k8sClient.List(context.TODO(), &pods, client.InNamespace(app.Namespace), client.MatchingLabels{"app": app.Name})).To(Succeed())
+		count := 0
+		for i := range pods.Items {
+			if condition, _ := readiness.ReadinessConditionStatus(&pods.Items[i]); condition.Status != corev1.ConditionTrue {
+				count++
+			}
+		}
+		return count
+	}
+
+	It("should not fail any request while the pods get replaced", func() {
+		Eventually(hostname, timeout, time.Second).ShouldNot(BeEmpty())
+		lb := hostname()
+		By("waiting for the load balancer to forward to the pods")
+		Eventually(gated, timeout, time.Second).Should(BeZero())
+		Eventually(func() int {
+			request, _ := http.NewRequest(http.MethodGet, loadBalancerURL, nil)
+			request.Host = lb
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				return 0
+			}
+			response.Body.Close()
+			return response.StatusCode
+		}, timeout, time.Second).Should(Equal(http.StatusOK))
+
+		By("replacing all pods under load")
+		generator := load.Start(load.Options{URL: loadBalancerURL, Host: lb, Workers: 10})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go load.WatchPods(ctx, k8sClient, generator, 500*time.Millisecond, client.InNamespace(app.Namespace), client.MatchingLabels{"app": app.Name})
+		time.Sleep(5 * time.Second)
+		generator.Mark("rollout restart")
+		run("kubectl", "rollout", "restart", "deployment/"+app.Name)
+		run("kubectl", "rollout", "status", "deployment/"+app.Name, "--timeout=5m")
+		generator.Mark("rollout complete")
+		// the replaced pods keep serving until their deregistration delay passed
+		time.Sleep(20 * time.Second)
+		cancel()
+		report := generator.Stop()
+		report.WriteText(GinkgoWriter)
+
+		Expect(report.Verify(load.Criteria{MaxGap: 2 * time.Second})).To(Succeed())
+		Expect(gated()).To(BeZero())
+	})
+})
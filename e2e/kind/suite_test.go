@@ -0,0 +1,109 @@
+//go:build e2e
+// +build e2e
+
+package kind
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// The suite runs against the kind cluster KIND_CLUSTER and creates it if it does not exist. A
+// cluster created by the suite is deleted afterwards unless E2E_KEEP_CLUSTER is true. The images
+// are built and loaded into the cluster unless E2E_SKIP_BUILD is true.
+var (
+	clusterName = getenv("KIND_CLUSTER", "kube-readiness-e2e")
+	// root of the repository, commands run in there
+	root           = filepath.Join("..", "..")
+	kubeconfig     string
+	createdCluster bool
+	k8sClient      client.Client
+)
+
+// images built from the repository by their build context and dockerfile
+var images = []struct {
+	name, context, dockerfile string
+}{
+	{"kube-readiness:e2e", ".", "Dockerfile"},
+	{"kube-readiness-fakelb:e2e", ".", "e2e/fakelb/Dockerfile"},
+	{"kube-readiness-app:e2e", "e2e/app", "e2e/app/Dockerfile"},
+}
+
+func TestKind(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Kind E2E Suite")
+}
+
+var _ = BeforeSuite(func() {
+	dir, err := ioutil.TempDir("", "kube-readiness-e2e")
+	Expect(err).ToNot(HaveOccurred())
+	kubeconfig = filepath.Join(dir, "kubeconfig")
+
+	if strings.Contains(run("kind", "get", "clusters"), clusterName) {
+		By("using the existing cluster " + clusterName)
+		Expect(ioutil.WriteFile(kubeconfig, []byte(run("kind", "get", "kubeconfig", "--name", clusterName)), 0600)).To(Succeed())
+	} else {
+		By("creating the cluster " + clusterName)
+		run("kind", "create", "cluster", "--name", clusterName, "--kubeconfig", kubeconfig, "--config", "e2e/kind/cluster.yaml", "--wait", "5m")
+		createdCluster = true
+	}
+
+	if os.Getenv("E2E_SKIP_BUILD") != "true" {
+		for _, image := range images {
+			By("building " + image.name)
+			run("docker", "build", "-t", image.name, "-f", image.dockerfile, image.context)
+			run("kind", "load", "docker-image", image.name, "--name", clusterName)
+		}
+	}
+
+	By("deploying the fake load balancer provider, the controller and the test app")
+	run("kubectl", "apply", "-f", "e2e/kind/fakelb.yaml")
+	run("helm", "upgrade", "--install", "kube-readiness", "helm/kube-readiness", "--namespace", "kube-system", "-f", "e2e/kind/values.yaml", "--wait")
+	run("kubectl", "apply", "-f", "e2e/kind/app.yaml")
+	for _, deployment := range []string{"kube-system/fakelb", "kube-system/kube-readiness", "default/kube-readiness-app"} {
+		parts := strings.Split(deployment, "/")
+		run("kubectl", "rollout", "status", "--namespace", parts[0], "deployment/"+parts[1], "--timeout=5m")
+	}
+
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	Expect(err).ToNot(HaveOccurred())
+	k8sClient, err = client.New(config, client.Options{Scheme: scheme.Scheme})
+	Expect(err).ToNot(HaveOccurred())
+})
+
+var _ = AfterSuite(func() {
+	if createdCluster && os.Getenv("E2E_KEEP_CLUSTER") != "true" {
+		By("deleting the cluster " + clusterName)
+		run("kind", "delete", "cluster", "--name", clusterName)
+	}
+	if kubeconfig != "" {
+		os.RemoveAll(filepath.Dir(kubeconfig))
+	}
+})
+
+// run runs a command in the root of the repository against the cluster and returns its output
+func run(name string, args ...string) string {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = root
+	cmd.Env = append(os.Environ(), "KUBECONFIG="+kubeconfig)
+	out, err := cmd.CombinedOutput()
+	Expect(err).ToNot(HaveOccurred(), "%s %s failed:\n%s", name, strings.Join(args, " "), out)
+	return string(out)
+}
+
+func getenv(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
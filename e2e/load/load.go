@@ -0,0 +1,149 @@
+// Package load drives http traffic at a target and reports whether it got served without downtime,
+// e.g. while the pods behind a load balancer get replaced.
+package load
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Options of a load generator
+type Options struct {
+	// URL requests are sent to
+	URL string
+	// Host header of the requests, e.g. the hostname of a load balancer the url does not resolve to
+	Host string
+	// Workers sending requests in parallel, 10 if unset
+	Workers int
+	// Rate limits the requests per second of all workers together, unlimited if unset
+	Rate float64
+	// Timeout of a single request, 5s if unset
+	Timeout time.Duration
+}
+
+// Sample is the outcome of a single request
+type Sample struct {
+	Start   time.Time     `json:"start"`
+	Latency time.Duration `json:"latency"`
+	Status  int           `json:"status,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// Failed reports whether the request did not get a 2xx response
+func (s Sample) Failed() bool {
+	return s.Error != "" || s.Status < 200 || s.Status > 299
+}
+
+// End is the time the response got received
+func (s Sample) End() time.Time {
+	return s.Start.Add(s.Latency)
+}
+
+// Event is something which happened during the load, e.g. a pod which got terminated
+type Event struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// Generator sends requests until it is stopped
+type Generator struct {
+	opts    Options
+	client  *http.Client
+	limiter *rate.Limiter
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	start   time.Time
+
+	mu      sync.Mutex
+	samples []Sample
+	events  []Event
+}
+
+// Start starts sending requests
+func Start(opts Options) *Generator {
+	if opts.Workers == 0 {
+		opts.Workers = 10
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	g := &Generator{
+		opts: opts,
+		client: &http.Client{
+			Timeout:   opts.Timeout,
+			Transport: &http.Transport{MaxIdleConnsPerHost: opts.Workers},
+		},
+		limiter: rate.NewLimiter(rate.Inf, opts.Workers),
+		cancel:  cancel,
+		start:   time.Now(),
+	}
+	if opts.Rate > 0 {
+		g.limiter = rate.NewLimiter(rate.Limit(opts.Rate), 1)
+	}
+	for i := 0; i < opts.Workers; i++ {
+		g.wg.Add(1)
+		go g.work(ctx)
+	}
+	return g
+}
+
+func (g *Generator) work(ctx context.Context) {
+	defer g.wg.Done()
+	for {
+		if err := g.limiter.Wait(ctx); err != nil {
+			return
+		}
+		sample := g.send(ctx)
+		if ctx.Err() != nil {
+			// the request got cancelled by Stop
+			return
+		}
+		g.mu.Lock()
+		g.samples = append(g.samples, sample)
+		g.mu.Unlock()
+	}
+}
+
+func (g *Generator) send(ctx context.Context) Sample {
+	sample := Sample{Start: time.Now()}
+	request, err := http.NewRequest(http.MethodGet, g.opts.URL, nil)
+	if err != nil {
+		sample.Error = err.Error()
+		return sample
+	}
+	if g.opts.Host != "" {
+		request.Host = g.opts.Host
+	}
+	response, err := g.client.Do(request.WithContext(ctx))
+	sample.Latency = time.Since(sample.Start)
+	if err != nil {
+		sample.Error = err.Error()
+		return sample
+	}
+	response.Body.Close()
+	sample.Status = response.StatusCode
+	return sample
+}
+
+// Mark records an event, failures get correlated with the events preceding them
+func (g *Generator) Mark(format string, args ...interface{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.events = append(g.events, Event{Time: time.Now(), Message: fmt.Sprintf(format, args...)})
+}
+
+// Stop stops sending requests, waits for the ones in flight and reports the outcome
+func (g *Generator) Stop() *Report {
+	end := time.Now()
+	g.cancel()
+	g.wg.Wait()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return NewReport(g.start, end, g.samples, g.events)
+}
@@ -0,0 +1,190 @@
+package load
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Generator", func() {
+	var (
+		server  *httptest.Server
+		failing int32
+		host    atomic.Value
+	)
+	BeforeEach(func() {
+		atomic.StoreInt32(&failing, 0)
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host.Store(r.Host)
+			if atomic.LoadInt32(&failing) == 1 {
+				w.WriteHeader(http.StatusBadGateway)
+			}
+		}))
+	})
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should report the requests sent until it got stopped", func() {
+		g := Start(Options{URL: server.URL, Host: "lb.example.com", Workers: 2, Rate: 200})
+		time.Sleep(100 * time.Millisecond)
+		report := g.Stop()
+
+		Expect(report.Total).To(BeNumerically(">", 0))
+		Expect(report.Failures).To(BeZero())
+		Expect(report.Statuses).To(Equal(map[int]int{http.StatusOK: report.Total}))
+		Expect(report.Verify(Criteria{MaxGap: time.Second})).To(Succeed())
+		Expect(host.Load()).To(Equal("lb.example.com"))
+	})
+
+	It("should correlate failures with the events preceding them", func() {
+		g := Start(Options{URL: server.URL, Workers: 2, Rate: 200})
+		time.Sleep(50 * time.Millisecond)
+		g.Mark("pod %s terminating", "web-1")
+		atomic.StoreInt32(&failing, 1)
+		time.Sleep(50 * time.Millisecond)
+		report := g.Stop()
+
+		Expect(report.Failures).To(BeNumerically(">", 0))
+		Expect(report.Statuses[http.StatusBadGateway]).To(Equal(report.Failures))
+		Expect(report.Events).To(HaveLen(1))
+		Expect(report.Events[0].Message).To(Equal("pod web-1 terminating"))
+		// requests in flight when the event got marked may fail as well
+		Expect(report.Events[0].Failures).To(BeNumerically(">=", report.Failures-2))
+		Expect(report.FirstFailures).ToNot(BeEmpty())
+		Expect(report.Verify(Criteria{})).To(MatchError(ContainSubstring("requests failed")))
+	})
+
+	It("should count requests without a response as errors", func() {
+		server.Close()
+		g := Start(Options{URL: server.URL, Workers: 1, Rate: 100})
+		time.Sleep(50 * time.Millisecond)
+		report := g.Stop()
+
+		Expect(report.Errors).To(BeNumerically(">", 0))
+		Expect(report.Failures).To(Equal(report.Errors))
+		Expect(report.Statuses).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Report", func() {
+	start := time.Date(2019, 9, 1, 12, 0, 0, 0, time.UTC)
+	at := func(offset time.Duration) time.Time { return start.Add(offset) }
+	sample := func(offset, latency time.Duration, status int) Sample {
+		return Sample{Start: at(offset), Latency: latency, Status: status}
+	}
+
+	It("should measure the longest time without a successful response", func() {
+		report := NewReport(start, at(10*time.Second), []Sample{
+			sample(0, 100*time.Millisecond, 200),
+			sample(time.Second, 100*time.Millisecond, 503),
+			{Start: at(2 * time.Second), Latency: 5 * time.Second, Error: "timeout"},
+			sample(4*time.Second, 100*time.Millisecond, 200),
+			sample(9*time.Second, 100*time.Millisecond, 200),
+		}, nil)
+
+		Expect(report.Total).To(Equal(5))
+		Expect(report.Failures).To(Equal(2))
+		Expect(report.Errors).To(Equal(1))
+		Expect(report.Statuses).To(Equal(map[int]int{200: 3, 503: 1}))
+		Expect(report.MaxGap).To(Equal(5 * time.Second))
+		Expect(report.Max).To(Equal(5 * time.Second))
+		Expect(report.P50).To(Equal(100 * time.Millisecond))
+
+		Expect(report.Verify(Criteria{MaxFailures: 2, MaxGap: 5 * time.Second})).To(Succeed())
+		err := report.Verify(Criteria{MaxFailures: 2, MaxGap: 4 * time.Second, MaxP99: time.Second})
+		Expect(err).To(MatchError(ContainSubstring("no successful response for 5s")))
+		Expect(err).To(MatchError(ContainSubstring("p99 latency of 5s exceeds 1s")))
+	})
+
+	It("should count the time until the end without a successful response as gap", func() {
+		report := NewReport(start, at(3*time.Second), []Sample{sample(0, 500*time.Millisecond, 200)}, nil)
+		Expect(report.MaxGap).To(Equal(2500 * time.Millisecond))
+	})
+
+	It("should use the nearest rank as percentile", func() {
+		var samples []Sample
+		for i := 1; i <= 200; i++ {
+			samples = append(samples, sample(0, time.Duration(i)*time.Millisecond, 200))
+		}
+		report := NewReport(start, at(time.Second), samples, nil)
+		Expect(report.P50).To(Equal(100 * time.Millisecond))
+		Expect(report.P99).To(Equal(198 * time.Millisecond))
+		Expect(report.Max).To(Equal(200 * time.Millisecond))
+	})
+
+	It("should attribute failures to the last event before the request", func() {
+		report := NewReport(start, at(10*time.Second), []Sample{
+			sample(500*time.Millisecond, 0, 502),
+			sample(2*time.Second, 0, 502),
+			sample(4*time.Second, 0, 502),
+			sample(5*time.Second, 0, 502),
+		}, []Event{
+			{Time: at(3 * time.Second), Message: "pod web-2 terminating"},
+			{Time: at(time.Second), Message: "pod web-1 terminating"},
+		})
+
+		Expect(report.Events).To(Equal([]EventReport{
+			{Event: Event{Time: at(time.Second), Message: "pod web-1 terminating"}, Failures: 1},
+			{Event: Event{Time: at(3 * time.Second), Message: "pod web-2 terminating"}, Failures: 2},
+		}))
+	})
+
+	It("should fail without any request", func() {
+		Expect(NewReport(start, at(time.Second), nil, nil).Verify(Criteria{})).To(MatchError(ContainSubstring("no requests were sent")))
+	})
+})
+
+var _ = Describe("WatchPods", func() {
+	pod := func(name string, ready corev1.ConditionStatus) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Labels: map[string]string{"app": "web"}},
+			Status:     corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: ready}}},
+		}
+	}
+	messages := func(g *Generator) func() []string {
+		return func() []string {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			var messages []string
+			for _, event := range g.events {
+				messages = append(messages, event.Message)
+			}
+			return messages
+		}
+	}
+
+	It("should mark the lifecycle of the pods", func() {
+		c := fake.NewFakeClientWithScheme(scheme.Scheme, pod("web-1", corev1.ConditionTrue))
+		g := &Generator{}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go WatchPods(ctx, c, g, 10*time.Millisecond, client.InNamespace("default"), client.MatchingLabels{"app": "web"})
+		time.Sleep(50 * time.Millisecond)
+		Expect(messages(g)()).To(BeEmpty())
+
+		Expect(c.Create(ctx, pod("web-2", corev1.ConditionFalse))).To(Succeed())
+		Eventually(messages(g)).Should(Equal([]string{"pod web-2 created"}))
+		Expect(c.Update(ctx, pod("web-2", corev1.ConditionTrue))).To(Succeed())
+		Eventually(messages(g)).Should(ContainElement("pod web-2 ready"))
+
+		terminating := pod("web-1", corev1.ConditionFalse)
+		now := metav1.Now()
+		terminating.DeletionTimestamp = &now
+		Expect(c.Update(ctx, terminating)).To(Succeed())
+		Eventually(messages(g)).Should(ContainElement("pod web-1 terminating"))
+		Expect(messages(g)()).To(ContainElement("pod web-1 not ready"))
+		Expect(c.Delete(ctx, terminating)).To(Succeed())
+		Eventually(messages(g)).Should(ContainElement("pod web-1 deleted"))
+	})
+})
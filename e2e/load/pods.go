@@ -0,0 +1,71 @@
+package load
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// podState is what the events of a pod are derived from
+type podState struct {
+	ready       bool
+	terminating bool
+}
+
+// WatchPods marks the lifecycle of the matching pods as events of the generator until the context is
+// done: pods which got created, became ready or not ready, started terminating or got deleted.
+func WatchPods(ctx context.Context, c client.Client, g *Generator, interval time.Duration, opts ...client.ListOption) {
+	known := map[string]podState{}
+	first := true
+	wait.Until(func() {
+		var pods corev1.PodList
+		if err := c.List(ctx, &pods, opts...); err != nil {
+			g.Mark("unable to list pods: %v", err)
+			return
+		}
+		seen := map[string]bool{}
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			seen[pod.Name] = true
+			state := podState{ready: podReady(pod), terminating: pod.DeletionTimestamp != nil}
+			previous, ok := known[pod.Name]
+			known[pod.Name] = state
+			switch {
+			case !ok && !first:
+				g.Mark("pod %s created", pod.Name)
+			case !ok:
+				// pods which existed before the watch started
+				continue
+			}
+			if state.ready != previous.ready {
+				if state.ready {
+					g.Mark("pod %s ready", pod.Name)
+				} else if ok {
+					g.Mark("pod %s not ready", pod.Name)
+				}
+			}
+			if state.terminating && !previous.terminating {
+				g.Mark("pod %s terminating", pod.Name)
+			}
+		}
+		for name := range known {
+			if !seen[name] {
+				delete(known, name)
+				g.Mark("pod %s deleted", name)
+			}
+		}
+		first = false
+	}, interval, ctx.Done())
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
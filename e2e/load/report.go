@@ -0,0 +1,167 @@
+package load
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxFailureSamples is the number of failed requests a report keeps
+const maxFailureSamples = 10
+
+// Report summarizes the requests of a load generator
+type Report struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Total int       `json:"total"`
+	// Failures are the requests without a 2xx response
+	Failures int `json:"failures"`
+	// Statuses counts the responses by status code
+	Statuses map[int]int `json:"statuses"`
+	// Errors counts the requests which did not get any response
+	Errors int `json:"errors"`
+	// MaxGap is the longest time without a successful response
+	MaxGap time.Duration `json:"maxGap"`
+	P50    time.Duration `json:"p50"`
+	P99    time.Duration `json:"p99"`
+	Max    time.Duration `json:"max"`
+	// Events and the failures between them and the next event
+	Events []EventReport `json:"events,omitempty"`
+	// FirstFailures are the first failed requests
+	FirstFailures []Sample `json:"firstFailures,omitempty"`
+}
+
+// EventReport is an event and the requests failed after it, before the next event
+type EventReport struct {
+	Event
+	Failures int `json:"failures"`
+}
+
+// NewReport summarizes the samples of the load between start and end
+func NewReport(start, end time.Time, samples []Sample, events []Event) *Report {
+	samples = append([]Sample(nil), samples...)
+	sort.Slice(samples, func(i, j int) bool { return samples[i].End().Before(samples[j].End()) })
+	events = append([]Event(nil), events...)
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+
+	r := &Report{Start: start, End: end, Total: len(samples), Statuses: map[int]int{}}
+	for _, event := range events {
+		r.Events = append(r.Events, EventReport{Event: event})
+	}
+	latencies := make([]time.Duration, 0, len(samples))
+	lastSuccess := start
+	for _, sample := range samples {
+		latencies = append(latencies, sample.Latency)
+		if sample.Error != "" {
+			r.Errors++
+		} else {
+			r.Statuses[sample.Status]++
+		}
+		if !sample.Failed() {
+			if gap := sample.End().Sub(lastSuccess); gap > r.MaxGap {
+				r.MaxGap = gap
+			}
+			lastSuccess = sample.End()
+			continue
+		}
+		r.Failures++
+		if len(r.FirstFailures) < maxFailureSamples {
+			r.FirstFailures = append(r.FirstFailures, sample)
+		}
+		// the last event which happened before the request got sent
+		for i := len(r.Events) - 1; i >= 0; i-- {
+			if !r.Events[i].Time.After(sample.Start) {
+				r.Events[i].Failures++
+				break
+			}
+		}
+	}
+	if gap := end.Sub(lastSuccess); gap > r.MaxGap {
+		r.MaxGap = gap
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	r.P50 = percentile(latencies, 0.50)
+	r.P99 = percentile(latencies, 0.99)
+	if len(latencies) > 0 {
+		r.Max = latencies[len(latencies)-1]
+	}
+	return r
+}
+
+// percentile returns the nearest rank percentile of sorted values
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(float64(len(sorted))*p+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// Criteria a report has to meet, zero durations are not checked
+type Criteria struct {
+	// MaxFailures is the number of requests which may fail
+	MaxFailures int
+	// MaxGap is the longest time without a successful response
+	MaxGap time.Duration
+	// MaxP99 is the highest 99th percentile of the latency
+	MaxP99 time.Duration
+}
+
+// Verify returns an error listing all criteria the report does not meet
+func (r *Report) Verify(c Criteria) error {
+	var violations []string
+	if r.Total == 0 {
+		violations = append(violations, "no requests were sent")
+	}
+	if r.Failures > c.MaxFailures {
+		violations = append(violations, fmt.Sprintf("%d of %d requests failed, at most %d may fail", r.Failures, r.Total, c.MaxFailures))
+	}
+	if c.MaxGap > 0 && r.MaxGap > c.MaxGap {
+		violations = append(violations, fmt.Sprintf("no successful response for %s, at most %s are allowed", r.MaxGap, c.MaxGap))
+	}
+	if c.MaxP99 > 0 && r.P99 > c.MaxP99 {
+		violations = append(violations, fmt.Sprintf("p99 latency of %s exceeds %s", r.P99, c.MaxP99))
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("load test failed: %s", strings.Join(violations, "; "))
+	}
+	return nil
+}
+
+// WriteText writes a human readable summary of the report
+func (r *Report) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "requests: %d, failures: %d, errors: %d, duration: %s\n", r.Total, r.Failures, r.Errors, r.End.Sub(r.Start).Round(time.Millisecond))
+	codes := make([]int, 0, len(r.Statuses))
+	for code := range r.Statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "  status %d: %d\n", code, r.Statuses[code])
+	}
+	fmt.Fprintf(w, "latency p50: %s, p99: %s, max: %s, max gap: %s\n", r.P50, r.P99, r.Max, r.MaxGap)
+	if len(r.Events) > 0 {
+		fmt.Fprintln(w, "events:")
+		for _, event := range r.Events {
+			fmt.Fprintf(w, "  %s %s (%d failures)\n", event.Time.Sub(r.Start).Round(time.Millisecond), event.Message, event.Failures)
+		}
+	}
+	if len(r.FirstFailures) > 0 {
+		fmt.Fprintln(w, "first failures:")
+		for _, sample := range r.FirstFailures {
+			outcome := sample.Error
+			if outcome == "" {
+				outcome = fmt.Sprintf("status %d", sample.Status)
+			}
+			fmt.Fprintf(w, "  %s %s after %s\n", sample.Start.Sub(r.Start).Round(time.Millisecond), outcome, sample.Latency)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Command loadtest sends requests to a target for a while, e.g. during a rolling update, and fails
+// unless all of them got served. Lifecycle events of the pods behind the target are correlated with
+// the failed requests.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/nirnanaaa/kube-readiness/e2e/load"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func main() {
+	var (
+		opts                load.Options
+		criteria            load.Criteria
+		duration            time.Duration
+		report              string
+		namespace, selector string
+		watchInterval       time.Duration
+	)
+	flag.StringVar(&opts.URL, "url", "", "The url requests are sent to.")
+	flag.StringVar(&opts.Host, "host", "", "The host header of the requests, the host of the url if empty.")
+	flag.IntVar(&opts.Workers, "workers", 10, "The number of requests sent in parallel.")
+	flag.Float64Var(&opts.Rate, "rate", 0, "The requests per second of all workers together, unlimited if 0.")
+	flag.DurationVar(&opts.Timeout, "timeout", 5*time.Second, "The time a request may take.")
+	flag.DurationVar(&duration, "duration", 3*time.Minute, "The time requests are sent for.")
+	flag.IntVar(&criteria.MaxFailures, "max-failures", 0, "The number of requests which may fail.")
+	flag.DurationVar(&criteria.MaxGap, "max-gap", 0, "The longest time without a successful response, not checked if 0.")
+	flag.DurationVar(&criteria.MaxP99, "max-p99", 0, "The highest 99th percentile of the latency, not checked if 0.")
+	flag.StringVar(&report, "report", "", "Write the report as json to this file.")
+	flag.StringVar(&namespace, "namespace", "default", "The namespace of the pods behind the target.")
+	flag.StringVar(&selector, "selector", "", "The label selector, e.g. app=web, of the pods behind the target. Their lifecycle is not watched if empty.")
+	flag.DurationVar(&watchInterval, "watch-interval", 500*time.Millisecond, "The time between two lookups of the pods.")
+	flag.Parse()
+
+	if opts.URL == "" {
+		fmt.Fprintln(os.Stderr, "--url is required")
+		os.Exit(2)
+	}
+	var listOpts []client.ListOption
+	var c client.Client
+	if selector != "" {
+		set, err := labels.ConvertSelectorToLabelsMap(selector)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid selector: %v\n", err)
+			os.Exit(2)
+		}
+		listOpts = []client.ListOption{client.InNamespace(namespace), client.MatchingLabels(set)}
+		scheme := runtime.NewScheme()
+		_ = clientgoscheme.AddToScheme(scheme)
+		config, err := ctrl.GetConfig()
+		if err == nil {
+			c, err = client.New(config, client.Options{Scheme: scheme})
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to create client: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	stop := ctrl.SetupSignalHandler()
+	generator := load.Start(opts)
+	ctx, cancel := context.WithCancel(context.Background())
+	if c != nil {
+		go load.WatchPods(ctx, c, generator, watchInterval, listOpts...)
+	}
+	select {
+	case <-time.After(duration):
+	case <-stop:
+		generator.Mark("interrupted")
+	}
+	cancel()
+	result := generator.Stop()
+
+	result.WriteText(os.Stdout)
+	if report != "" {
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err == nil {
+			err = ioutil.WriteFile(report, data, 0644)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to write report: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := result.Verify(criteria); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
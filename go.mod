@@ -10,12 +10,13 @@ require (
 	github.com/onsi/ginkgo v1.8.0
 	github.com/onsi/gomega v1.5.0
 	github.com/prometheus/client_golang v0.9.0
-	github.com/ticketmaster/aws-sdk-go-cache v0.0.0-20200114210642-9a510f7c39db
 	go.uber.org/zap v1.9.1
+	golang.org/x/time v0.0.0-20180412165947-fbb02b2291d2
 	gomodules.xyz/jsonpatch/v2 v2.0.1 // indirect
 	k8s.io/api v0.0.0-20190409021203-6e4e0e4f393b
 	k8s.io/apimachinery v0.0.0-20190404173353-6a84e37a896d
 	k8s.io/client-go v11.0.1-0.20190409021438-1a26190bd76a+incompatible
 	sigs.k8s.io/controller-runtime v0.2.0-rc.0
 	sigs.k8s.io/controller-tools v0.2.0-rc.0 // indirect
+	sigs.k8s.io/yaml v1.1.0
 )
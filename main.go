@@ -17,21 +17,34 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/nirnanaaa/kube-readiness/controllers"
+	"github.com/nirnanaaa/kube-readiness/pkg/cloud"
 	"github.com/nirnanaaa/kube-readiness/pkg/cloud/aws"
+	"github.com/nirnanaaa/kube-readiness/pkg/config"
+	"github.com/nirnanaaa/kube-readiness/pkg/health"
 	"github.com/nirnanaaa/kube-readiness/pkg/readiness"
+	"github.com/nirnanaaa/kube-readiness/pkg/sharding"
 	corev1 "k8s.io/api/core/v1"
 	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
 	networkingv1beta1 "k8s.io/api/networking/v1beta1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	// +kubebuilder:scaffold:imports
 )
@@ -52,100 +65,474 @@ func init() {
 
 func main() {
 	var metricsAddr string
-	var region string
-	var assumeRoleArn string
+	var healthProbeAddr string
 	var namespace string
+	var includeNamespaces string
+	var excludeNamespaces string
+	var awsRoles string
+	var verifyCredentials bool
+	var requireCredentials bool
+	var awsNamespaceRoles string
 	var enableLeaderElection bool
-	var sdkCache bool
+	var leaderElectionID string
+	var leaderElectionNamespace string
+	var leaseDuration time.Duration
+	var renewDeadline time.Duration
+	var retryPeriod time.Duration
+	var endpointSlices bool
+	var dryRun bool
 	var debug bool
+	var shardMode string
+	var shardGroup string
+	var shardNamespace string
+	var shardIdentity string
+	var shardLeaseDuration time.Duration
+	var configFile string
+	var configMap string
 
-	syncPeriod := 1 * time.Minute
+	// the flags are the defaults of the configuration file
+	cfg := config.Default()
 
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8081", "The address the metric endpoint binds to.")
-	flag.StringVar(&assumeRoleArn, "aws-assume-role-arn", "", "A role that should be assumed from aws.")
-	flag.StringVar(&region, "aws-region", "eu-west-1", "The AWS region to bind to.")
+	flag.StringVar(&healthProbeAddr, "health-probe-addr", ":8082", "The address the liveness and readiness endpoints bind to.")
+	flag.StringVar(&configFile, "config", "", "Path of a configuration file overriding the flags.")
+	flag.StringVar(&configMap, "config-map", "",
+		"Namespace and name of a ConfigMap holding the configuration file in the key \""+config.DefaultKey+"\". It is reloaded on change.")
+	flag.StringVar(&cfg.AWS.AssumeRoleArn, "aws-assume-role-arn", cfg.AWS.AssumeRoleArn, "A role that should be assumed from aws.")
+	flag.StringVar(&cfg.AWS.Region, "aws-region", cfg.AWS.Region,
+		"The AWS region of load balancers whose region can not be told from their hostname. Detected from the environment or the instance metadata if set empty.")
+	flag.StringVar(&cfg.AWS.WebIdentityTokenFile, "aws-web-identity-token-file", cfg.AWS.WebIdentityTokenFile,
+		"A web identity token file, e.g. of IAM roles for service accounts, exchanged for the credentials of --aws-web-identity-role-arn.")
+	flag.StringVar(&cfg.AWS.WebIdentityRoleArn, "aws-web-identity-role-arn", cfg.AWS.WebIdentityRoleArn,
+		"The role assumed with the web identity token.")
+	flag.StringVar(&cfg.AWS.Endpoints.ELBV2, "aws-elbv2-endpoint", cfg.AWS.Endpoints.ELBV2,
+		"Overrides the url of the elbv2 api, e.g. a vpc or fips endpoint. {region} is replaced with the region of the load balancer.")
+	flag.StringVar(&cfg.AWS.Endpoints.EC2, "aws-ec2-endpoint", cfg.AWS.Endpoints.EC2,
+		"Overrides the url of the ec2 api. {region} is replaced with the region of the client.")
+	flag.StringVar(&cfg.AWS.Endpoints.STS, "aws-sts-endpoint", cfg.AWS.Endpoints.STS,
+		"Overrides the url of the sts api, e.g. a regional endpoint. {region} is replaced with the region of the client.")
+	flag.BoolVar(&verifyCredentials, "aws-verify-credentials", true,
+		"Resolve and log the AWS identity of the default role and all --aws-roles at startup.")
+	flag.BoolVar(&requireCredentials, "aws-require-credentials", false,
+		"Exit if --aws-verify-credentials fails for any role instead of logging the error.")
+	flag.StringVar(&awsRoles, "aws-roles", "",
+		"Comma separated list of alias=role-arn pairs. Ingresses of namespaces without --aws-namespace-roles select a role by its alias in the \""+cloud.RoleAnnotation+"\" annotation.")
+	flag.StringVar(&awsNamespaceRoles, "aws-namespace-roles", "",
+		"Comma separated list of namespace=alias pairs selecting the role of the ingresses of a namespace. The annotation of the ingresses is ignored in these namespaces.")
 	flag.StringVar(&namespace, "namespace", "", "Namespace to listen on")
+	flag.StringVar(&includeNamespaces, "include-namespaces", "",
+		"Comma separated list of namespaces to maintain readiness gates in. Empty includes all namespaces.")
+	flag.StringVar(&excludeNamespaces, "exclude-namespaces", "",
+		"Comma separated list of namespaces to ignore. Takes precedence over the include list and selector.")
+	flag.StringVar(&cfg.Namespaces.Selector, "namespace-selector", cfg.Namespaces.Selector,
+		"Label selector namespaces have to match to maintain readiness gates in them.")
+	flag.DurationVar(&cfg.SyncPeriod.Duration, "sync-period", cfg.SyncPeriod.Duration,
+		"The minimum interval in which all watched objects are reconciled.")
+	flag.IntVar(&cfg.Concurrency.Pods, "pod-concurrency", cfg.Concurrency.Pods, "The number of pods reconciled in parallel.")
+	flag.IntVar(&cfg.Concurrency.Services, "service-concurrency", cfg.Concurrency.Services, "The number of services reconciled in parallel.")
+	flag.IntVar(&cfg.Concurrency.Ingresses, "ingress-concurrency", cfg.Concurrency.Ingresses, "The number of ingresses reconciled in parallel.")
+	flag.DurationVar(&cfg.Workqueue.BaseDelay.Duration, "workqueue-base-delay", cfg.Workqueue.BaseDelay.Duration,
+		"The delay of the first retry of a failed reconcile, it doubles with every further failure.")
+	flag.DurationVar(&cfg.Workqueue.MaxDelay.Duration, "workqueue-max-delay", cfg.Workqueue.MaxDelay.Duration,
+		"The maximum delay between two retries of a failed reconcile.")
+	flag.Float64Var(&cfg.Workqueue.QPS, "workqueue-qps", cfg.Workqueue.QPS,
+		"The number of retries per second of each controller.")
+	flag.IntVar(&cfg.Workqueue.Burst, "workqueue-burst", cfg.Workqueue.Burst,
+		"The number of retries of each controller allowed in a burst.")
+	flag.Float64Var(&cfg.CloudRateLimit.QPS, "cloud-qps", cfg.CloudRateLimit.QPS,
+		"The number of requests per second to the load balancer api, counting every page and retry but no cached results. 0 disables the limit.")
+	flag.IntVar(&cfg.CloudRateLimit.Burst, "cloud-burst", cfg.CloudRateLimit.Burst,
+		"The number of requests to the load balancer api allowed in a burst.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&leaderElectionID, "leader-election-id", "kube-readiness-leader-election",
+		"Name of the configmap holding the leader election lock.")
+	flag.StringVar(&leaderElectionNamespace, "leader-election-namespace", "",
+		"Namespace of the leader election lock. Defaults to the namespace the controller runs in.")
+	flag.DurationVar(&leaseDuration, "leader-election-lease-duration", 15*time.Second,
+		"How long followers wait before they take over the leadership of a leader which stopped renewing it.")
+	flag.DurationVar(&renewDeadline, "leader-election-renew-deadline", 10*time.Second,
+		"How long the leader retries renewing its leadership before giving it up.")
+	flag.DurationVar(&retryPeriod, "leader-election-retry-period", 2*time.Second,
+		"The time between two attempts to acquire or renew the leadership.")
+	flag.StringVar(&shardMode, "sharding", "",
+		"Split the health checks of the pods between all replicas by \"namespace\" or pod \"uid\". Replaces the leader election.")
+	flag.StringVar(&shardGroup, "shard-group", "kube-readiness", "Name of the group of replicas sharing the pods.")
+	flag.StringVar(&shardNamespace, "shard-namespace", "", "Namespace of the shard membership leases.")
+	flag.StringVar(&shardIdentity, "shard-identity", "", "Identity of the replica within its shard group. Defaults to the hostname.")
+	flag.DurationVar(&shardLeaseDuration, "shard-lease-duration", 15*time.Second,
+		"How long a replica which stopped renewing its lease keeps its shard.")
 	flag.BoolVar(&debug, "debug", false,
 		"Enable debug logging.")
-	flag.BoolVar(&sdkCache, "sdk-cache", false,
+	flag.BoolVar(&cfg.Cache.Enabled, "sdk-cache", cfg.Cache.Enabled,
 		"enable the sdk cache (supported: AWS).")
+	flag.BoolVar(&endpointSlices, "endpoint-slices", true,
+		"Resolve the pods of a service from EndpointSlices. Falls back to Endpoints if the cluster does not serve them.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Observe only: log, count and record events for the readiness gate changes instead of patching pods. Pods behind exposed services are checked even without the readiness gate.")
+	flag.DurationVar(&cfg.Cache.LoadBalancerTTL.Duration, "sdk-cache-load-balancer-ttl", cfg.Cache.LoadBalancerTTL.Duration,
+		"How long load balancer lookups are cached. 0 disables caching of the operation.")
+	flag.DurationVar(&cfg.Cache.TargetGroupTTL.Duration, "sdk-cache-target-group-ttl", cfg.Cache.TargetGroupTTL.Duration,
+		"How long target group lookups are cached. 0 disables caching of the operation.")
+	flag.DurationVar(&cfg.Cache.TargetHealthTTL.Duration, "sdk-cache-target-health-ttl", cfg.Cache.TargetHealthTTL.Duration,
+		"How long target health states are cached. 0 disables caching of the operation.")
+	flag.DurationVar(&cfg.Cache.NotFoundTTL.Duration, "sdk-cache-not-found-ttl", cfg.Cache.NotFoundTTL.Duration,
+		"How long a load balancer which could not be found is remembered. 0 disables negative caching.")
+	flag.DurationVar(&cfg.Timeouts.EndpointGroupRefresh.Duration, "endpoint-group-refresh-interval", cfg.Timeouts.EndpointGroupRefresh.Duration,
+		"How often the resolved endpoint groups of all known load balancers are refreshed. 0 disables the refresh.")
+	flag.DurationVar(&cfg.Timeouts.AWSCall.Duration, "aws-call-timeout", cfg.Timeouts.AWSCall.Duration,
+		"Timeout for a single AWS api call including all of its pages. 0 disables the timeout.")
 	flag.Parse()
+	cfg.Namespaces.Include = splitList(includeNamespaces)
+	cfg.Namespaces.Exclude = splitList(excludeNamespaces)
 
 	ctrl.SetLogger(zap.Logger(debug))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:             scheme,
-		MetricsBindAddress: metricsAddr,
-		LeaderElection:     enableLeaderElection,
-		SyncPeriod:         &syncPeriod,
-		Namespace:          namespace,
+	mode, err := sharding.ParseMode(shardMode)
+	if err != nil {
+		setupLog.Error(err, "invalid sharding mode")
+		os.Exit(1)
+	}
+	if mode != "" && enableLeaderElection {
+		setupLog.Error(errors.New("sharding and leader election are mutually exclusive"), "invalid flags")
+		os.Exit(1)
+	}
+	if mode != "" && shardNamespace == "" {
+		setupLog.Error(errors.New("sharding requires --shard-namespace"), "invalid flags")
+		os.Exit(1)
+	}
+	if cfg.AWS.Roles, err = splitMap(awsRoles); err != nil {
+		setupLog.Error(err, "invalid --aws-roles")
+		os.Exit(1)
+	}
+	if cfg.AWS.NamespaceRoles, err = splitMap(awsNamespaceRoles); err != nil {
+		setupLog.Error(err, "invalid --aws-namespace-roles")
+		os.Exit(1)
+	}
+
+	if configFile != "" {
+		data, err := ioutil.ReadFile(configFile)
+		if err != nil {
+			setupLog.Error(err, "unable to read configuration file")
+			os.Exit(1)
+		}
+		loaded, err := config.Parse(data, cfg)
+		if err != nil {
+			setupLog.Error(err, "invalid configuration file")
+			os.Exit(1)
+		}
+		cfg = *loaded
+	}
+	restConfig := ctrl.GetConfigOrDie()
+	var configWatcher *config.Watcher
+	if configMap != "" {
+		parts := strings.SplitN(configMap, "/", 2)
+		if len(parts) != 2 {
+			setupLog.Error(errors.New("expected namespace/name"), "invalid --config-map")
+			os.Exit(1)
+		}
+		clientset, err := kubernetes.NewForConfig(restConfig)
+		if err != nil {
+			setupLog.Error(err, "unable to create configuration client")
+			os.Exit(1)
+		}
+		configWatcher = &config.Watcher{
+			Client:    clientset,
+			Namespace: parts[0],
+			Name:      parts[1],
+			Defaults:  cfg,
+			Log:       ctrl.Log.WithName("config"),
+		}
+		loaded, err := configWatcher.Load()
+		if err != nil {
+			setupLog.Error(err, "unable to load configuration", "configMap", configMap)
+			os.Exit(1)
+		}
+		cfg = *loaded
+	}
+	if err := cfg.Validate(); err != nil {
+		setupLog.Error(err, "invalid configuration")
+		os.Exit(1)
+	}
+
+	mgr, err := ctrl.NewManager(restConfig, ctrl.Options{
+		Scheme:                  scheme,
+		MetricsBindAddress:      metricsAddr,
+		LeaderElection:          enableLeaderElection,
+		LeaderElectionID:        leaderElectionID,
+		LeaderElectionNamespace: leaderElectionNamespace,
+		LeaseDuration:           &leaseDuration,
+		RenewDeadline:           &renewDeadline,
+		RetryPeriod:             &retryPeriod,
+		SyncPeriod:              &cfg.SyncPeriod.Duration,
+		Namespace:               namespace,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
-	endpointPodMap := make(readiness.EndpointPodMap)
-	awsSdk, err := aws.NewCloudSDK(region, assumeRoleArn, ctrl.Log.WithName("sdk").WithName("aws"), sdkCache)
+	namespaces := &controllers.NamespaceFilter{
+		Client:   mgr.GetClient(),
+		Include:  cfg.Namespaces.Include,
+		Exclude:  cfg.Namespaces.Exclude,
+		Selector: cfg.NamespaceSelector(),
+	}
+	endpointPodMap := readiness.NewEndpointPodMap()
+	awsCloud, err := aws.NewCloudSDK(awsOptions(&cfg), ctrl.Log.WithName("sdk").WithName("aws"))
 	if err != nil {
 		setupLog.Error(err, "unable to setup Cloud SDK", "component", "awsSDK")
 		os.Exit(1)
 	}
+	if verifyCredentials {
+		// an sts outage must not keep the controller from starting unless asked to
+		if err := verifyAWSCredentials(awsCloud.(*aws.Cloud), cfg.AWS.Roles); err != nil {
+			setupLog.Error(err, "unable to verify AWS credentials")
+			if requireCredentials {
+				os.Exit(1)
+			}
+		}
+	}
+	awsSdk := awsCloud
+	var podDryRun *controllers.DryRun
+	if dryRun {
+		setupLog.Info("dry run enabled, no pods are patched")
+		podDryRun = &controllers.DryRun{
+			Log:      ctrl.Log.WithName("controllers").WithName("DryRun"),
+			Recorder: mgr.GetEventRecorderFor("kube-readiness"),
+		}
+	}
 	endpointPodMutex := new(sync.RWMutex)
 	serviceInfoMutex := new(sync.RWMutex)
 	serviceInfoMap := make(readiness.ServiceInfoMap)
 
-	if err = (&controllers.PodReconciler{
-		Client:              mgr.GetClient(),
-		Log:                 ctrl.Log.WithName("controllers").WithName("Pod"),
-		EndpointPodMap:      endpointPodMap,
-		EndpointPodMutex:    endpointPodMutex,
-		ServiceInfoMap:      serviceInfoMap,
-		ServiceInfoMapMutex: serviceInfoMutex,
-		CloudSDK:            awsSdk,
-	}).SetupWithManager(mgr); err != nil {
+	podReconciler := &controllers.PodReconciler{
+		Client:                  mgr.GetClient(),
+		Log:                     ctrl.Log.WithName("controllers").WithName("Pod"),
+		EndpointPodMap:          endpointPodMap,
+		EndpointPodMutex:        endpointPodMutex,
+		ServiceInfoMap:          serviceInfoMap,
+		ServiceInfoMapMutex:     serviceInfoMutex,
+		CloudSDK:                awsSdk,
+		Namespaces:              namespaces,
+		MaxConcurrentReconciles: cfg.Concurrency.Pods,
+		RateLimiter:             newRateLimiter(&cfg),
+		DryRun:                  podDryRun,
+	}
+	if err = podReconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Pod")
 		os.Exit(1)
 	}
 	serviceReconciler := &controllers.ServiceReconciler{
-		Client:              mgr.GetClient(),
-		ServiceInfoMap:      serviceInfoMap,
-		EndpointPodMap:      endpointPodMap,
-		Lock:                endpointPodMutex,
-		ServiceInfoMapMutex: serviceInfoMutex,
-		Log:                 ctrl.Log.WithName("controllers").WithName("Service"),
+		Client:                  mgr.GetClient(),
+		ServiceInfoMap:          serviceInfoMap,
+		EndpointPodMap:          endpointPodMap,
+		Lock:                    endpointPodMutex,
+		ServiceInfoMapMutex:     serviceInfoMutex,
+		PodReconciler:           podReconciler,
+		EndpointSlices:          endpointSlices,
+		Namespaces:              namespaces,
+		MaxConcurrentReconciles: cfg.Concurrency.Services,
+		RateLimiter:             newRateLimiter(&cfg),
+		Log:                     ctrl.Log.WithName("controllers").WithName("Service"),
 	}
 	if err = (serviceReconciler).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Service")
 		os.Exit(1)
 	}
-	if err = (&controllers.IngressReconciler{
-		CloudSDK:            awsSdk,
-		Client:              mgr.GetClient(),
-		ServiceInfoMap:      serviceInfoMap,
-		ServiceInfoMapMutex: serviceInfoMutex,
-		ServiceReconciler:   serviceReconciler,
-		Log:                 ctrl.Log.WithName("controllers").WithName("Ingress"),
-	}).SetupWithManager(mgr); err != nil {
+	endpointGroupCache := cloud.NewEndpointGroupCache(awsSdk, cfg.Timeouts.EndpointGroupRefresh.Duration, ctrl.Log.WithName("cloud").WithName("EndpointGroupCache"))
+	if err := mgr.Add(endpointGroupCache); err != nil {
+		setupLog.Error(err, "unable to add endpoint group cache")
+		os.Exit(1)
+	}
+	ingressReconciler := &controllers.IngressReconciler{
+		EndpointGroupCache:      endpointGroupCache,
+		Client:                  mgr.GetClient(),
+		ServiceInfoMap:          serviceInfoMap,
+		ServiceInfoMapMutex:     serviceInfoMutex,
+		ServiceReconciler:       serviceReconciler,
+		PodReconciler:           podReconciler,
+		Namespaces:              namespaces,
+		Roles:                   &cloud.RoleMapping{Roles: cfg.AWS.Roles, Namespaces: cfg.AWS.NamespaceRoles},
+		MaxConcurrentReconciles: cfg.Concurrency.Ingresses,
+		RateLimiter:             newRateLimiter(&cfg),
+		Log:                     ctrl.Log.WithName("controllers").WithName("Ingress"),
+	}
+	if err = ingressReconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Ingress")
 		os.Exit(1)
 	}
-	if err = (&controllers.EndpointsReconciler{
+	// +kubebuilder:scaffold:builder
+
+	// the pod reconciler waits for the warm-up, which runs on the leader only
+	podReconciler.Warmup = &controllers.Warmup{
 		Client:            mgr.GetClient(),
-		EndpointPodMap:    endpointPodMap,
+		IngressReconciler: ingressReconciler,
 		ServiceReconciler: serviceReconciler,
-		EndpointPodMutex:  endpointPodMutex,
-		Log:               ctrl.Log.WithName("controllers").WithName("Endpoints"),
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Endpoints")
+		PodReconciler:     podReconciler,
+		Log:               ctrl.Log.WithName("controllers").WithName("Warmup"),
+	}
+	if err := mgr.Add(podReconciler.Warmup); err != nil {
+		setupLog.Error(err, "unable to add state warm-up")
 		os.Exit(1)
 	}
-	// +kubebuilder:scaffold:builder
+	if mode != "" {
+		if shardIdentity == "" {
+			if shardIdentity, err = os.Hostname(); err != nil {
+				setupLog.Error(err, "unable to determine shard identity")
+				os.Exit(1)
+			}
+		}
+		// the leases of the other replicas are read directly, the manager cache might not cover their namespace
+		leaseClient, err := client.New(mgr.GetConfig(), client.Options{Scheme: scheme, Mapper: mgr.GetRESTMapper()})
+		if err != nil {
+			setupLog.Error(err, "unable to create shard lease client")
+			os.Exit(1)
+		}
+		podReconciler.Shard = &sharding.Coordinator{
+			Client:        leaseClient,
+			Log:           ctrl.Log.WithName("sharding"),
+			Mode:          mode,
+			Namespace:     shardNamespace,
+			Group:         shardGroup,
+			Identity:      shardIdentity,
+			LeaseDuration: shardLeaseDuration,
+			RenewInterval: shardLeaseDuration / 3,
+			OnRebalance: func() {
+				if err := podReconciler.EnqueueAll(); err != nil {
+					setupLog.Error(err, "unable to enqueue pods after rebalancing")
+				}
+			},
+		}
+		if err := mgr.Add(podReconciler.Shard); err != nil {
+			setupLog.Error(err, "unable to add shard coordinator")
+			os.Exit(1)
+		}
+	}
+
+	if configWatcher != nil {
+		configWatcher.OnChange = func(cfg *config.Config) {
+			if reconfigurable, ok := awsCloud.(*aws.Cloud); ok {
+				reconfigurable.Reconfigure(awsOptions(cfg))
+			}
+			namespaces.Update(cfg.Namespaces.Include, cfg.Namespaces.Exclude, cfg.NamespaceSelector())
+			ingressReconciler.Roles.Update(cfg.AWS.Roles, cfg.AWS.NamespaceRoles)
+			if !podReconciler.Warmup.Elected() {
+				// followers do not reconcile, the warm-up applies the filter once they lead
+				return
+			}
+			if err := ingressReconciler.EnqueueAll(); err != nil {
+				setupLog.Error(err, "unable to enqueue ingresses after configuration change")
+			}
+		}
+		if err := mgr.Add(configWatcher); err != nil {
+			setupLog.Error(err, "unable to add configuration watcher")
+			os.Exit(1)
+		}
+	}
+
+	cacheSync := &health.CacheSync{Cache: mgr.GetCache()}
+	if err := mgr.Add(cacheSync); err != nil {
+		setupLog.Error(err, "unable to add cache sync check")
+		os.Exit(1)
+	}
+	healthServer := health.NewServer(healthProbeAddr, ctrl.Log.WithName("health"))
+	healthServer.AddLivenessCheck("ping", health.Ping)
+	healthServer.AddReadinessCheck("informers", cacheSync.Check)
+	healthServer.AddReadinessCheck("cloud", health.CloudCheck(awsSdk, 5*time.Second))
+
+	stop := ctrl.SetupSignalHandler()
+	go func() {
+		if err := healthServer.Start(stop); err != nil {
+			setupLog.Error(err, "problem running health server")
+			os.Exit(1)
+		}
+	}()
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	if err := mgr.Start(stop); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
 }
+
+// splitList splits a comma separated flag value, ignoring empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// splitMap splits a comma separated flag value of key=value pairs
+func splitMap(value string) (map[string]string, error) {
+	var items map[string]string
+	for _, item := range splitList(value) {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", item)
+		}
+		if items == nil {
+			items = map[string]string{}
+		}
+		items[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return items, nil
+}
+
+func awsOptions(cfg *config.Config) aws.Options {
+	return aws.Options{
+		Region:               cfg.AWS.Region,
+		AssumeRoleArn:        cfg.AWS.AssumeRoleArn,
+		WebIdentityTokenFile: cfg.AWS.WebIdentityTokenFile,
+		WebIdentityRoleArn:   cfg.AWS.WebIdentityRoleArn,
+		CacheEnabled:         cfg.Cache.Enabled,
+		Endpoints: aws.Endpoints{
+			ELBV2: cfg.AWS.Endpoints.ELBV2,
+			EC2:   cfg.AWS.Endpoints.EC2,
+			STS:   cfg.AWS.Endpoints.STS,
+		},
+		CacheTTLs: &aws.CacheTTLs{
+			DescribeLoadBalancers: cfg.Cache.LoadBalancerTTL.Duration,
+			DescribeTargetGroups:  cfg.Cache.TargetGroupTTL.Duration,
+			DescribeTargetHealth:  cfg.Cache.TargetHealthTTL.Duration,
+			LoadBalancerNotFound:  cfg.Cache.NotFoundTTL.Duration,
+		},
+		CallTimeout: cfg.Timeouts.AWSCall.Duration,
+		QPS:         cfg.CloudRateLimit.QPS,
+		Burst:       cfg.CloudRateLimit.Burst,
+	}
+}
+
+// verifyAWSCredentials logs the identity of the default role and of all mapped roles, it fails if
+// any of them can not be resolved
+func verifyAWSCredentials(sdk *aws.Cloud, roles map[string]string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	aliases := []string{""}
+	for alias := range roles {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	var failed []string
+	for _, alias := range aliases {
+		identity, err := sdk.CallerIdentity(ctx, roles[alias])
+		if err != nil {
+			setupLog.Error(err, "unable to resolve AWS identity", "role", alias)
+			failed = append(failed, fmt.Sprintf("%q", alias))
+			continue
+		}
+		setupLog.Info("resolved AWS identity", "role", alias, "account", identity.Account, "arn", identity.Arn)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("roles %s failed", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// newRateLimiter returns a new workqueue rate limiter, every controller needs its own
+func newRateLimiter(cfg *config.Config) workqueue.RateLimiter {
+	return controllers.NewRateLimiter(cfg.Workqueue.BaseDelay.Duration, cfg.Workqueue.MaxDelay.Duration, cfg.Workqueue.QPS, cfg.Workqueue.Burst)
+}
@@ -0,0 +1,501 @@
+package awstest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPageSize is the number of items of a describe response without page size
+const DefaultPageSize = 400
+
+// LoadBalancer is an application load balancer of the server
+type LoadBalancer struct {
+	ARN     string
+	Name    string
+	DNSName string
+}
+
+// TargetGroup is a target group of the server. Zero values are replaced with the aws defaults.
+type TargetGroup struct {
+	ARN        string
+	Name       string
+	TargetType string
+	// HealthCheckInterval is the time between two health checks of a target
+	HealthCheckInterval time.Duration
+	// HealthyThreshold is the number of passed health checks before a new target is healthy
+	HealthyThreshold int
+	// DeregistrationDelay is the time a deregistered target drains before it is removed
+	DeregistrationDelay time.Duration
+
+	loadBalancerArn string
+	targets         []*target
+}
+
+type target struct {
+	id             string
+	port           int64
+	registeredAt   time.Time
+	deregisteredAt time.Time
+}
+
+// Health states of targets as reported by DescribeTargetHealth
+const (
+	StateInitial   = "initial"
+	StateHealthy   = "healthy"
+	StateUnhealthy = "unhealthy"
+	StateDraining  = "draining"
+	StateUnused    = "unused"
+)
+
+// AddLoadBalancer creates an internet-facing application load balancer in the given region
+func (s *Server) AddLoadBalancer(region, name string) *LoadBalancer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ids++
+	lb := &LoadBalancer{
+		ARN:     fmt.Sprintf("arn:aws:elasticloadbalancing:%s:%s:loadbalancer/app/%s/%016x", region, Account, name, s.ids),
+		Name:    name,
+		DNSName: fmt.Sprintf("%s-%d.%s.elb.amazonaws.com", name, 1000000000+s.ids, region),
+	}
+	s.loadBalancers = append(s.loadBalancers, lb)
+	return lb
+}
+
+// AddTargetGroup creates a target group forwarded to by the load balancer
+func (s *Server) AddTargetGroup(lb *LoadBalancer, tg TargetGroup) *TargetGroup {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ids++
+	region := strings.Split(lb.ARN, ":")[3]
+	if tg.Name == "" {
+		tg.Name = fmt.Sprintf("tg-%d", s.ids)
+	}
+	if tg.TargetType == "" {
+		tg.TargetType = "ip"
+	}
+	if tg.HealthCheckInterval == 0 {
+		tg.HealthCheckInterval = 30 * time.Second
+	}
+	if tg.HealthyThreshold == 0 {
+		tg.HealthyThreshold = 5
+	}
+	if tg.DeregistrationDelay == 0 {
+		tg.DeregistrationDelay = 300 * time.Second
+	}
+	tg.ARN = fmt.Sprintf("arn:aws:elasticloadbalancing:%s:%s:targetgroup/%s/%016x", region, Account, tg.Name, s.ids)
+	tg.loadBalancerArn = lb.ARN
+	created := tg
+	s.targetGroups = append(s.targetGroups, &created)
+	return &created
+}
+
+// RegisterTarget adds a target to a target group, it becomes healthy once it passed its health checks
+func (s *Server) RegisterTarget(targetGroupArn, id string, port int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tg, ok := s.targetGroup(targetGroupArn)
+	if !ok {
+		return fmt.Errorf("target group %q not found", targetGroupArn)
+	}
+	s.register(tg, id, port)
+	return nil
+}
+
+// DeregisterTarget lets a target drain, it is removed once the deregistration delay passed
+func (s *Server) DeregisterTarget(targetGroupArn, id string, port int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tg, ok := s.targetGroup(targetGroupArn)
+	if !ok {
+		return fmt.Errorf("target group %q not found", targetGroupArn)
+	}
+	t := s.findTarget(tg, id, port)
+	if t == nil || s.state(tg, t) == StateUnused {
+		return fmt.Errorf("target %s:%d is not registered", id, port)
+	}
+	if t.deregisteredAt.IsZero() {
+		t.deregisteredAt = s.now()
+	}
+	return nil
+}
+
+// TargetHealth is a registered target and its health state
+type TargetHealth struct {
+	ID    string
+	Port  int64
+	State string
+}
+
+// Targets returns the targets of a target group which are not unused, sorted by id and port
+func (s *Server) Targets(targetGroupArn string) []TargetHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tg, ok := s.targetGroup(targetGroupArn)
+	if !ok {
+		return nil
+	}
+	var targets []TargetHealth
+	for _, t := range tg.targets {
+		if state := s.state(tg, t); state != StateUnused {
+			targets = append(targets, TargetHealth{ID: t.id, Port: t.port, State: state})
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].ID != targets[j].ID {
+			return targets[i].ID < targets[j].ID
+		}
+		return targets[i].Port < targets[j].Port
+	})
+	return targets
+}
+
+// SetHealthy lets the health checks of a target id in all target groups pass or fail, targets are healthy by default
+func (s *Server) SetHealthy(id string, healthy bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unhealthy[id] = !healthy
+}
+
+// Advance moves the clock of the server, e.g. to let health checks pass or targets drain
+func (s *Server) Advance(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset += d
+}
+
+// TargetState returns the health state of a target
+func (s *Server) TargetState(targetGroupArn, id string, port int64) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tg, ok := s.targetGroup(targetGroupArn)
+	if !ok {
+		return StateUnused
+	}
+	return s.state(tg, s.findTarget(tg, id, port))
+}
+
+func (s *Server) now() time.Time {
+	return time.Now().Add(s.offset)
+}
+
+func (s *Server) targetGroup(arn string) (*TargetGroup, bool) {
+	for _, tg := range s.targetGroups {
+		if tg.ARN == arn {
+			return tg, true
+		}
+	}
+	return nil, false
+}
+
+func (s *Server) findTarget(tg *TargetGroup, id string, port int64) *target {
+	for _, t := range tg.targets {
+		if t.id == id && t.port == port {
+			return t
+		}
+	}
+	return nil
+}
+
+func (s *Server) register(tg *TargetGroup, id string, port int64) {
+	if t := s.findTarget(tg, id, port); t != nil && t.deregisteredAt.IsZero() {
+		return
+	}
+	s.removeTarget(tg, id, port)
+	tg.targets = append(tg.targets, &target{id: id, port: port, registeredAt: s.now()})
+}
+
+func (s *Server) removeTarget(tg *TargetGroup, id string, port int64) {
+	for i, t := range tg.targets {
+		if t.id == id && t.port == port {
+			tg.targets = append(tg.targets[:i], tg.targets[i+1:]...)
+			return
+		}
+	}
+}
+
+// state derives the health state of a target from the clock
+func (s *Server) state(tg *TargetGroup, t *target) string {
+	now := s.now()
+	switch {
+	case t == nil:
+		return StateUnused
+	case !t.deregisteredAt.IsZero() && now.Sub(t.deregisteredAt) >= tg.DeregistrationDelay:
+		return StateUnused
+	case !t.deregisteredAt.IsZero():
+		return StateDraining
+	case now.Sub(t.registeredAt) < tg.HealthCheckInterval*time.Duration(tg.HealthyThreshold):
+		return StateInitial
+	case s.unhealthy[t.id]:
+		return StateUnhealthy
+	}
+	return StateHealthy
+}
+
+// page returns the items of the page the request asks for and the marker of the next page
+func (s *Server) page(form url.Values, n int) (start, end int, next string, err error) {
+	size := s.PageSize
+	if value := form.Get("PageSize"); value != "" {
+		if size, err = strconv.Atoi(value); err != nil || size < 1 {
+			return 0, 0, "", fmt.Errorf("invalid page size %q", value)
+		}
+	}
+	if size == 0 {
+		size = DefaultPageSize
+	}
+	if marker := form.Get("Marker"); marker != "" {
+		if start, err = strconv.Atoi(marker); err != nil || start > n {
+			return 0, 0, "", fmt.Errorf("invalid marker %q", marker)
+		}
+	}
+	end = start + size
+	if end >= n {
+		return start, n, "", nil
+	}
+	return start, end, strconv.Itoa(end), nil
+}
+
+// members returns the values of a list parameter, e.g. Names.member.1
+func members(form url.Values, name string) []string {
+	var values []string
+	for i := 1; ; i++ {
+		value, ok := form[fmt.Sprintf("%s.member.%d", name, i)]
+		if !ok {
+			return values
+		}
+		values = append(values, value[0])
+	}
+}
+
+// targetMembers returns the targets of a request, the port defaults to the given one
+func targetMembers(form url.Values) ([]target, error) {
+	var targets []target
+	for i := 1; ; i++ {
+		id := form.Get(fmt.Sprintf("Targets.member.%d.Id", i))
+		if id == "" {
+			return targets, nil
+		}
+		port, err := strconv.ParseInt(form.Get(fmt.Sprintf("Targets.member.%d.Port", i)), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("target %q has no valid port", id)
+		}
+		targets = append(targets, target{id: id, port: port})
+	}
+}
+
+type loadBalancerXML struct {
+	LoadBalancerArn  string `xml:"LoadBalancerArn"`
+	LoadBalancerName string `xml:"LoadBalancerName"`
+	DNSName          string `xml:"DNSName"`
+	Type             string `xml:"Type"`
+}
+
+type describeLoadBalancersResponse struct {
+	XMLName       xml.Name          `xml:"DescribeLoadBalancersResponse"`
+	LoadBalancers []loadBalancerXML `xml:"DescribeLoadBalancersResult>LoadBalancers>member"`
+	NextMarker    string            `xml:"DescribeLoadBalancersResult>NextMarker,omitempty"`
+}
+
+func (s *Server) describeLoadBalancers(w http.ResponseWriter, r *http.Request) {
+	names := members(r.PostForm, "Names")
+	s.mu.Lock()
+	var found []*LoadBalancer
+	for _, lb := range s.loadBalancers {
+		if len(names) == 0 || contains(names, lb.Name) {
+			found = append(found, lb)
+		}
+	}
+	s.mu.Unlock()
+	if len(names) > 0 && len(found) < len(names) {
+		writeError(w, http.StatusBadRequest, "LoadBalancerNotFound", "one or more load balancers not found")
+		return
+	}
+	start, end, next, err := s.page(r.PostForm, len(found))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "ValidationError", err.Error())
+		return
+	}
+	response := describeLoadBalancersResponse{NextMarker: next}
+	for _, lb := range found[start:end] {
+		response.LoadBalancers = append(response.LoadBalancers, loadBalancerXML{
+			LoadBalancerArn:  lb.ARN,
+			LoadBalancerName: lb.Name,
+			DNSName:          lb.DNSName,
+			Type:             "application",
+		})
+	}
+	writeXML(w, response)
+}
+
+type targetGroupXML struct {
+	TargetGroupArn             string   `xml:"TargetGroupArn"`
+	TargetGroupName            string   `xml:"TargetGroupName"`
+	TargetType                 string   `xml:"TargetType"`
+	HealthCheckIntervalSeconds int64    `xml:"HealthCheckIntervalSeconds"`
+	HealthyThresholdCount      int      `xml:"HealthyThresholdCount"`
+	LoadBalancerArns           []string `xml:"LoadBalancerArns>member"`
+}
+
+type describeTargetGroupsResponse struct {
+	XMLName      xml.Name         `xml:"DescribeTargetGroupsResponse"`
+	TargetGroups []targetGroupXML `xml:"DescribeTargetGroupsResult>TargetGroups>member"`
+	NextMarker   string           `xml:"DescribeTargetGroupsResult>NextMarker,omitempty"`
+}
+
+func (s *Server) describeTargetGroups(w http.ResponseWriter, r *http.Request) {
+	loadBalancerArn := r.PostForm.Get("LoadBalancerArn")
+	s.mu.Lock()
+	var found []*TargetGroup
+	known := loadBalancerArn == ""
+	for _, lb := range s.loadBalancers {
+		known = known || lb.ARN == loadBalancerArn
+	}
+	for _, tg := range s.targetGroups {
+		if loadBalancerArn == "" || tg.loadBalancerArn == loadBalancerArn {
+			found = append(found, tg)
+		}
+	}
+	s.mu.Unlock()
+	if !known {
+		writeError(w, http.StatusBadRequest, "LoadBalancerNotFound", fmt.Sprintf("load balancer %q not found", loadBalancerArn))
+		return
+	}
+	start, end, next, err := s.page(r.PostForm, len(found))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "ValidationError", err.Error())
+		return
+	}
+	response := describeTargetGroupsResponse{NextMarker: next}
+	for _, tg := range found[start:end] {
+		response.TargetGroups = append(response.TargetGroups, targetGroupXML{
+			TargetGroupArn:             tg.ARN,
+			TargetGroupName:            tg.Name,
+			TargetType:                 tg.TargetType,
+			HealthCheckIntervalSeconds: int64(tg.HealthCheckInterval / time.Second),
+			HealthyThresholdCount:      tg.HealthyThreshold,
+			LoadBalancerArns:           []string{tg.loadBalancerArn},
+		})
+	}
+	writeXML(w, response)
+}
+
+type targetHealthDescriptionXML struct {
+	ID     string `xml:"Target>Id"`
+	Port   int64  `xml:"Target>Port"`
+	State  string `xml:"TargetHealth>State"`
+	Reason string `xml:"TargetHealth>Reason,omitempty"`
+}
+
+type describeTargetHealthResponse struct {
+	XMLName                  xml.Name                     `xml:"DescribeTargetHealthResponse"`
+	TargetHealthDescriptions []targetHealthDescriptionXML `xml:"DescribeTargetHealthResult>TargetHealthDescriptions>member"`
+}
+
+var stateReasons = map[string]string{
+	StateInitial:   "Elb.InitialHealthChecking",
+	StateUnhealthy: "Target.FailedHealthChecks",
+	StateDraining:  "Target.DeregistrationInProgress",
+	StateUnused:    "Target.NotRegistered",
+}
+
+func (s *Server) describeTargetHealth(w http.ResponseWriter, r *http.Request) {
+	targets, err := targetMembers(r.PostForm)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "ValidationError", err.Error())
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tg, ok := s.targetGroup(r.PostForm.Get("TargetGroupArn"))
+	if !ok {
+		writeError(w, http.StatusBadRequest, "TargetGroupNotFound", "target group not found")
+		return
+	}
+	if len(targets) == 0 {
+		for _, t := range tg.targets {
+			if s.state(tg, t) != StateUnused {
+				targets = append(targets, *t)
+			}
+		}
+		sort.Slice(targets, func(i, j int) bool { return targets[i].id < targets[j].id })
+	}
+	var response describeTargetHealthResponse
+	for _, t := range targets {
+		state := s.state(tg, s.findTarget(tg, t.id, t.port))
+		response.TargetHealthDescriptions = append(response.TargetHealthDescriptions, targetHealthDescriptionXML{
+			ID:     t.id,
+			Port:   t.port,
+			State:  state,
+			Reason: stateReasons[state],
+		})
+	}
+	writeXML(w, response)
+}
+
+type registerTargetsResponse struct {
+	XMLName xml.Name `xml:"RegisterTargetsResponse"`
+}
+
+func (s *Server) registerTargets(w http.ResponseWriter, r *http.Request) {
+	targets, err := targetMembers(r.PostForm)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "ValidationError", err.Error())
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tg, ok := s.targetGroup(r.PostForm.Get("TargetGroupArn"))
+	if !ok {
+		writeError(w, http.StatusBadRequest, "TargetGroupNotFound", "target group not found")
+		return
+	}
+	for _, t := range targets {
+		s.register(tg, t.id, t.port)
+	}
+	writeXML(w, registerTargetsResponse{})
+}
+
+type deregisterTargetsResponse struct {
+	XMLName xml.Name `xml:"DeregisterTargetsResponse"`
+}
+
+func (s *Server) deregisterTargets(w http.ResponseWriter, r *http.Request) {
+	targets, err := targetMembers(r.PostForm)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "ValidationError", err.Error())
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tg, ok := s.targetGroup(r.PostForm.Get("TargetGroupArn"))
+	if !ok {
+		writeError(w, http.StatusBadRequest, "TargetGroupNotFound", "target group not found")
+		return
+	}
+	for _, t := range targets {
+		if registered := s.findTarget(tg, t.id, t.port); registered == nil || s.state(tg, registered) == StateUnused {
+			writeError(w, http.StatusBadRequest, "InvalidTarget", fmt.Sprintf("target %s:%d is not registered", t.id, t.port))
+			return
+		}
+	}
+	for _, t := range targets {
+		if registered := s.findTarget(tg, t.id, t.port); registered.deregisteredAt.IsZero() {
+			registered.deregisteredAt = s.now()
+		}
+	}
+	writeXML(w, deregisterTargetsResponse{})
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
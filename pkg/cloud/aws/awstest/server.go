@@ -0,0 +1,189 @@
+// Package awstest provides an in-process stand-in for the aws apis used by the controller, so that
+// the aws provider can be tested without an aws account. Point a session at it with Config.
+package awstest
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	awssdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+const (
+	// AccessKeyID of the static credentials of Config
+	AccessKeyID = "AKIAAWSTEST"
+	// Account of the static credentials of Config
+	Account = "123456789012"
+	// UserArn is the identity of the static credentials of Config
+	UserArn = "arn:aws:iam::" + Account + ":user/awstest"
+)
+
+// Server answers aws query api requests of sts and elbv2, the instance metadata and counts the calls per action
+type Server struct {
+	*httptest.Server
+	// Region is reported by the instance metadata, the metadata is unavailable if it is empty
+	Region string
+	// PageSize is the number of items of describe responses without page size, DefaultPageSize if zero
+	PageSize int
+
+	mu sync.Mutex
+	// sessions are the identities of issued temporary credentials by access key id
+	sessions map[string]identity
+	// webIdentityTokens are the tokens credentials were requested with
+	webIdentityTokens []string
+	calls             map[string]int
+
+	loadBalancers []*LoadBalancer
+	targetGroups  []*TargetGroup
+	// unhealthy targets fail their health checks
+	unhealthy map[string]bool
+	// offset of the clock from the time of the process
+	offset time.Duration
+	// ids is the last id of a created resource
+	ids int
+}
+
+type identity struct {
+	account string
+	arn     string
+	userID  string
+}
+
+// NewServer starts a server, it has to be closed by the caller
+func NewServer() *Server {
+	s := New()
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// New returns a server which is not listening, e.g. to serve it as handler of an own http server.
+// Config is not available without a listener.
+func New() *Server {
+	return &Server{
+		sessions:  map[string]identity{},
+		calls:     map[string]int{},
+		unhealthy: map[string]bool{},
+	}
+}
+
+// Config returns the config of a session which sends all requests to the server with static credentials
+func (s *Server) Config() *awssdk.Config {
+	return awssdk.NewConfig().
+		WithEndpoint(s.URL).
+		WithCredentials(credentials.NewStaticCredentials(AccessKeyID, "secret", "")).
+		WithMaxRetries(0)
+}
+
+// Calls returns the number of requests of an action, e.g. GetCallerIdentity
+func (s *Server) Calls(action string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[action]
+}
+
+// WebIdentityTokens returns all web identity tokens exchanged for credentials
+func (s *Server) WebIdentityTokens() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.webIdentityTokens...)
+}
+
+// ServeHTTP answers the requests of the apis
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPut && r.URL.Path == "/api/token":
+		s.serveMetadataToken(w)
+	case r.Method == http.MethodGet && r.URL.Path == "/dynamic/instance-identity/document":
+		s.serveInstanceIdentity(w)
+	case r.Method == http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			writeError(w, http.StatusBadRequest, "MalformedQueryString", err.Error())
+			return
+		}
+		action := r.PostForm.Get("Action")
+		s.mu.Lock()
+		s.calls[action]++
+		s.mu.Unlock()
+		handler, ok := s.actions()[action]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "InvalidAction", fmt.Sprintf("action %q is not supported", action))
+			return
+		}
+		handler(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// actions returns the handlers of the query api actions by name
+func (s *Server) actions() map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		"GetCallerIdentity":         s.getCallerIdentity,
+		"AssumeRole":                s.assumeRole,
+		"AssumeRoleWithWebIdentity": s.assumeRoleWithWebIdentity,
+		"DescribeLoadBalancers":     s.describeLoadBalancers,
+		"DescribeTargetGroups":      s.describeTargetGroups,
+		"DescribeTargetHealth":      s.describeTargetHealth,
+		"RegisterTargets":           s.registerTargets,
+		"DeregisterTargets":         s.deregisterTargets,
+	}
+}
+
+func (s *Server) serveMetadataToken(w http.ResponseWriter) {
+	if s.Region == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "21600")
+	fmt.Fprint(w, "awstest-metadata-token")
+}
+
+func (s *Server) serveInstanceIdentity(w http.ResponseWriter) {
+	if s.Region == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"region":           s.Region,
+		"availabilityZone": s.Region + "a",
+		"instanceId":       "i-0123456789abcdef0",
+		"accountId":        Account,
+	})
+}
+
+// accessKeyID returns the access key a request is signed with
+func accessKeyID(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	i := strings.Index(auth, "Credential=")
+	if i < 0 {
+		return ""
+	}
+	credential := auth[i+len("Credential="):]
+	return credential[:strings.Index(credential, "/")]
+}
+
+// writeXML writes a query api response
+func writeXML(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", "text/xml")
+	xml.NewEncoder(w).Encode(response)
+}
+
+type errorResponse struct {
+	XMLName xml.Name `xml:"ErrorResponse"`
+	Type    string   `xml:"Error>Type"`
+	Code    string   `xml:"Error>Code"`
+	Message string   `xml:"Error>Message"`
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(errorResponse{Type: "Sender", Code: code, Message: message})
+}
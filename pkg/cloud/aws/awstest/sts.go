@@ -0,0 +1,110 @@
+package awstest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type credentialsXML struct {
+	AccessKeyID     string `xml:"AccessKeyId"`
+	SecretAccessKey string `xml:"SecretAccessKey"`
+	SessionToken    string `xml:"SessionToken"`
+	Expiration      string `xml:"Expiration"`
+}
+
+type getCallerIdentityResponse struct {
+	XMLName xml.Name `xml:"GetCallerIdentityResponse"`
+	Arn     string   `xml:"GetCallerIdentityResult>Arn"`
+	UserID  string   `xml:"GetCallerIdentityResult>UserId"`
+	Account string   `xml:"GetCallerIdentityResult>Account"`
+}
+
+type assumeRoleResponse struct {
+	XMLName         xml.Name       `xml:"AssumeRoleResponse"`
+	Credentials     credentialsXML `xml:"AssumeRoleResult>Credentials"`
+	AssumedRoleUser string         `xml:"AssumeRoleResult>AssumedRoleUser>Arn"`
+}
+
+type assumeRoleWithWebIdentityResponse struct {
+	XMLName         xml.Name       `xml:"AssumeRoleWithWebIdentityResponse"`
+	Credentials     credentialsXML `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	AssumedRoleUser string         `xml:"AssumeRoleWithWebIdentityResult>AssumedRoleUser>Arn"`
+}
+
+// GetCallerIdentity answers with the identity of the credentials the request is signed with
+func (s *Server) getCallerIdentity(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	caller, ok := s.sessions[accessKeyID(r)]
+	s.mu.Unlock()
+	if !ok {
+		if accessKeyID(r) != AccessKeyID {
+			writeError(w, http.StatusForbidden, "InvalidClientTokenId", "the security token included in the request is invalid")
+			return
+		}
+		caller = identity{account: Account, arn: UserArn, userID: "AIDAAWSTEST"}
+	}
+	writeXML(w, getCallerIdentityResponse{Arn: caller.arn, UserID: caller.userID, Account: caller.account})
+}
+
+// AssumeRole issues credentials of a role to callers with valid credentials
+func (s *Server) assumeRole(w http.ResponseWriter, r *http.Request) {
+	key := accessKeyID(r)
+	s.mu.Lock()
+	_, ok := s.sessions[key]
+	s.mu.Unlock()
+	if !ok && key != AccessKeyID {
+		writeError(w, http.StatusForbidden, "InvalidClientTokenId", "the security token included in the request is invalid")
+		return
+	}
+	assumed, credentials, err := s.issue(r.PostForm.Get("RoleArn"), r.PostForm.Get("RoleSessionName"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "ValidationError", err.Error())
+		return
+	}
+	writeXML(w, assumeRoleResponse{Credentials: credentials, AssumedRoleUser: assumed.arn})
+}
+
+// AssumeRoleWithWebIdentity issues credentials of a role for any non-empty token
+func (s *Server) assumeRoleWithWebIdentity(w http.ResponseWriter, r *http.Request) {
+	token := r.PostForm.Get("WebIdentityToken")
+	if token == "" {
+		writeError(w, http.StatusBadRequest, "InvalidIdentityToken", "no web identity token")
+		return
+	}
+	s.mu.Lock()
+	s.webIdentityTokens = append(s.webIdentityTokens, token)
+	s.mu.Unlock()
+	assumed, credentials, err := s.issue(r.PostForm.Get("RoleArn"), r.PostForm.Get("RoleSessionName"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "ValidationError", err.Error())
+		return
+	}
+	writeXML(w, assumeRoleWithWebIdentityResponse{Credentials: credentials, AssumedRoleUser: assumed.arn})
+}
+
+// issue creates temporary credentials of a role arn of the form arn:aws:iam::<account>:role/<name>
+func (s *Server) issue(roleArn, sessionName string) (identity, credentialsXML, error) {
+	parts := strings.SplitN(roleArn, ":", 6)
+	if len(parts) != 6 || !strings.HasPrefix(parts[5], "role/") {
+		return identity{}, credentialsXML{}, fmt.Errorf("%q is not a role arn", roleArn)
+	}
+	account, role := parts[4], strings.TrimPrefix(parts[5], "role/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := fmt.Sprintf("ASIAAWSTEST%d", len(s.sessions)+1)
+	assumed := identity{
+		account: account,
+		arn:     fmt.Sprintf("arn:aws:sts::%s:assumed-role/%s/%s", account, role, sessionName),
+		userID:  "AROAAWSTEST:" + sessionName,
+	}
+	s.sessions[key] = assumed
+	return assumed, credentialsXML{
+		AccessKeyID:     key,
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+		Expiration:      time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+	}, nil
+}
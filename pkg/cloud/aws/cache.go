@@ -0,0 +1,176 @@
+package aws
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	opDescribeLoadBalancers = "DescribeLoadBalancers"
+	opDescribeTargetGroups  = "DescribeTargetGroups"
+	opDescribeTargetHealth  = "DescribeTargetHealth"
+)
+
+// CacheTTLs configures how long the results of the individual api operations are cached.
+// A zero ttl disables caching for the operation.
+type CacheTTLs struct {
+	DescribeLoadBalancers time.Duration
+	DescribeTargetGroups  time.Duration
+	DescribeTargetHealth  time.Duration
+	// LoadBalancerNotFound is the ttl of negative lookups of load balancers
+	LoadBalancerNotFound time.Duration
+}
+
+// DefaultCacheTTLs are used when the sdk cache is enabled without further configuration
+var DefaultCacheTTLs = CacheTTLs{
+	DescribeLoadBalancers: time.Minute,
+	DescribeTargetGroups:  time.Minute,
+	DescribeTargetHealth:  10 * time.Second,
+	LoadBalancerNotFound:  30 * time.Second,
+}
+
+type cacheEntry struct {
+	value   interface{}
+	err     error
+	expires time.Time
+}
+
+// ttlCache caches the results of a single api operation
+type ttlCache struct {
+	operation string
+	ttl       time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]cacheEntry
+	lastSweep time.Time
+}
+
+func newTTLCache(operation string, ttl time.Duration, now func() time.Time) *ttlCache {
+	return &ttlCache{
+		operation: operation,
+		ttl:       ttl,
+		now:       now,
+		entries:   map[string]cacheEntry{},
+	}
+}
+
+// Get returns a cached result. Cached errors are returned with ok set to true.
+func (c *ttlCache) Get(key string) (value interface{}, err error, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl <= 0 && len(c.entries) == 0 {
+		return nil, nil, false
+	}
+	entry, found := c.entries[key]
+	if !found || !c.now().Before(entry.expires) {
+		cacheRequests.WithLabelValues(c.operation, "miss").Inc()
+		return nil, nil, false
+	}
+	cacheRequests.WithLabelValues(c.operation, "hit").Inc()
+	return entry.value, entry.err, true
+}
+
+func (c *ttlCache) Set(key string, value interface{}) {
+	c.mu.Lock()
+	ttl := c.ttl
+	c.mu.Unlock()
+	c.set(key, value, nil, ttl)
+}
+
+// SetTTL changes the ttl of future entries. A zero ttl disables the cache and drops all entries.
+func (c *ttlCache) SetTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+	if ttl <= 0 {
+		c.entries = map[string]cacheEntry{}
+	}
+}
+
+// SetError caches a negative result for the given ttl
+func (c *ttlCache) SetError(key string, err error, ttl time.Duration) {
+	c.set(key, nil, err, ttl)
+}
+
+func (c *ttlCache) set(key string, value interface{}, err error, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	c.sweep(now)
+	c.entries[key] = cacheEntry{
+		value:   value,
+		err:     err,
+		expires: now.Add(ttl),
+	}
+}
+
+// sweep drops expired entries, at most once per ttl
+func (c *ttlCache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+	c.lastSweep = now
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Invalidate removes all entries whose key contains the given part
+func (c *ttlCache) Invalidate(part string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if strings.Contains(key, part) {
+			delete(c.entries, key)
+			cacheInvalidations.WithLabelValues(c.operation).Inc()
+		}
+	}
+}
+
+func (c *ttlCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// sdkCache holds the caches of all cached operations. Zero ttls disable caching.
+type sdkCache struct {
+	mu            sync.RWMutex
+	ttls          CacheTTLs
+	loadBalancers *ttlCache
+	targetGroups  *ttlCache
+	targetHealth  *ttlCache
+}
+
+func newSDKCache(ttls CacheTTLs, now func() time.Time) *sdkCache {
+	return &sdkCache{
+		ttls:          ttls,
+		loadBalancers: newTTLCache(opDescribeLoadBalancers, ttls.DescribeLoadBalancers, now),
+		targetGroups:  newTTLCache(opDescribeTargetGroups, ttls.DescribeTargetGroups, now),
+		targetHealth:  newTTLCache(opDescribeTargetHealth, ttls.DescribeTargetHealth, now),
+	}
+}
+
+func (s *sdkCache) TTLs() CacheTTLs {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ttls
+}
+
+// SetTTLs changes the ttls of future entries, cached entries keep their expiry unless the cache of
+// their operation got disabled
+func (s *sdkCache) SetTTLs(ttls CacheTTLs) {
+	s.mu.Lock()
+	s.ttls = ttls
+	s.mu.Unlock()
+	s.loadBalancers.SetTTL(ttls.DescribeLoadBalancers)
+	s.targetGroups.SetTTL(ttls.DescribeTargetGroups)
+	s.targetHealth.SetTTL(ttls.DescribeTargetHealth)
+}
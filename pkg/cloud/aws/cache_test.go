@@ -0,0 +1,212 @@
+package aws
+
+import (
+	"context"
+	"time"
+
+	awssdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/nirnanaaa/kube-readiness/pkg/cloud"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// stubELBV2 answers the elbv2 calls used by the cache from static data and counts them
+type stubELBV2 struct {
+	elbv2iface.ELBV2API
+	calls         map[string]int
+	loadBalancers map[string]*elbv2.LoadBalancer
+	targetGroups  map[string][]*elbv2.TargetGroup
+	targetHealth  map[string]string
+}
+
+func (s *stubELBV2) DescribeLoadBalancersPagesWithContext(_ awssdk.Context, input *elbv2.DescribeLoadBalancersInput, fn func(*elbv2.DescribeLoadBalancersOutput, bool) bool, _ ...request.Option) error {
+	s.calls[opDescribeLoadBalancers]++
+	out := &elbv2.DescribeLoadBalancersOutput{}
+	for _, name := range input.Names {
+		lb, ok := s.loadBalancers[*name]
+		if !ok {
+			return awserr.New(elbv2.ErrCodeLoadBalancerNotFoundException, "not found", nil)
+		}
+		out.LoadBalancers = append(out.LoadBalancers, lb)
+	}
+	fn(out, true)
+	return nil
+}
+
+func (s *stubELBV2) DescribeTargetGroupsPagesWithContext(_ awssdk.Context, input *elbv2.DescribeTargetGroupsInput, fn func(*elbv2.DescribeTargetGroupsOutput, bool) bool, _ ...request.Option) error {
+	s.calls[opDescribeTargetGroups]++
+	fn(&elbv2.DescribeTargetGroupsOutput{TargetGroups: s.targetGroups[*input.LoadBalancerArn]}, true)
+	return nil
+}
+
+func (s *stubELBV2) DescribeTargetHealthWithContext(_ awssdk.Context, input *elbv2.DescribeTargetHealthInput, _ ...request.Option) (*elbv2.DescribeTargetHealthOutput, error) {
+	s.calls[opDescribeTargetHealth]++
+	return &elbv2.DescribeTargetHealthOutput{
+		TargetHealthDescriptions: []*elbv2.TargetHealthDescription{{
+			Target:       input.Targets[0],
+			TargetHealth: &elbv2.TargetHealth{State: awssdk.String(s.targetHealth[*input.Targets[0].Id])},
+		}},
+	}, nil
+}
+
+func (s *stubELBV2) DeregisterTargetsWithContext(_ awssdk.Context, input *elbv2.DeregisterTargetsInput, _ ...request.Option) (*elbv2.DeregisterTargetsOutput, error) {
+	s.calls["DeregisterTargets"]++
+	s.targetHealth[*input.Targets[0].Id] = elbv2.TargetHealthStateEnumDraining
+	return &elbv2.DeregisterTargetsOutput{}, nil
+}
+
+var _ = Describe("SDK Cache", func() {
+	const hostname = "internal-test-lb-123456.eu-west-1.elb.amazonaws.com"
+	var (
+		stub   *stubELBV2
+		now    time.Time
+		sdk    *Cloud
+		groups []*cloud.EndpointGroup
+	)
+	newCloud := func(ttls CacheTTLs) *Cloud {
+		return &Cloud{
+			elbv2:  stub,
+			region: "eu-west-1",
+			log:    logf.NullLogger{},
+			cache:  newSDKCache(ttls, func() time.Time { return now }),
+		}
+	}
+	BeforeEach(func() {
+		now = time.Now()
+		stub = &stubELBV2{
+			calls: map[string]int{},
+			loadBalancers: map[string]*elbv2.LoadBalancer{
+				"test-lb": {LoadBalancerArn: awssdk.String("arn:lb"), DNSName: awssdk.String(hostname)},
+			},
+			targetGroups: map[string][]*elbv2.TargetGroup{
+				"arn:lb": {{TargetGroupArn: awssdk.String("arn:tg")}},
+			},
+			targetHealth: map[string]string{"10.0.0.1": elbv2.TargetHealthStateEnumInitial},
+		}
+		sdk = newCloud(DefaultCacheTTLs)
+		groups = []*cloud.EndpointGroup{{Name: "arn:tg"}}
+	})
+
+	It("should cache load balancer and target group lookups for their ttl", func() {
+		for i := 0; i < 3; i++ {
+			found, err := sdk.GetEndpointGroupsByHostname(context.TODO(), hostname)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(found).To(HaveLen(1))
+		}
+		Expect(stub.calls[opDescribeLoadBalancers]).To(Equal(1))
+		Expect(stub.calls[opDescribeTargetGroups]).To(Equal(1))
+
+		now = now.Add(DefaultCacheTTLs.DescribeLoadBalancers)
+		_, err := sdk.GetEndpointGroupsByHostname(context.TODO(), hostname)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stub.calls[opDescribeLoadBalancers]).To(Equal(2))
+		Expect(stub.calls[opDescribeTargetGroups]).To(Equal(2))
+	})
+
+	It("should honor per operation ttls", func() {
+		ttls := DefaultCacheTTLs
+		ttls.DescribeTargetGroups = 0
+		sdk = newCloud(ttls)
+		for i := 0; i < 2; i++ {
+			_, err := sdk.GetEndpointGroupsByHostname(context.TODO(), hostname)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(stub.calls[opDescribeLoadBalancers]).To(Equal(1))
+		Expect(stub.calls[opDescribeTargetGroups]).To(Equal(2))
+	})
+
+	It("should apply reconfigured ttls to new entries", func() {
+		ttls := DefaultCacheTTLs
+		ttls.DescribeTargetHealth = time.Minute
+		sdk.Reconfigure(Options{CacheEnabled: true, CacheTTLs: &ttls, CallTimeout: time.Second})
+		ctx, cancel := sdk.withCallTimeout(context.TODO())
+		defer cancel()
+		_, ok := ctx.Deadline()
+		Expect(ok).To(BeTrue())
+		_, err := sdk.IsEndpointHealthy(context.TODO(), groups, "10.0.0.1", []int32{80})
+		Expect(err).ToNot(HaveOccurred())
+		now = now.Add(30 * time.Second)
+		_, err = sdk.IsEndpointHealthy(context.TODO(), groups, "10.0.0.1", []int32{80})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stub.calls[opDescribeTargetHealth]).To(Equal(1))
+
+		By("disabling the cache")
+		sdk.Reconfigure(Options{})
+		_, err = sdk.IsEndpointHealthy(context.TODO(), groups, "10.0.0.1", []int32{80})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stub.calls[opDescribeTargetHealth]).To(Equal(2), "cached entries are dropped")
+		_, err = sdk.GetEndpointGroupsByHostname(context.TODO(), "internal-missing-123.eu-west-1.elb.amazonaws.com")
+		Expect(err).To(Equal(cloud.ErrLoadBalancerNotFound))
+		_, err = sdk.GetEndpointGroupsByHostname(context.TODO(), "internal-missing-123.eu-west-1.elb.amazonaws.com")
+		Expect(err).To(Equal(cloud.ErrLoadBalancerNotFound))
+		Expect(stub.calls[opDescribeLoadBalancers]).To(Equal(2))
+	})
+
+	It("should not cache anything when the cache is disabled", func() {
+		sdk = newCloud(CacheTTLs{})
+		for i := 0; i < 2; i++ {
+			_, err := sdk.GetEndpointGroupsByHostname(context.TODO(), "internal-missing-123.eu-west-1.elb.amazonaws.com")
+			Expect(err).To(Equal(cloud.ErrLoadBalancerNotFound))
+			_, err = sdk.IsEndpointHealthy(context.TODO(), groups, "10.0.0.1", []int32{80})
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(stub.calls[opDescribeLoadBalancers]).To(Equal(2))
+		Expect(stub.calls[opDescribeTargetHealth]).To(Equal(2))
+	})
+
+	It("should cache load balancers which could not be found", func() {
+		const missing = "internal-missing-123.eu-west-1.elb.amazonaws.com"
+		for i := 0; i < 3; i++ {
+			_, err := sdk.GetEndpointGroupsByHostname(context.TODO(), missing)
+			Expect(err).To(Equal(cloud.ErrLoadBalancerNotFound))
+		}
+		Expect(stub.calls[opDescribeLoadBalancers]).To(Equal(1))
+
+		By("creating the load balancer after the negative ttl expired")
+		stub.loadBalancers["missing"] = &elbv2.LoadBalancer{LoadBalancerArn: awssdk.String("arn:lb"), DNSName: awssdk.String(missing)}
+		now = now.Add(DefaultCacheTTLs.LoadBalancerNotFound)
+		_, err := sdk.GetEndpointGroupsByHostname(context.TODO(), missing)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stub.calls[opDescribeLoadBalancers]).To(Equal(2))
+	})
+
+	It("should cache target health until the endpoint is invalidated", func() {
+		healthy, err := sdk.IsEndpointHealthy(context.TODO(), groups, "10.0.0.1", []int32{80})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(healthy).To(BeFalse())
+
+		stub.targetHealth["10.0.0.1"] = elbv2.TargetHealthStateEnumHealthy
+		healthy, _ = sdk.IsEndpointHealthy(context.TODO(), groups, "10.0.0.1", []int32{80})
+		Expect(healthy).To(BeFalse())
+		Expect(stub.calls[opDescribeTargetHealth]).To(Equal(1))
+
+		sdk.InvalidateEndpoint("10.0.0.1")
+		healthy, _ = sdk.IsEndpointHealthy(context.TODO(), groups, "10.0.0.1", []int32{80})
+		Expect(healthy).To(BeTrue())
+		Expect(stub.calls[opDescribeTargetHealth]).To(Equal(2))
+	})
+
+	It("should only invalidate the given endpoint", func() {
+		stub.targetHealth["10.0.0.11"] = elbv2.TargetHealthStateEnumHealthy
+		_, _ = sdk.IsEndpointHealthy(context.TODO(), groups, "10.0.0.1", []int32{80})
+		_, _ = sdk.IsEndpointHealthy(context.TODO(), groups, "10.0.0.11", []int32{80})
+		sdk.InvalidateEndpoint("10.0.0.1")
+		Expect(sdk.cache.targetHealth.Len()).To(Equal(1))
+	})
+
+	It("should invalidate the target health when a target is deregistered", func() {
+		stub.targetHealth["10.0.0.1"] = elbv2.TargetHealthStateEnumHealthy
+		healthy, _ := sdk.IsEndpointHealthy(context.TODO(), groups, "10.0.0.1", []int32{80})
+		Expect(healthy).To(BeTrue())
+
+		Expect(sdk.RemoveEndpoint(context.TODO(), []cloud.EndpointGroup{{Name: "arn:tg"}}, "10.0.0.1", 80)).To(Succeed())
+		healthy, _ = sdk.IsEndpointHealthy(context.TODO(), groups, "10.0.0.1", []int32{80})
+		Expect(healthy).To(BeFalse())
+		Expect(stub.calls[opDescribeTargetHealth]).To(Equal(2))
+	})
+})
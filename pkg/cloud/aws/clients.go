@@ -0,0 +1,78 @@
+package aws
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+)
+
+// clientKey identifies the elbv2 client of a region and role, an empty role is the default role
+type clientKey struct {
+	region string
+	role   string
+}
+
+// clientPool creates one elbv2 client per region and role on first use and keeps it. Load balancers
+// may live in other regions and accounts than the default client is configured for.
+type clientPool struct {
+	mu      sync.Mutex
+	clients map[clientKey]elbv2iface.ELBV2API
+	new     func(region, role string) elbv2iface.ELBV2API
+}
+
+func (p *clientPool) get(region, role string) elbv2iface.ELBV2API {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := clientKey{region: region, role: role}
+	if client, ok := p.clients[key]; ok {
+		return client
+	}
+	if p.clients == nil {
+		p.clients = map[clientKey]elbv2iface.ELBV2API{}
+	}
+	client := p.new(region, role)
+	p.clients[key] = client
+	return client
+}
+
+// client returns the elbv2 client of a region and role. An empty region is the default region.
+func (c *Cloud) client(region, role string) elbv2iface.ELBV2API {
+	if region == "" {
+		region = c.region
+	}
+	if region == c.region && role == "" {
+		return c.elbv2
+	}
+	return c.clients.get(region, role)
+}
+
+var regionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+
+// regionFromHostname returns the region of a load balancer hostname, e.g.
+// internal-name-1883083075.eu-west-1.elb.amazonaws.com or name-4a5b6c.elb.us-east-1.amazonaws.com.
+// It returns an empty region for hostnames it does not understand.
+func regionFromHostname(hostname string) string {
+	labels := strings.Split(hostname, ".")
+	if len(labels) < 2 {
+		return ""
+	}
+	// the first label is the name of the load balancer, which may look like a region as well
+	for _, label := range labels[1:] {
+		if regionPattern.MatchString(label) {
+			return label
+		}
+	}
+	return ""
+}
+
+// regionFromARN returns the region of a resource arn, empty if it is not an arn
+func regionFromARN(resource string) string {
+	parsed, err := arn.Parse(resource)
+	if err != nil {
+		return ""
+	}
+	return parsed.Region
+}
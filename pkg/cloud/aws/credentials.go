@@ -0,0 +1,83 @@
+package aws
+
+import (
+	"context"
+	"errors"
+
+	awssdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/go-logr/logr"
+)
+
+// DefaultRoleSessionName is the session name of web identity and assumed role sessions
+const DefaultRoleSessionName = "kube-readiness"
+
+// Identity is the principal the controller calls the aws api as
+type Identity struct {
+	Account string
+	Arn     string
+	UserID  string
+}
+
+// newSession creates the session all clients are derived from. It resolves the default region and
+// exchanges a web identity token, e.g. of an IAM role for service accounts, for credentials.
+func newSession(opts Options, log logr.Logger, configs ...*awssdk.Config) (*session.Session, error) {
+	if resolver := opts.Endpoints.resolver(); resolver != nil {
+		configs = append(configs, awssdk.NewConfig().WithEndpointResolver(resolver))
+	}
+	sess, err := session.NewSession(configs...)
+	if err != nil {
+		return nil, err
+	}
+	region, err := resolveRegion(opts.Region, sess, log)
+	if err != nil {
+		return nil, err
+	}
+	sess.Config.Region = awssdk.String(region)
+	if opts.WebIdentityTokenFile != "" {
+		if opts.WebIdentityRoleArn == "" {
+			return nil, errors.New("a web identity token file requires the arn of the role to assume with it")
+		}
+		log.Info("using web identity credentials", "role", opts.WebIdentityRoleArn, "tokenFile", opts.WebIdentityTokenFile)
+		sess.Config.Credentials = stscreds.NewWebIdentityCredentials(sess, opts.WebIdentityRoleArn, opts.roleSessionName(), opts.WebIdentityTokenFile)
+	}
+	return sess, nil
+}
+
+// resolveRegion returns the configured region, the region of the environment or shared config, or
+// the region of the instance the controller runs on, in that order. The region of a load balancer
+// is taken from its hostname, the default region is used for all other calls.
+func resolveRegion(region string, sess *session.Session, log logr.Logger) (string, error) {
+	if region != "" {
+		return region, nil
+	}
+	if region := awssdk.StringValue(sess.Config.Region); region != "" {
+		log.Info("using the region of the environment", "region", region)
+		return region, nil
+	}
+	region, err := ec2metadata.New(sess).Region()
+	if err != nil {
+		return "", errors.New("unable to detect the aws region from the instance metadata, it has to be configured")
+	}
+	log.Info("detected region from the instance metadata", "region", region)
+	return region, nil
+}
+
+// CallerIdentity returns the principal the controller calls the aws api as with the given role, the
+// default role if it is empty. It verifies that the credentials are usable.
+func (c *Cloud) CallerIdentity(ctx context.Context, role string) (*Identity, error) {
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
+	out, err := c.newSTS(role).GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{
+		Account: awssdk.StringValue(out.Account),
+		Arn:     awssdk.StringValue(out.Arn),
+		UserID:  awssdk.StringValue(out.UserId),
+	}, nil
+}
@@ -0,0 +1,90 @@
+package aws
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/nirnanaaa/kube-readiness/pkg/cloud/aws/awstest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var _ = Describe("Credentials", func() {
+	var (
+		server *awstest.Server
+		dir    string
+		env    map[string]string
+	)
+	BeforeEach(func() {
+		server = awstest.NewServer()
+		var err error
+		dir, err = ioutil.TempDir("", "credentials")
+		Expect(err).ToNot(HaveOccurred())
+		// the environment of the test must not configure the sessions
+		env = map[string]string{}
+		for _, name := range []string{"AWS_REGION", "AWS_DEFAULT_REGION", "AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN"} {
+			if value, ok := os.LookupEnv(name); ok {
+				env[name] = value
+				os.Unsetenv(name)
+			}
+		}
+	})
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(dir)
+		for name, value := range env {
+			os.Setenv(name, value)
+		}
+	})
+
+	It("should resolve the identity of the default and of assumed roles", func() {
+		sdk, err := newCloud(Options{Region: "eu-west-1"}, logf.NullLogger{}, server.Config())
+		Expect(err).ToNot(HaveOccurred())
+		identity, err := sdk.CallerIdentity(context.TODO(), "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(identity.Arn).To(Equal(awstest.UserArn))
+
+		identity, err = sdk.CallerIdentity(context.TODO(), "arn:aws:iam::210987654321:role/readiness")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(identity.Account).To(Equal("210987654321"))
+		Expect(identity.Arn).To(Equal("arn:aws:sts::210987654321:assumed-role/readiness/" + DefaultRoleSessionName))
+	})
+
+	It("should exchange a web identity token for credentials", func() {
+		tokenFile := filepath.Join(dir, "token")
+		Expect(ioutil.WriteFile(tokenFile, []byte("service-account-token"), 0600)).To(Succeed())
+		sdk, err := newCloud(Options{
+			Region:               "eu-west-1",
+			WebIdentityTokenFile: tokenFile,
+			WebIdentityRoleArn:   "arn:aws:iam::123456789012:role/kube-readiness",
+		}, logf.NullLogger{}, server.Config())
+		Expect(err).ToNot(HaveOccurred())
+		identity, err := sdk.CallerIdentity(context.TODO(), "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(identity.Arn).To(Equal("arn:aws:sts::123456789012:assumed-role/kube-readiness/" + DefaultRoleSessionName))
+		Expect(server.WebIdentityTokens()).To(Equal([]string{"service-account-token"}))
+	})
+
+	It("should require the role of a web identity token", func() {
+		_, err := newCloud(Options{Region: "eu-west-1", WebIdentityTokenFile: "token"}, logf.NullLogger{}, server.Config())
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should detect the region from the instance metadata", func() {
+		server.Region = "ap-northeast-1"
+		sdk, err := newCloud(Options{}, logf.NullLogger{}, server.Config())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sdk.region).To(Equal("ap-northeast-1"))
+
+		sdk, err = newCloud(Options{}, logf.NullLogger{}, server.Config().WithRegion("us-west-2"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sdk.region).To(Equal("us-west-2"))
+
+		server.Region = ""
+		_, err = newCloud(Options{}, logf.NullLogger{}, server.Config())
+		Expect(err).To(MatchError(ContainSubstring("region")))
+	})
+})
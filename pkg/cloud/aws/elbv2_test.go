@@ -0,0 +1,159 @@
+package aws
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/nirnanaaa/kube-readiness/pkg/cloud"
+	"github.com/nirnanaaa/kube-readiness/pkg/cloud/aws/awstest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var _ = Describe("ELBV2", func() {
+	var (
+		server *awstest.Server
+		sdk    *Cloud
+		lb     *awstest.LoadBalancer
+		tg     *awstest.TargetGroup
+	)
+	BeforeEach(func() {
+		server = awstest.NewServer()
+		var err error
+		sdk, err = newCloud(Options{Region: "eu-west-1"}, logf.NullLogger{}, server.Config())
+		Expect(err).ToNot(HaveOccurred())
+		lb = server.AddLoadBalancer("eu-west-1", "web")
+		tg = server.AddTargetGroup(lb, awstest.TargetGroup{HealthCheckInterval: 10 * time.Second, HealthyThreshold: 3})
+	})
+	AfterEach(func() {
+		server.Close()
+	})
+	endpointGroups := func() []*cloud.EndpointGroup {
+		groups, err := sdk.GetEndpointGroupsByHostname(context.TODO(), lb.DNSName)
+		Expect(err).ToNot(HaveOccurred())
+		return groups
+	}
+
+	It("should resolve the endpoint groups of a load balancer across all pages", func() {
+		server.PageSize = 2
+		for i := 0; i < 4; i++ {
+			server.AddTargetGroup(lb, awstest.TargetGroup{TargetType: "instance"})
+		}
+		server.AddTargetGroup(server.AddLoadBalancer("eu-west-1", "other"), awstest.TargetGroup{})
+
+		groups := endpointGroups()
+		Expect(groups).To(HaveLen(5))
+		Expect(server.Calls("DescribeTargetGroups")).To(Equal(3))
+		Expect(*groups[0]).To(Equal(cloud.EndpointGroup{
+			Name:                tg.ARN,
+			TargetType:          cloud.TargetTypeIP,
+			HealthCheckInterval: 10 * time.Second,
+			HealthyThreshold:    3,
+		}))
+		Expect(groups[4].IsInstance()).To(BeTrue())
+	})
+
+	It("should report load balancers which do not exist", func() {
+		_, err := sdk.GetEndpointGroupsByHostname(context.TODO(), "missing-1234.eu-west-1.elb.amazonaws.com")
+		Expect(err).To(Equal(cloud.ErrLoadBalancerNotFound))
+	})
+
+	It("should follow the health of a target", func() {
+		Expect(server.RegisterTarget(tg.ARN, "10.0.0.1", 8080)).To(Succeed())
+		groups := endpointGroups()
+		healthy, err := sdk.IsEndpointHealthy(context.TODO(), groups, "10.0.0.1", []int32{8080})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(healthy).To(BeFalse(), "the target is still in its initial health checks")
+
+		server.Advance(groups[0].MinHealthyAge())
+		healthy, err = sdk.IsEndpointHealthy(context.TODO(), groups, "10.0.0.1", []int32{8080})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(healthy).To(BeTrue())
+
+		server.SetHealthy("10.0.0.1", false)
+		healthy, err = sdk.IsEndpointHealthy(context.TODO(), groups, "10.0.0.1", []int32{8080})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(healthy).To(BeFalse())
+
+		healthy, err = sdk.IsEndpointHealthy(context.TODO(), groups, "10.0.0.2", []int32{8080})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(healthy).To(BeFalse(), "the target is not registered")
+	})
+
+	It("should check a target on several ports", func() {
+		instances := server.AddTargetGroup(lb, awstest.TargetGroup{TargetType: "instance", HealthCheckInterval: 10 * time.Second, HealthyThreshold: 3})
+		Expect(server.RegisterTarget(instances.ARN, "i-0123456789abcdef0", 30080)).To(Succeed())
+		groups := []*cloud.EndpointGroup{endpointGroups()[1]}
+		server.Advance(groups[0].MinHealthyAge())
+		healthy, err := sdk.IsEndpointHealthy(context.TODO(), groups, "i-0123456789abcdef0", []int32{30080, 30090})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(healthy).To(BeTrue(), "ports the target is not registered on are skipped")
+
+		Expect(server.RegisterTarget(instances.ARN, "i-0123456789abcdef0", 30090)).To(Succeed())
+		sdk.InvalidateEndpoint("i-0123456789abcdef0")
+		healthy, err = sdk.IsEndpointHealthy(context.TODO(), groups, "i-0123456789abcdef0", []int32{30080, 30090})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(healthy).To(BeFalse(), "the target is still in its initial health checks on the second port")
+	})
+
+	It("should require a target to be healthy in all groups", func() {
+		other := server.AddTargetGroup(lb, awstest.TargetGroup{HealthCheckInterval: 10 * time.Second, HealthyThreshold: 3})
+		Expect(server.RegisterTarget(tg.ARN, "10.0.0.1", 8080)).To(Succeed())
+		groups := endpointGroups()
+		server.Advance(groups[0].MinHealthyAge())
+		healthy, err := sdk.IsEndpointHealthy(context.TODO(), groups, "10.0.0.1", []int32{8080})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(healthy).To(BeFalse(), "the target is not registered with the second group")
+
+		Expect(server.RegisterTarget(other.ARN, "10.0.0.1", 8080)).To(Succeed())
+		server.Advance(groups[1].MinHealthyAge())
+		sdk.InvalidateEndpoint("10.0.0.1")
+		healthy, err = sdk.IsEndpointHealthy(context.TODO(), groups, "10.0.0.1", []int32{8080})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(healthy).To(BeTrue())
+	})
+
+	It("should surface the error codes of the api", func() {
+		_, err := sdk.IsEndpointHealthy(context.TODO(), []*cloud.EndpointGroup{{Name: tg.ARN + "-missing"}}, "10.0.0.1", []int32{8080})
+		Expect(err).To(HaveOccurred())
+		Expect(err.(awserr.Error).Code()).To(Equal(elbv2.ErrCodeTargetGroupNotFoundException))
+	})
+
+	It("should deregister a target from all groups", func() {
+		other := server.AddTargetGroup(lb, awstest.TargetGroup{DeregistrationDelay: 30 * time.Second})
+		Expect(server.RegisterTarget(tg.ARN, "10.0.0.1", 8080)).To(Succeed())
+		Expect(server.RegisterTarget(other.ARN, "10.0.0.1", 8080)).To(Succeed())
+		var groups []cloud.EndpointGroup
+		for _, group := range endpointGroups() {
+			groups = append(groups, *group)
+		}
+
+		Expect(sdk.RemoveEndpoint(context.TODO(), groups, "10.0.0.1", 8080)).To(Succeed())
+		Expect(server.TargetState(tg.ARN, "10.0.0.1", 8080)).To(Equal(awstest.StateDraining))
+		Expect(server.TargetState(other.ARN, "10.0.0.1", 8080)).To(Equal(awstest.StateDraining))
+
+		server.Advance(30 * time.Second)
+		Expect(server.TargetState(other.ARN, "10.0.0.1", 8080)).To(Equal(awstest.StateUnused))
+		// targets which are not registered anymore are skipped
+		Expect(sdk.RemoveEndpoint(context.TODO(), groups, "10.0.0.1", 8080)).To(Succeed())
+		Expect(server.Calls("DeregisterTargets")).To(Equal(4))
+	})
+
+	It("should list the targets of a group until they drained", func() {
+		Expect(server.RegisterTarget(tg.ARN, "10.0.0.2", 8080)).To(Succeed())
+		Expect(server.RegisterTarget(tg.ARN, "10.0.0.1", 8080)).To(Succeed())
+		server.Advance(30 * time.Second)
+		Expect(server.DeregisterTarget(tg.ARN, "10.0.0.2", 8080)).To(Succeed())
+		Expect(server.Targets(tg.ARN)).To(Equal([]awstest.TargetHealth{
+			{ID: "10.0.0.1", Port: 8080, State: awstest.StateHealthy},
+			{ID: "10.0.0.2", Port: 8080, State: awstest.StateDraining},
+		}))
+
+		server.Advance(300 * time.Second)
+		Expect(server.Targets(tg.ARN)).To(HaveLen(1))
+		Expect(server.DeregisterTarget(tg.ARN, "10.0.0.2", 8080)).ToNot(Succeed())
+	})
+})
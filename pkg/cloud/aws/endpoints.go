@@ -0,0 +1,45 @@
+package aws
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// Endpoints override the urls of the aws apis, e.g. with vpc or fips endpoints or a local stand-in.
+// The placeholder {region} is replaced with the region of the client. Empty urls are resolved by the sdk.
+type Endpoints struct {
+	ELBV2 string
+	EC2   string
+	STS   string
+}
+
+// resolver returns an endpoint resolver applying the overrides, nil without overrides
+func (e Endpoints) resolver() endpoints.Resolver {
+	overrides := map[string]string{}
+	for service, url := range map[string]string{
+		elbv2.EndpointsID: e.ELBV2,
+		ec2.EndpointsID:   e.EC2,
+		sts.EndpointsID:   e.STS,
+	} {
+		if url != "" {
+			overrides[service] = url
+		}
+	}
+	if len(overrides) == 0 {
+		return nil
+	}
+	return endpoints.ResolverFunc(func(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		url, ok := overrides[service]
+		if !ok {
+			return endpoints.DefaultResolver().EndpointFor(service, region, opts...)
+		}
+		return endpoints.ResolvedEndpoint{
+			URL:           strings.Replace(url, "{region}", region, -1),
+			SigningRegion: region,
+		}, nil
+	})
+}
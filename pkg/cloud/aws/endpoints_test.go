@@ -0,0 +1,52 @@
+package aws
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/nirnanaaa/kube-readiness/pkg/cloud/aws/awstest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var _ = Describe("Endpoints", func() {
+	var server *awstest.Server
+	BeforeEach(func() {
+		server = awstest.NewServer()
+	})
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should resolve overridden endpoints per service and region", func() {
+		resolver := Endpoints{ELBV2: "https://elasticloadbalancing-fips.{region}.amazonaws.com"}.resolver()
+		endpoint, err := resolver.EndpointFor(elbv2.EndpointsID, "us-east-2")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(endpoint.URL).To(Equal("https://elasticloadbalancing-fips.us-east-2.amazonaws.com"))
+		Expect(endpoint.SigningRegion).To(Equal("us-east-2"))
+
+		endpoint, err = resolver.EndpointFor(ec2.EndpointsID, "us-east-2")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(endpoint.URL).To(Equal("https://ec2.us-east-2.amazonaws.com"))
+
+		Expect(Endpoints{}.resolver()).To(BeNil())
+	})
+
+	It("should send the calls of each service to its endpoint", func() {
+		sdk, err := newCloud(Options{
+			Region:    "eu-west-1",
+			Endpoints: Endpoints{ELBV2: server.URL + "/{region}", STS: server.URL},
+		}, logf.NullLogger{}, server.Config().WithEndpoint(""))
+		Expect(err).ToNot(HaveOccurred())
+
+		identity, err := sdk.CallerIdentity(context.TODO(), "arn:aws:iam::210987654321:role/readiness")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(identity.Account).To(Equal("210987654321"))
+		Expect(server.Calls("AssumeRole")).To(Equal(1))
+
+		Expect(sdk.Ping(context.TODO())).To(Succeed())
+		Expect(server.Calls("DescribeLoadBalancers")).To(Equal(1))
+	})
+})
@@ -27,9 +27,34 @@ var (
 			Help:      "Number of failed aws api requests",
 		},
 	)
+	cacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:      "cache_requests",
+			Namespace: "aws",
+			Help:      "Number of aws sdk cache lookups by operation and result",
+		},
+		[]string{"operation", "result"},
+	)
+	cacheInvalidations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:      "cache_invalidations",
+			Namespace: "aws",
+			Help:      "Number of aws sdk cache entries invalidated by operation",
+		},
+		[]string{"operation"},
+	)
+	rateLimitWait = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:      "rate_limit_wait_seconds",
+			Namespace: "aws",
+			Help:      "Time aws api requests waited for the rate limiter by operation",
+			Buckets:   []float64{0.001, 0.01, 0.1, 0.5, 1, 2.5, 5, 10},
+		},
+		[]string{"operation"},
+	)
 )
 
 func init() {
 	// Register custom metrics with the global prometheus registry
-	metrics.Registry.MustRegister(successfulApiRequests, throttledApiRequests, failedApiRequests)
+	metrics.Registry.MustRegister(successfulApiRequests, throttledApiRequests, failedApiRequests, cacheRequests, cacheInvalidations, rateLimitWait)
 }
@@ -0,0 +1,30 @@
+package aws
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"golang.org/x/time/rate"
+)
+
+// rateLimitHandler lets every request to the elbv2 api wait for a token of the limiter as long as
+// its context allows. It runs once per http request, so every page and every retry of a call takes a
+// token while results served from the cache take none.
+func rateLimitHandler(limiter *rate.Limiter) request.NamedHandler {
+	return request.NamedHandler{
+		Name: "kubereadiness.RateLimitHandler",
+		Fn: func(r *request.Request) {
+			if r.ClientInfo.ServiceName != elbv2.ServiceName {
+				return
+			}
+			start := time.Now()
+			err := limiter.Wait(r.Context())
+			rateLimitWait.WithLabelValues(r.Operation.Name).Observe(time.Since(start).Seconds())
+			if err != nil {
+				r.Error = awserr.New(request.CanceledErrorCode, "request canceled while waiting for the rate limiter", err)
+			}
+		},
+	}
+}
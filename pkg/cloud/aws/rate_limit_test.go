@@ -0,0 +1,59 @@
+package aws
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/nirnanaaa/kube-readiness/pkg/cloud/aws/awstest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var _ = Describe("Rate Limit", func() {
+	var (
+		server *awstest.Server
+		sdk    *Cloud
+		lb     *awstest.LoadBalancer
+	)
+	BeforeEach(func() {
+		server = awstest.NewServer()
+		var err error
+		sdk, err = newCloud(Options{Region: "eu-west-1", CacheEnabled: true, QPS: 0.001, Burst: 3}, logf.NullLogger{}, server.Config())
+		Expect(err).ToNot(HaveOccurred())
+		lb = server.AddLoadBalancer("eu-west-1", "web")
+		server.AddTargetGroup(lb, awstest.TargetGroup{})
+	})
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should take a token for every page of a call", func() {
+		server.PageSize = 1
+		server.AddTargetGroup(lb, awstest.TargetGroup{})
+		ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+		defer cancel()
+		// one page of load balancers and two pages of target groups take up the whole burst
+		_, err := sdk.GetEndpointGroupsByHostname(ctx, lb.DNSName)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(server.Calls("DescribeTargetGroups")).To(Equal(2))
+
+		_, err = sdk.GetEndpointGroupsByHostname(ctx, "missing-1234.eu-west-1.elb.amazonaws.com")
+		Expect(err).To(HaveOccurred())
+		Expect(err.(awserr.Error).Code()).To(Equal(request.CanceledErrorCode))
+		Expect(server.Calls("DescribeLoadBalancers")).To(Equal(1))
+	})
+
+	It("should not take tokens for cached results", func() {
+		ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+		defer cancel()
+		for i := 0; i < 5; i++ {
+			_, err := sdk.GetEndpointGroupsByHostname(ctx, lb.DNSName)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(server.Calls("DescribeLoadBalancers")).To(Equal(1))
+		Expect(server.Calls("DescribeTargetGroups")).To(Equal(1))
+	})
+})
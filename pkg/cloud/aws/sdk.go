@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	awssdk "github.com/aws/aws-sdk-go/aws"
@@ -14,41 +15,114 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
 	"github.com/go-logr/logr"
 	"github.com/nirnanaaa/kube-readiness/pkg/cloud"
-	"github.com/ticketmaster/aws-sdk-go-cache/cache"
-	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"golang.org/x/time/rate"
 )
 
 // SDK implements an
 type Cloud struct {
-	session *session.Session
-	config  *awssdk.Config
-	ec2     *ec2.EC2
-	log     logr.Logger
-	elbv2   *elbv2.ELBV2
+	session     *session.Session
+	config      *awssdk.Config
+	ec2         *ec2.EC2
+	log         logr.Logger
+	elbv2       elbv2iface.ELBV2API // client of the default region and role
+	region      string
+	clients     clientPool
+	newSTS      func(role string) stsiface.STSAPI
+	callTimeout int64 // time.Duration, updated atomically
+	cache       *sdkCache
 }
 
-func NewCloudSDK(region string, assumeRoleArn string, log logr.Logger, cacheEnabled bool) (sdk cloud.SDK, err error) {
+// Options configures the aws cloud provider
+type Options struct {
+	// Region of load balancers whose region can not be told from their hostname
+	Region string
+	// AssumeRoleArn is the default role. Roles requested by the context are assumed with the
+	// credentials of the controller, not with the default role.
+	AssumeRoleArn string
+	// WebIdentityTokenFile is exchanged for the credentials of WebIdentityRoleArn, e.g. the token
+	// projected into the pod for IAM roles for service accounts
+	WebIdentityTokenFile string
+	WebIdentityRoleArn   string
+	// RoleSessionName of web identity and assumed role sessions, DefaultRoleSessionName if empty
+	RoleSessionName string
+	CacheEnabled    bool
+	// CacheTTLs are the per operation ttls of the sdk cache. DefaultCacheTTLs are used if unset.
+	CacheTTLs *CacheTTLs
+	// CallTimeout bounds every single api call including all of its pages. Zero disables the timeout.
+	CallTimeout time.Duration
+	// Endpoints override the urls of the aws apis
+	Endpoints Endpoints
+	// QPS limits the requests to the elbv2 api of all roles and regions together, bursts of up to
+	// Burst requests are allowed. A non-positive qps disables the limit.
+	QPS   float64
+	Burst int
+}
+
+func (opts Options) cacheTTLs() CacheTTLs {
+	if !opts.CacheEnabled {
+		return CacheTTLs{}
+	}
+	if opts.CacheTTLs != nil {
+		return *opts.CacheTTLs
+	}
+	return DefaultCacheTTLs
+}
+
+func (opts Options) roleSessionName() string {
+	if opts.RoleSessionName == "" {
+		return DefaultRoleSessionName
+	}
+	return opts.RoleSessionName
+}
+
+func NewCloudSDK(opts Options, log logr.Logger) (sdk cloud.SDK, err error) {
+	return newCloud(opts, log)
+}
+
+// newCloud creates the sdk from a session with the given configs on top of the environment
+func newCloud(opts Options, log logr.Logger, configs ...*awssdk.Config) (*Cloud, error) {
 	logger := log.WithValues("sdk", "aws")
-	sess, err := session.NewSession()
+	sess, err := newSession(opts, logger, configs...)
 	if err != nil {
-		return
+		return nil, err
+	}
+	region := awssdk.StringValue(sess.Config.Region)
+	// configFor returns the config of the clients of a region and role, the default role if empty
+	configFor := func(region, role string) *awssdk.Config {
+		config := awssdk.NewConfig().WithRegion(region)
+		if role == "" {
+			role = opts.AssumeRoleArn
+		}
+		if role != "" {
+			config.Credentials = stscreds.NewCredentials(sess, role, func(p *stscreds.AssumeRoleProvider) {
+				p.RoleSessionName = opts.roleSessionName()
+			})
+		}
+		return config
+	}
+	newELBV2 := func(region, role string) elbv2iface.ELBV2API {
+		logger.Info("creating elbv2 client", "region", region, "role", role)
+		return elbv2.New(sess, configFor(region, role))
 	}
-	awsConfig := awssdk.NewConfig().WithRegion(region)
+	awsConfig := configFor(region, "")
 
-	if cacheEnabled {
-		logger.Info("starting up sdk cache")
-		cc := cache.NewConfig(30 * time.Second)
-		cc.SetCacheTTL(elbv2.ServiceName, "DescribeLoadBalancers", time.Minute)
-		cc.SetCacheTTL(elbv2.ServiceName, "DescribeTargetHealth", 10*time.Second)
-		cache.AddCaching(sess, cc)
-		metrics.Registry.MustRegister(cc.NewCacheCollector("aws_cache"))
+	cacheTTLs := opts.cacheTTLs()
+	if opts.CacheEnabled {
+		logger.Info("starting up sdk cache", "ttls", cacheTTLs)
 	}
 
-	if assumeRoleArn != "" {
-		creds := stscreds.NewCredentials(sess, assumeRoleArn)
-		awsConfig.Credentials = creds
+	if opts.QPS > 0 {
+		burst := opts.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		// signing runs before every attempt, the handler is copied into all clients of the session
+		sess.Handlers.Sign.PushFrontNamed(rateLimitHandler(rate.NewLimiter(rate.Limit(opts.QPS), burst)))
 	}
 
 	sess.Handlers.Send.PushFront(func(r *request.Request) {
@@ -78,60 +152,134 @@ func NewCloudSDK(region string, assumeRoleArn string, log logr.Logger, cacheEnab
 			logger.V(4).Info("response", "service", r.ClientInfo.ServiceName, "operation", r.Operation.Name, "data", r.Data)
 		}
 	})
-	sdk = &Cloud{
+	return &Cloud{
 		session: sess,
 		config:  awsConfig,
 		ec2:     ec2.New(sess, awsConfig),
 		log:     logger,
-		elbv2:   elbv2.New(sess, awsConfig),
+		elbv2:   newELBV2(region, ""),
+		region:  region,
+		clients: clientPool{new: newELBV2},
+		newSTS: func(role string) stsiface.STSAPI {
+			return sts.New(sess, configFor(region, role))
+		},
+		callTimeout: int64(opts.CallTimeout),
+		cache:       newSDKCache(cacheTTLs, time.Now),
+	}, nil
+}
+
+// Reconfigure applies the settings of a running sdk which can change without a restart: the call
+// timeout, enabling or disabling the cache and its ttls. The region and the role require a restart.
+func (c *Cloud) Reconfigure(opts Options) {
+	atomic.StoreInt64(&c.callTimeout, int64(opts.CallTimeout))
+	ttls := opts.cacheTTLs()
+	c.cache.SetTTLs(ttls)
+	c.log.Info("reconfigured", "callTimeout", opts.CallTimeout, "cacheEnabled", opts.CacheEnabled, "cacheTTLs", ttls)
+}
+
+// withCallTimeout derives the context a single api call is run with
+func (c *Cloud) withCallTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := time.Duration(atomic.LoadInt64(&c.callTimeout))
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
-	return sdk, nil
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Ping issues a single, minimal DescribeLoadBalancers call to verify that elbv2 is reachable
+func (c *Cloud) Ping(ctx context.Context) error {
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
+	_, err := c.elbv2.DescribeLoadBalancersWithContext(ctx, &elbv2.DescribeLoadBalancersInput{
+		PageSize: awssdk.Int64(1),
+	})
+	return err
 }
 
+// GetEndpointGroupsByHostname looks the load balancer up in the region of its hostname as the role of the context
 func (c *Cloud) GetEndpointGroupsByHostname(ctx context.Context, hostname string) (groups []*cloud.EndpointGroup, err error) {
 	name := getNameFromHostname(hostname)
-	lb, err := c.GetLoadBalancerByHostname(ctx, name)
+	region, role := regionFromHostname(hostname), cloud.RoleFrom(ctx)
+	client := c.client(region, role)
+	lb, err := c.getLoadBalancer(ctx, client, loadBalancerKey(region, role, name), name)
 	if err != nil {
 		return nil, err
 	}
-	tgs, err := c.describeTargetGroupsHelper(&elbv2.DescribeTargetGroupsInput{
-		LoadBalancerArn: awssdk.String(lb.Name),
-	})
+	tgs, err := c.describeTargetGroups(ctx, client, lb.Name)
 	if err != nil {
 		return
 	}
 	groups = []*cloud.EndpointGroup{}
 	for _, tg := range tgs {
 		groups = append(groups, &cloud.EndpointGroup{
-			Name: awssdk.StringValue(tg.TargetGroupArn),
+			Name:                awssdk.StringValue(tg.TargetGroupArn),
+			TargetType:          awssdk.StringValue(tg.TargetType),
+			HealthCheckInterval: time.Duration(awssdk.Int64Value(tg.HealthCheckIntervalSeconds)) * time.Second,
+			HealthyThreshold:    int(awssdk.Int64Value(tg.HealthyThresholdCount)),
+			Role:                role,
 		})
 	}
 	return
 }
 
-func (c *Cloud) GetLoadBalancerByHostname(ctx context.Context, name string) (lb *cloud.LoadBalancer, err error) {
-	loadBalancers, err := c.describeLoadBalancersHelper(&elbv2.DescribeLoadBalancersInput{
+// loadBalancerKey is the cache key of a load balancer, names are unique per region and account only
+func loadBalancerKey(region, role, name string) string {
+	return region + "|" + role + "|" + name
+}
+
+func (c *Cloud) getLoadBalancer(ctx context.Context, client elbv2iface.ELBV2API, key, name string) (lb *cloud.LoadBalancer, err error) {
+	if value, err, ok := c.cache.loadBalancers.Get(key); ok {
+		if err != nil {
+			return nil, err
+		}
+		return value.(*cloud.LoadBalancer), nil
+	}
+	loadBalancers, err := c.describeLoadBalancersHelper(ctx, client, &elbv2.DescribeLoadBalancersInput{
 		Names: []*string{awssdk.String(name)},
 	})
 	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == elbv2.ErrCodeLoadBalancerNotFoundException {
+			c.cache.loadBalancers.SetError(key, cloud.ErrLoadBalancerNotFound, c.cache.TTLs().LoadBalancerNotFound)
+			return nil, cloud.ErrLoadBalancerNotFound
+		}
 		return nil, err
 	}
 	if len(loadBalancers) == 0 {
-		return nil, errors.New("no load balancer found")
+		c.cache.loadBalancers.SetError(key, cloud.ErrLoadBalancerNotFound, c.cache.TTLs().LoadBalancerNotFound)
+		return nil, cloud.ErrLoadBalancerNotFound
 	}
 	if len(loadBalancers) > 1 {
 		return nil, errors.New("more than one load balancer found. cannot determine which one to use")
 	}
 	balancer := loadBalancers[0]
-	return &cloud.LoadBalancer{
+	lb = &cloud.LoadBalancer{
 		Name:     awssdk.StringValue(balancer.LoadBalancerArn),
 		Hostname: awssdk.StringValue(balancer.DNSName),
-	}, nil
+	}
+	c.cache.loadBalancers.Set(key, lb)
+	return lb, nil
+}
+
+// describeTargetGroups returns the (cached) target groups of a load balancer
+func (c *Cloud) describeTargetGroups(ctx context.Context, client elbv2iface.ELBV2API, loadBalancerArn string) ([]*elbv2.TargetGroup, error) {
+	if value, _, ok := c.cache.targetGroups.Get(loadBalancerArn); ok {
+		return value.([]*elbv2.TargetGroup), nil
+	}
+	tgs, err := c.describeTargetGroupsHelper(ctx, client, &elbv2.DescribeTargetGroupsInput{
+		LoadBalancerArn: awssdk.String(loadBalancerArn),
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.cache.targetGroups.Set(loadBalancerArn, tgs)
+	return tgs, nil
 }
 
 // describeLoadBalancersHelper is an helper to handle pagination in describeLoadBalancers call
-func (c *Cloud) describeLoadBalancersHelper(input *elbv2.DescribeLoadBalancersInput) (result []*elbv2.LoadBalancer, err error) {
-	err = c.elbv2.DescribeLoadBalancersPages(input, func(output *elbv2.DescribeLoadBalancersOutput, _ bool) bool {
+func (c *Cloud) describeLoadBalancersHelper(ctx context.Context, client elbv2iface.ELBV2API, input *elbv2.DescribeLoadBalancersInput) (result []*elbv2.LoadBalancer, err error) {
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
+	err = client.DescribeLoadBalancersPagesWithContext(ctx, input, func(output *elbv2.DescribeLoadBalancersOutput, _ bool) bool {
 		if output == nil {
 			return false
 		}
@@ -142,8 +290,10 @@ func (c *Cloud) describeLoadBalancersHelper(input *elbv2.DescribeLoadBalancersIn
 }
 
 // describeTargetGroupsHelper is an helper t handle pagination in describeTargetGroups call
-func (c *Cloud) describeTargetGroupsHelper(input *elbv2.DescribeTargetGroupsInput) (result []*elbv2.TargetGroup, err error) {
-	err = c.elbv2.DescribeTargetGroupsPages(input, func(output *elbv2.DescribeTargetGroupsOutput, _ bool) bool {
+func (c *Cloud) describeTargetGroupsHelper(ctx context.Context, client elbv2iface.ELBV2API, input *elbv2.DescribeTargetGroupsInput) (result []*elbv2.TargetGroup, err error) {
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
+	err = client.DescribeTargetGroupsPagesWithContext(ctx, input, func(output *elbv2.DescribeTargetGroupsOutput, _ bool) bool {
 		if output == nil {
 			return false
 		}
@@ -164,7 +314,13 @@ func getNameFromHostname(hostname string) string {
 	return strings.ReplaceAll(noPrefix, "-"+tmp[len(tmp)-1], "")
 }
 
+// IsEndpointHealthy reports whether the target is healthy in all groups. A target which is checked
+// on several ports, e.g. the node ports of an instance mode service, only needs to be registered on
+// one of them in a group, but every registered port has to be healthy.
 func (c *Cloud) IsEndpointHealthy(ctx context.Context, groups []*cloud.EndpointGroup, name string, ports []int32) (bool, error) {
+	if len(groups) == 0 {
+		return false, nil
+	}
 	for _, endpoint := range groups {
 		var targetInfo []*elbv2.TargetDescription
 		for _, port := range ports {
@@ -173,27 +329,64 @@ func (c *Cloud) IsEndpointHealthy(ctx context.Context, groups []*cloud.EndpointG
 				Port: awssdk.Int64(int64(port)),
 			})
 		}
-		out, err := c.elbv2.DescribeTargetHealth(&elbv2.DescribeTargetHealthInput{
+		client := c.client(regionFromARN(endpoint.Name), endpoint.Role)
+		out, err := c.describeTargetHealth(ctx, client, targetHealthKey(endpoint.Name, name, ports), &elbv2.DescribeTargetHealthInput{
 			TargetGroupArn: awssdk.String(endpoint.Name),
 			Targets:        targetInfo,
 		})
 		if err != nil {
 			return false, err
 		}
-		if len(out.TargetHealthDescriptions) != 1 {
-			return false, errors.New(fmt.Sprintf("expecting only one health target but got [%v]", len(out.TargetHealthDescriptions)))
+		if !isTargetHealthy(out.TargetHealthDescriptions) {
+			return false, nil
 		}
-		if *out.TargetHealthDescriptions[0].TargetHealth.State == "healthy" {
-			return true, nil
+	}
+	return true, nil
+}
+
+// isTargetHealthy reports whether the target is registered on at least one of the described ports
+// and healthy on all ports it is registered on
+func isTargetHealthy(descriptions []*elbv2.TargetHealthDescription) bool {
+	registered := false
+	for _, description := range descriptions {
+		if description.TargetHealth == nil || description.TargetHealth.State == nil {
+			return false
+		}
+		switch *description.TargetHealth.State {
+		case elbv2.TargetHealthStateEnumUnused:
+			continue
+		case elbv2.TargetHealthStateEnumHealthy:
+			registered = true
+		default:
+			return false
 		}
-		return false, nil
 	}
-	return false, nil
+	return registered
 }
 
+// InstanceID extracts the ec2 instance id from a provider id of the form aws:///<zone>/<instance id>
+func (c *Cloud) InstanceID(providerID string) (string, error) {
+	if !strings.HasPrefix(providerID, "aws://") {
+		return "", fmt.Errorf("provider id %q is not an aws provider id", providerID)
+	}
+	id := providerID[strings.LastIndex(providerID, "/")+1:]
+	if !strings.HasPrefix(id, "i-") {
+		return "", fmt.Errorf("provider id %q does not refer to an ec2 instance", providerID)
+	}
+	return id, nil
+}
+
+// InvalidateEndpoint drops all cached health states of the given target
+func (c *Cloud) InvalidateEndpoint(name string) {
+	c.cache.targetHealth.Invalidate(targetIDKeyPart(name))
+}
+
+// RemoveEndpoint deregisters the target from all groups, targets which are not registered are skipped
 func (c *Cloud) RemoveEndpoint(ctx context.Context, groups []cloud.EndpointGroup, name string, port int32) error {
+	defer c.InvalidateEndpoint(name)
 	for _, endpoint := range groups {
-		_, err := c.elbv2.DeregisterTargets(&elbv2.DeregisterTargetsInput{
+		client := c.client(regionFromARN(endpoint.Name), endpoint.Role)
+		_, err := c.deregisterTargets(ctx, client, &elbv2.DeregisterTargetsInput{
 			TargetGroupArn: awssdk.String(endpoint.Name),
 			Targets: []*elbv2.TargetDescription{
 				{
@@ -213,7 +406,36 @@ func (c *Cloud) RemoveEndpoint(ctx context.Context, groups []cloud.EndpointGroup
 			}
 			return err
 		}
-		return nil
 	}
 	return nil
 }
+
+func (c *Cloud) describeTargetHealth(ctx context.Context, client elbv2iface.ELBV2API, key string, input *elbv2.DescribeTargetHealthInput) (*elbv2.DescribeTargetHealthOutput, error) {
+	if value, _, ok := c.cache.targetHealth.Get(key); ok {
+		return value.(*elbv2.DescribeTargetHealthOutput), nil
+	}
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
+	out, err := client.DescribeTargetHealthWithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	c.cache.targetHealth.Set(key, out)
+	return out, nil
+}
+
+// targetHealthKey builds the cache key of a target health lookup
+func targetHealthKey(targetGroupArn, name string, ports []int32) string {
+	return fmt.Sprintf("%s%s%v", targetGroupArn, targetIDKeyPart(name), ports)
+}
+
+// targetIDKeyPart is shared by all target health cache keys of the same target id
+func targetIDKeyPart(name string) string {
+	return "|" + name + "|"
+}
+
+func (c *Cloud) deregisterTargets(ctx context.Context, client elbv2iface.ELBV2API, input *elbv2.DeregisterTargetsInput) (*elbv2.DeregisterTargetsOutput, error) {
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
+	return client.DeregisterTargetsWithContext(ctx, input)
+}
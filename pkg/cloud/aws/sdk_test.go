@@ -1 +1,122 @@
 package aws
+
+import (
+	"context"
+	"time"
+
+	awssdk "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/nirnanaaa/kube-readiness/pkg/cloud"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var _ = Describe("SDK", func() {
+	It("should extract instance ids from provider ids", func() {
+		sdk := &Cloud{}
+		id, err := sdk.InstanceID("aws:///eu-west-1a/i-0123456789abcdef0")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(id).To(Equal("i-0123456789abcdef0"))
+
+		_, err = sdk.InstanceID("gce://project/zone/instance")
+		Expect(err).To(HaveOccurred())
+		_, err = sdk.InstanceID("aws:///eu-west-1a/fargate-ip-10-0-0-1")
+		Expect(err).To(HaveOccurred())
+		_, err = sdk.InstanceID("")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should extract the region of load balancer hostnames", func() {
+		for hostname, region := range map[string]string{
+			"internal-aefc3232-ab-prometheus-d4e5-1883083075.eu-west-1.elb.amazonaws.com": "eu-west-1",
+			"ab-prometheus-1883083075.us-east-2.elb.amazonaws.com":                        "us-east-2",
+			"net-lb-0123456789abcdef.elb.ap-southeast-1.amazonaws.com":                    "ap-southeast-1",
+			"web-123.cn-north-1.elb.amazonaws.com.cn":                                     "cn-north-1",
+			"web-123.us-gov-west-1.elb.amazonaws.com":                                     "us-gov-west-1",
+			"ab-west-1.example.com":                                                       "",
+			"localhost":                                                                   "",
+		} {
+			Expect(regionFromHostname(hostname)).To(Equal(region), hostname)
+		}
+		Expect(regionFromARN("arn:aws:elasticloadbalancing:us-east-2:123456789012:targetgroup/web/0123")).To(Equal("us-east-2"))
+		Expect(regionFromARN("arn:tg")).To(BeEmpty())
+	})
+
+	Context("with load balancers in several regions and accounts", func() {
+		const (
+			networkingRole = "arn:aws:iam::210987654321:role/readiness"
+			targetGroup    = "arn:aws:elasticloadbalancing:us-east-2:210987654321:targetgroup/web/0123"
+		)
+		var (
+			sdk     *Cloud
+			stubs   map[clientKey]*stubELBV2
+			newStub = func() *stubELBV2 {
+				return &stubELBV2{
+					calls: map[string]int{},
+					loadBalancers: map[string]*elbv2.LoadBalancer{
+						"web": {LoadBalancerArn: awssdk.String("arn:lb")},
+					},
+					targetGroups: map[string][]*elbv2.TargetGroup{
+						"arn:lb": {{TargetGroupArn: awssdk.String(targetGroup)}},
+					},
+					targetHealth: map[string]string{"10.0.0.1": elbv2.TargetHealthStateEnumHealthy},
+				}
+			}
+		)
+		BeforeEach(func() {
+			stubs = map[clientKey]*stubELBV2{{region: "eu-west-1"}: newStub()}
+			sdk = &Cloud{
+				elbv2:  stubs[clientKey{region: "eu-west-1"}],
+				region: "eu-west-1",
+				log:    logf.NullLogger{},
+				cache:  newSDKCache(DefaultCacheTTLs, time.Now),
+				clients: clientPool{new: func(region, role string) elbv2iface.ELBV2API {
+					stub := newStub()
+					stubs[clientKey{region: region, role: role}] = stub
+					return stub
+				}},
+			}
+		})
+
+		It("should use one client per region and role", func() {
+			ctx := cloud.WithRole(context.TODO(), networkingRole)
+			groups, err := sdk.GetEndpointGroupsByHostname(ctx, "web-123.us-east-2.elb.amazonaws.com")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups).To(HaveLen(1))
+			Expect(groups[0].Role).To(Equal(networkingRole))
+
+			healthy, err := sdk.IsEndpointHealthy(context.TODO(), groups, "10.0.0.1", []int32{80})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(healthy).To(BeTrue())
+			Expect(sdk.RemoveEndpoint(context.TODO(), []cloud.EndpointGroup{*groups[0]}, "10.0.0.1", 80)).To(Succeed())
+
+			Expect(stubs).To(HaveLen(2))
+			stub := stubs[clientKey{region: "us-east-2", role: networkingRole}]
+			Expect(stub.calls).To(Equal(map[string]int{
+				opDescribeLoadBalancers: 1, opDescribeTargetGroups: 1, opDescribeTargetHealth: 1, "DeregisterTargets": 1,
+			}))
+			Expect(stubs[clientKey{region: "eu-west-1"}].calls).To(BeEmpty())
+		})
+
+		It("should use the default client for the default region and role", func() {
+			_, err := sdk.GetEndpointGroupsByHostname(context.TODO(), "web-123.eu-west-1.elb.amazonaws.com")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = sdk.GetEndpointGroupsByHostname(context.TODO(), "web-123.elb.example.com")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stubs).To(HaveLen(1))
+		})
+
+		It("should not share cached load balancers between accounts", func() {
+			_, err := sdk.GetEndpointGroupsByHostname(context.TODO(), "web-123.us-east-2.elb.amazonaws.com")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = sdk.GetEndpointGroupsByHostname(cloud.WithRole(context.TODO(), networkingRole), "web-123.us-east-2.elb.amazonaws.com")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stubs).To(HaveLen(3))
+			for _, stub := range stubs {
+				Expect(stub.calls[opDescribeLoadBalancers]).To(BeNumerically("<=", 1))
+			}
+		})
+	})
+})
@@ -0,0 +1,174 @@
+package cloud
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	endpointGroupCacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:      "cache_requests",
+			Namespace: "endpoint_group",
+			Help:      "Number of endpoint group lookups by hostname and their cache result",
+		},
+		[]string{"result"},
+	)
+	endpointGroupCacheRefreshes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:      "cache_refreshes",
+			Namespace: "endpoint_group",
+			Help:      "Number of background refreshes of cached endpoint groups by outcome",
+		},
+		[]string{"result"},
+	)
+)
+
+func init() {
+	// Register custom metrics with the global prometheus registry
+	metrics.Registry.MustRegister(endpointGroupCacheRequests, endpointGroupCacheRefreshes)
+}
+
+// EndpointGroupCache keeps the endpoint groups of load balancers by role and hostname, so that
+// resolving them does not hit the cloud provider on every ingress event.
+type EndpointGroupCache struct {
+	SDK             SDK
+	Log             logr.Logger
+	RefreshInterval time.Duration
+
+	mu     sync.RWMutex
+	groups map[endpointGroupKey][]*EndpointGroup
+	// generation is increased by every invalidation, lookups which started before one do not cache
+	// their result, it might be outdated
+	generation uint64
+}
+
+// endpointGroupKey identifies a load balancer, the same hostname may be looked up as different roles
+type endpointGroupKey struct {
+	role     string
+	hostname string
+}
+
+func NewEndpointGroupCache(sdk SDK, refreshInterval time.Duration, log logr.Logger) *EndpointGroupCache {
+	return &EndpointGroupCache{
+		SDK:             sdk,
+		Log:             log,
+		RefreshInterval: refreshInterval,
+		groups:          map[endpointGroupKey][]*EndpointGroup{},
+	}
+}
+
+// GetEndpointGroupsByHostname returns the cached endpoint groups of a hostname and resolves them on a miss.
+// The role of the context is part of the cache key.
+func (c *EndpointGroupCache) GetEndpointGroupsByHostname(ctx context.Context, hostname string) ([]*EndpointGroup, error) {
+	key := endpointGroupKey{role: RoleFrom(ctx), hostname: hostname}
+	c.mu.RLock()
+	groups, ok := c.groups[key]
+	generation := c.generation
+	c.mu.RUnlock()
+	if ok {
+		endpointGroupCacheRequests.WithLabelValues("hit").Inc()
+		return groups, nil
+	}
+	endpointGroupCacheRequests.WithLabelValues("miss").Inc()
+	groups, err := c.SDK.GetEndpointGroupsByHostname(ctx, hostname)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	if c.generation == generation {
+		c.groups[key] = groups
+	}
+	c.mu.Unlock()
+	return groups, nil
+}
+
+// Invalidate drops the cached endpoint groups of the given hostnames of all roles
+func (c *EndpointGroupCache) Invalidate(hostnames ...string) {
+	invalid := map[string]bool{}
+	for _, hostname := range hostnames {
+		invalid[hostname] = true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for key := range c.groups {
+		if invalid[key.hostname] {
+			delete(c.groups, key)
+		}
+	}
+}
+
+func (c *EndpointGroupCache) invalidateKey(key endpointGroupKey) {
+	c.mu.Lock()
+	c.generation++
+	delete(c.groups, key)
+	c.mu.Unlock()
+}
+
+func (c *EndpointGroupCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.groups)
+}
+
+// Start refreshes all cached hostnames every RefreshInterval until the stop channel is closed
+func (c *EndpointGroupCache) Start(stop <-chan struct{}) error {
+	if c.RefreshInterval <= 0 {
+		<-stop
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+	ticker := time.NewTicker(c.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			c.Refresh(ctx)
+		}
+	}
+}
+
+// Refresh resolves all cached hostnames again. Entries of load balancers which vanished are dropped,
+// other failures keep the last known endpoint groups.
+func (c *EndpointGroupCache) Refresh(ctx context.Context) {
+	c.mu.RLock()
+	keys := make([]endpointGroupKey, 0, len(c.groups))
+	for key := range c.groups {
+		keys = append(keys, key)
+	}
+	c.mu.RUnlock()
+	for _, key := range keys {
+		c.mu.RLock()
+		generation := c.generation
+		c.mu.RUnlock()
+		groups, err := c.SDK.GetEndpointGroupsByHostname(WithRole(ctx, key.role), key.hostname)
+		switch {
+		case err == ErrLoadBalancerNotFound:
+			endpointGroupCacheRefreshes.WithLabelValues("removed").Inc()
+			c.invalidateKey(key)
+		case err != nil:
+			endpointGroupCacheRefreshes.WithLabelValues("failed").Inc()
+			c.Log.Error(err, "unable to refresh endpoint groups", "hostname", key.hostname, "role", key.role)
+		default:
+			endpointGroupCacheRefreshes.WithLabelValues("refreshed").Inc()
+			c.mu.Lock()
+			if _, ok := c.groups[key]; ok && c.generation == generation {
+				c.groups[key] = groups
+			}
+			c.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var _ = Describe("EndpointGroupCache", func() {
+	const hostname = "app-1234.eu-west-1.elb.amazonaws.com"
+	var (
+		sdk   *Fake
+		cache *EndpointGroupCache
+		ctx   = context.Background()
+	)
+	requests := func(result string) float64 {
+		return testutil.ToFloat64(endpointGroupCacheRequests.WithLabelValues(result))
+	}
+	BeforeEach(func() {
+		sdk = &Fake{Strict: true}
+		sdk.AddLoadBalancer(hostname, &EndpointGroup{Name: "tg-1"})
+		cache = NewEndpointGroupCache(sdk, time.Minute, logf.NullLogger{})
+	})
+
+	It("should resolve a hostname once and serve it from the cache afterwards", func() {
+		hits, misses := requests("hit"), requests("miss")
+		for i := 0; i < 3; i++ {
+			groups, err := cache.GetEndpointGroupsByHostname(ctx, hostname)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups).To(HaveLen(1))
+			Expect(groups[0].Name).To(Equal("tg-1"))
+		}
+		Expect(sdk.Calls("GetEndpointGroupsByHostname")).To(HaveLen(1))
+		Expect(requests("hit") - hits).To(Equal(2.0))
+		Expect(requests("miss") - misses).To(Equal(1.0))
+	})
+
+	It("should cache the hostname per role", func() {
+		_, err := cache.GetEndpointGroupsByHostname(ctx, hostname)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = cache.GetEndpointGroupsByHostname(WithRole(ctx, "networking"), hostname)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cache.Len()).To(Equal(2))
+		Expect(sdk.Calls("GetEndpointGroupsByHostname")).To(HaveLen(2))
+	})
+
+	It("should not cache failed lookups", func() {
+		sdk.Fail("GetEndpointGroupsByHostname", errors.New("Throttling: Rate exceeded"), 1)
+		_, err := cache.GetEndpointGroupsByHostname(ctx, hostname)
+		Expect(err).To(HaveOccurred())
+		Expect(cache.Len()).To(BeZero())
+		_, err = cache.GetEndpointGroupsByHostname(ctx, hostname)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cache.Len()).To(Equal(1))
+	})
+
+	It("should resolve an invalidated hostname again for all roles", func() {
+		_, _ = cache.GetEndpointGroupsByHostname(ctx, hostname)
+		_, _ = cache.GetEndpointGroupsByHostname(WithRole(ctx, "networking"), hostname)
+		cache.Invalidate("other.example.com")
+		Expect(cache.Len()).To(Equal(2))
+		cache.Invalidate(hostname)
+		Expect(cache.Len()).To(BeZero())
+		_, err := cache.GetEndpointGroupsByHostname(ctx, hostname)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sdk.Calls("GetEndpointGroupsByHostname")).To(HaveLen(3))
+	})
+
+	It("should not cache a lookup which raced with an invalidation", func() {
+		sdk.Delay("GetEndpointGroupsByHostname", 50*time.Millisecond)
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+			groups, err := cache.GetEndpointGroupsByHostname(ctx, hostname)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups).To(HaveLen(1))
+		}()
+		Eventually(func() []Call { return sdk.Calls("GetEndpointGroupsByHostname") }).Should(HaveLen(1))
+		cache.Invalidate(hostname)
+		<-done
+		Expect(cache.Len()).To(BeZero())
+	})
+
+	It("should refresh the cached hostnames and drop vanished load balancers", func() {
+		const other = "other-5678.eu-west-1.elb.amazonaws.com"
+		sdk.AddLoadBalancer(other, &EndpointGroup{Name: "tg-2"})
+		_, _ = cache.GetEndpointGroupsByHostname(ctx, hostname)
+		_, _ = cache.GetEndpointGroupsByHostname(ctx, other)
+
+		sdk.AddLoadBalancer(hostname, &EndpointGroup{Name: "tg-1"}, &EndpointGroup{Name: "tg-3"})
+		sdk.RemoveLoadBalancer(other)
+		cache.Refresh(ctx)
+
+		Expect(cache.Len()).To(Equal(1))
+		groups, err := cache.GetEndpointGroupsByHostname(ctx, hostname)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(groups).To(HaveLen(2))
+		Expect(sdk.Calls("GetEndpointGroupsByHostname")).To(HaveLen(4))
+	})
+
+	It("should keep the last known endpoint groups if a refresh fails", func() {
+		_, _ = cache.GetEndpointGroupsByHostname(ctx, hostname)
+		sdk.Fail("GetEndpointGroupsByHostname", errors.New("internal error"), 1)
+		cache.Refresh(ctx)
+		groups, err := cache.GetEndpointGroupsByHostname(ctx, hostname)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(groups).To(HaveLen(1))
+	})
+})
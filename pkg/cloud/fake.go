@@ -2,27 +2,302 @@ package cloud
 
 import (
 	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
 )
 
+// Fake is a stateful cloud provider for tests. Its zero value reports every endpoint healthy in every group and
+// resolves every hostname to no endpoint groups. Tests add load balancers, script the health of
+// endpoints over time, inject errors and latency per method and assert the calls made.
 type Fake struct {
+	// Unhealthy reports endpoints without scripted health as unhealthy
 	Unhealthy bool
+	// Unreachable fails Ping
+	Unreachable bool
+	// Strict reports hostnames which were not added as ErrLoadBalancerNotFound
+	Strict bool
+	// Now is the clock health scripts are evaluated with, time.Now if unset
+	Now func() time.Time
+
+	mu            sync.Mutex
+	loadBalancers map[string][]*EndpointGroup
+	health        map[healthKey]*healthScript
+	faults        map[string]*fault
+	calls         []Call
+}
+
+// Call is a recorded call of a method of the fake. Only the arguments of the method are set.
+type Call struct {
+	Method   string
+	Hostname string
+	Endpoint string
+	Ports    []int32
+	// Groups are the names of the endpoint groups of the call
+	Groups []string
+}
+
+// HealthStep is the health of an endpoint for a duration. The last step of a script lasts forever.
+type HealthStep struct {
+	Healthy bool
+	For     time.Duration
+}
+
+// healthKey is the endpoint of a script, an empty group applies to the endpoint in all groups
+type healthKey struct {
+	group    string
+	endpoint string
+}
+
+type healthScript struct {
+	start time.Time
+	steps []HealthStep
+}
+
+type fault struct {
+	err     error
+	times   int
+	latency time.Duration
+}
+
+// AddLoadBalancer lets the hostname resolve to the given endpoint groups, it replaces earlier ones
+func (c *Fake) AddLoadBalancer(hostname string, groups ...*EndpointGroup) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.loadBalancers == nil {
+		c.loadBalancers = map[string][]*EndpointGroup{}
+	}
+	c.loadBalancers[hostname] = groups
+}
+
+// RemoveLoadBalancer lets the hostname resolve to ErrLoadBalancerNotFound
+func (c *Fake) RemoveLoadBalancer(hostname string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.loadBalancers == nil {
+		c.loadBalancers = map[string][]*EndpointGroup{}
+	}
+	c.loadBalancers[hostname] = nil
+}
+
+// SetHealth fixes the health of an endpoint, e.g. a pod ip or an instance id, in all groups
+func (c *Fake) SetHealth(endpoint string, healthy bool) {
+	c.ScriptHealth(endpoint, HealthStep{Healthy: healthy})
+}
+
+// ScriptHealth runs the health of an endpoint in all groups through the steps, starting now
+func (c *Fake) ScriptHealth(endpoint string, steps ...HealthStep) {
+	c.ScriptGroupHealth("", endpoint, steps...)
+}
+
+// ScriptGroupHealth runs the health of an endpoint in a single group through the steps, starting now.
+// It takes precedence over the health of the endpoint in all groups.
+func (c *Fake) ScriptGroupHealth(group, endpoint string, steps ...HealthStep) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.health == nil {
+		c.health = map[healthKey]*healthScript{}
+	}
+	c.health[healthKey{group: group, endpoint: endpoint}] = &healthScript{start: c.now(), steps: steps}
+}
+
+// Fail lets the next calls of a method, e.g. "IsEndpointHealthy", return err. A non-positive number
+// of times fails all calls until Fail is called with a nil error.
+func (c *Fake) Fail(method string, err error, times int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f := c.fault(method)
+	f.err, f.times = err, times
+}
+
+// Delay lets all calls of a method take the given time, calls return early once their context is done
+func (c *Fake) Delay(method string, latency time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fault(method).latency = latency
 }
 
-func (c *Fake) GetEndpointGroupsByHostname(context.Context, string) (groups []*EndpointGroup, err error) {
-	return
+// Calls returns the recorded calls of a method, all calls if the method is empty
+func (c *Fake) Calls(method string) []Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var calls []Call
+	for _, call := range c.calls {
+		if method == "" || call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// ResetCalls forgets all recorded calls
+func (c *Fake) ResetCalls() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = nil
+}
+
+func (c *Fake) GetEndpointGroupsByHostname(ctx context.Context, hostname string) ([]*EndpointGroup, error) {
+	if err := c.call(ctx, Call{Method: "GetEndpointGroupsByHostname", Hostname: hostname}); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	groups, ok := c.loadBalancers[hostname]
+	switch {
+	case ok && groups == nil, !ok && c.Strict:
+		return nil, ErrLoadBalancerNotFound
+	case !ok:
+		return nil, nil
+	}
+	copied := make([]*EndpointGroup, 0, len(groups))
+	for _, group := range groups {
+		g := *group
+		copied = append(copied, &g)
+	}
+	return copied, nil
 }
 
-func (c *Fake) GetLoadBalancerByHostname(ctx context.Context, name string) (lb *LoadBalancer, err error) {
-	return
+func (c *Fake) GetLoadBalancerByHostname(ctx context.Context, hostname string) (*LoadBalancer, error) {
+	if err := c.call(ctx, Call{Method: "GetLoadBalancerByHostname", Hostname: hostname}); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if groups, ok := c.loadBalancers[hostname]; !ok || groups == nil {
+		return nil, nil
+	}
+	return &LoadBalancer{Name: strings.SplitN(hostname, ".", 2)[0], Hostname: hostname}, nil
 }
 
-func (c *Fake) IsEndpointHealthy(ctx context.Context, groups []*EndpointGroup, name string, port []int32) (b bool, err error) {
-	if c.Unhealthy {
+// IsEndpointHealthy reports an endpoint healthy if it is healthy in all groups, the ports are only
+// recorded
+func (c *Fake) IsEndpointHealthy(ctx context.Context, groups []*EndpointGroup, name string, ports []int32) (bool, error) {
+	call := Call{Method: "IsEndpointHealthy", Endpoint: name, Ports: ports}
+	for _, group := range groups {
+		call.Groups = append(call.Groups, group.Name)
+	}
+	if err := c.call(ctx, call); err != nil {
+		return false, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(groups) == 0 {
 		return false, nil
 	}
+	for _, group := range groups {
+		if !c.healthy(group.Name, name) {
+			return false, nil
+		}
+	}
 	return true, nil
 }
 
+// RemoveEndpoint records the call, the endpoint is unhealthy in the groups afterwards
 func (c *Fake) RemoveEndpoint(ctx context.Context, groups []EndpointGroup, name string, port int32) error {
+	call := Call{Method: "RemoveEndpoint", Endpoint: name, Ports: []int32{port}}
+	for _, group := range groups {
+		call.Groups = append(call.Groups, group.Name)
+	}
+	if err := c.call(ctx, call); err != nil {
+		return err
+	}
+	for _, group := range groups {
+		c.ScriptGroupHealth(group.Name, name, HealthStep{Healthy: false})
+	}
+	return nil
+}
+
+func (c *Fake) InvalidateEndpoint(name string) {
+	c.record(Call{Method: "InvalidateEndpoint", Endpoint: name})
+}
+
+func (c *Fake) Ping(ctx context.Context) error {
+	if err := c.call(ctx, Call{Method: "Ping"}); err != nil {
+		return err
+	}
+	if c.Unreachable {
+		return errors.New("cloud provider unreachable")
+	}
 	return nil
 }
+
+func (c *Fake) InstanceID(providerID string) (string, error) {
+	c.record(Call{Method: "InstanceID", Endpoint: providerID})
+	if providerID == "" {
+		return "", errors.New("node has no provider id")
+	}
+	return providerID[strings.LastIndex(providerID, "/")+1:], nil
+}
+
+func (c *Fake) now() time.Time {
+	if c.Now == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
+
+func (c *Fake) fault(method string) *fault {
+	if c.faults == nil {
+		c.faults = map[string]*fault{}
+	}
+	f, ok := c.faults[method]
+	if !ok {
+		f = &fault{}
+		c.faults[method] = f
+	}
+	return f
+}
+
+func (c *Fake) record(call Call) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, call)
+}
+
+// call records a call and applies the injected latency and errors of its method
+func (c *Fake) call(ctx context.Context, call Call) error {
+	c.mu.Lock()
+	c.calls = append(c.calls, call)
+	var latency time.Duration
+	var err error
+	if f, ok := c.faults[call.Method]; ok {
+		latency, err = f.latency, f.err
+		if err != nil && f.times > 0 {
+			if f.times--; f.times == 0 {
+				f.err = nil
+			}
+		}
+	}
+	c.mu.Unlock()
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return err
+}
+
+// healthy evaluates the script of an endpoint in a group, the caller holds the lock
+func (c *Fake) healthy(group, endpoint string) bool {
+	script, ok := c.health[healthKey{group: group, endpoint: endpoint}]
+	if !ok {
+		script, ok = c.health[healthKey{endpoint: endpoint}]
+	}
+	if !ok || len(script.steps) == 0 {
+		return !c.Unhealthy
+	}
+	elapsed := c.now().Sub(script.start)
+	for _, step := range script.steps[:len(script.steps)-1] {
+		if elapsed < step.For {
+			return step.Healthy
+		}
+		elapsed -= step.For
+	}
+	return script.steps[len(script.steps)-1].Healthy
+}
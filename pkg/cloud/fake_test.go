@@ -0,0 +1,115 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Fake", func() {
+	var (
+		fake *Fake
+		now  time.Time
+		ctx  = context.Background()
+	)
+
+	BeforeEach(func() {
+		now = time.Unix(1000, 0)
+		fake = &Fake{Now: func() time.Time { return now }}
+	})
+
+	It("should resolve hostnames to the added load balancers", func() {
+		fake.AddLoadBalancer("lb-1.eu-west-1.elb.amazonaws.com", &EndpointGroup{Name: "tg-1"}, &EndpointGroup{Name: "tg-2"})
+		groups, err := fake.GetEndpointGroupsByHostname(ctx, "lb-1.eu-west-1.elb.amazonaws.com")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(groups).To(HaveLen(2))
+		Expect(groups[1].Name).To(Equal("tg-2"))
+
+		groups, err = fake.GetEndpointGroupsByHostname(ctx, "unknown")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(groups).To(BeEmpty())
+
+		fake.Strict = true
+		_, err = fake.GetEndpointGroupsByHostname(ctx, "unknown")
+		Expect(err).To(Equal(ErrLoadBalancerNotFound))
+
+		fake.Strict = false
+		fake.RemoveLoadBalancer("lb-1.eu-west-1.elb.amazonaws.com")
+		_, err = fake.GetEndpointGroupsByHostname(ctx, "lb-1.eu-west-1.elb.amazonaws.com")
+		Expect(err).To(Equal(ErrLoadBalancerNotFound))
+	})
+
+	It("should run health scripts over time", func() {
+		groups := []*EndpointGroup{{Name: "tg-1"}}
+		fake.ScriptHealth("10.0.0.1",
+			HealthStep{Healthy: false, For: 10 * time.Second},
+			HealthStep{Healthy: true, For: 5 * time.Second},
+			HealthStep{Healthy: false})
+		healthy := func() bool {
+			h, err := fake.IsEndpointHealthy(ctx, groups, "10.0.0.1", []int32{80})
+			Expect(err).ToNot(HaveOccurred())
+			return h
+		}
+		Expect(healthy()).To(BeFalse())
+		now = now.Add(12 * time.Second)
+		Expect(healthy()).To(BeTrue())
+		now = now.Add(time.Hour)
+		Expect(healthy()).To(BeFalse())
+	})
+
+	It("should report an endpoint unhealthy if it is unhealthy in any group", func() {
+		fake.ScriptGroupHealth("tg-2", "10.0.0.1", HealthStep{Healthy: false})
+		healthy, err := fake.IsEndpointHealthy(ctx, []*EndpointGroup{{Name: "tg-1"}}, "10.0.0.1", []int32{80})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(healthy).To(BeTrue())
+		healthy, err = fake.IsEndpointHealthy(ctx, []*EndpointGroup{{Name: "tg-1"}, {Name: "tg-2"}}, "10.0.0.1", []int32{80})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(healthy).To(BeFalse())
+	})
+
+	It("should report an endpoint without groups unhealthy", func() {
+		healthy, err := fake.IsEndpointHealthy(ctx, nil, "10.0.0.1", []int32{80})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(healthy).To(BeFalse())
+	})
+
+	It("should mark removed endpoints unhealthy and record the calls", func() {
+		Expect(fake.RemoveEndpoint(ctx, []EndpointGroup{{Name: "tg-1"}}, "10.0.0.1", 80)).To(Succeed())
+		healthy, err := fake.IsEndpointHealthy(ctx, []*EndpointGroup{{Name: "tg-1"}}, "10.0.0.1", []int32{80})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(healthy).To(BeFalse())
+
+		Expect(fake.Calls("RemoveEndpoint")).To(Equal([]Call{
+			{Method: "RemoveEndpoint", Endpoint: "10.0.0.1", Ports: []int32{80}, Groups: []string{"tg-1"}},
+		}))
+		Expect(fake.Calls("")).To(HaveLen(2))
+		fake.ResetCalls()
+		Expect(fake.Calls("")).To(BeEmpty())
+	})
+
+	It("should fail calls as often as requested", func() {
+		throttled := errors.New("throttled")
+		fake.Fail("Ping", throttled, 2)
+		Expect(fake.Ping(ctx)).To(Equal(throttled))
+		Expect(fake.Ping(ctx)).To(Equal(throttled))
+		Expect(fake.Ping(ctx)).To(Succeed())
+
+		fake.Fail("Ping", throttled, 0)
+		for i := 0; i < 5; i++ {
+			Expect(fake.Ping(ctx)).To(Equal(throttled))
+		}
+		fake.Fail("Ping", nil, 0)
+		Expect(fake.Ping(ctx)).To(Succeed())
+	})
+
+	It("should delay calls until their context is done", func() {
+		fake.Delay("IsEndpointHealthy", time.Hour)
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		_, err := fake.IsEndpointHealthy(ctx, nil, "10.0.0.1", []int32{80})
+		Expect(err).To(Equal(context.DeadlineExceeded))
+	})
+})
@@ -2,18 +2,59 @@ package cloud
 
 import (
 	"context"
+	"errors"
+	"time"
 )
 
+// ErrLoadBalancerNotFound is returned if no load balancer matches a hostname
+var ErrLoadBalancerNotFound = errors.New("no load balancer found")
+
 // SDK defines a common interface for cloud providers
 type SDK interface {
 	GetEndpointGroupsByHostname(context.Context, string) ([]*EndpointGroup, error)
+	// IsEndpointHealthy reports whether the endpoint is healthy in every one of the groups, an endpoint
+	// without groups is not. Within a group the endpoint has to be registered on at least one of the
+	// ports and healthy on every port it is registered on.
 	IsEndpointHealthy(context.Context, []*EndpointGroup, string, []int32) (bool, error)
 	RemoveEndpoint(context.Context, []EndpointGroup, string, int32) error
+	// InvalidateEndpoint drops cached health information of an endpoint, e.g. after its pod changed state
+	InvalidateEndpoint(string)
+	// Ping verifies that the cloud provider api is reachable
+	Ping(context.Context) error
+	// InstanceID returns the target id of a node from its provider id, used by instance mode endpoint groups
+	InstanceID(providerID string) (string, error)
 }
 
+const (
+	// TargetTypeIP endpoint groups route to pod ips and container ports
+	TargetTypeIP = "ip"
+	// TargetTypeInstance endpoint groups route to the nodes and the node ports of a service
+	TargetTypeInstance = "instance"
+)
+
 // EndpointGroup group defines a set of cloud endpoints
 type EndpointGroup struct {
 	Name string
+	// TargetType is either TargetTypeIP or TargetTypeInstance. Empty means TargetTypeIP.
+	TargetType string
+	// HealthCheckInterval is the time between two health checks of an endpoint
+	HealthCheckInterval time.Duration
+	// HealthyThreshold is the number of consecutive successful health checks before an endpoint is healthy
+	HealthyThreshold int
+	// Role the group is accessed with, empty for the default credentials
+	Role string
+}
+
+// MinHealthyAge is the time it takes at least for a newly registered endpoint to become healthy.
+// An endpoint reported healthy earlier than that after it got first seen for its pod was registered
+// for a previous owner of its ip.
+func (g *EndpointGroup) MinHealthyAge() time.Duration {
+	return g.HealthCheckInterval * time.Duration(g.HealthyThreshold)
+}
+
+// IsInstance reports whether the endpoints of the group are nodes
+func (g *EndpointGroup) IsInstance() bool {
+	return g.TargetType == TargetTypeInstance
 }
 
 // LoadBalancer defines a single load balancer from a cloud provider
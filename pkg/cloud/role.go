@@ -0,0 +1,67 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// RoleAnnotation selects the role the load balancer of an ingress is accessed with by its alias
+const RoleAnnotation = "kube-readiness.io/role"
+
+type roleKey struct{}
+
+// WithRole returns a context the endpoint groups of a load balancer are looked up with as the given role
+func WithRole(ctx context.Context, role string) context.Context {
+	if role == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, roleKey{}, role)
+}
+
+// RoleFrom returns the role of the context, empty for the default credentials
+func RoleFrom(ctx context.Context) string {
+	role, _ := ctx.Value(roleKey{}).(string)
+	return role
+}
+
+// RoleMapping assigns roles, e.g. aws role arns of other accounts, to the load balancers of ingresses.
+// Ingresses refer to roles by their alias only, so that they can not assume arbitrary roles.
+type RoleMapping struct {
+	// Roles by alias
+	Roles map[string]string
+	// Namespaces maps namespaces to the alias of the role of their ingresses
+	Namespaces map[string]string
+
+	mu sync.RWMutex
+}
+
+// Update replaces the roles and the namespaces mapped to them, e.g. after a configuration reload
+func (m *RoleMapping) Update(roles, namespaces map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Roles, m.Namespaces = roles, namespaces
+}
+
+// Role returns the role of an ingress, empty means the default credentials. The role of a mapped
+// namespace always wins, so that its tenants can not switch roles by the annotation. Ingresses of
+// other namespaces may select a role by the annotation.
+func (m *RoleMapping) Role(namespace string, annotations map[string]string) (string, error) {
+	if m == nil {
+		return "", nil
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	alias, ok := m.Namespaces[namespace]
+	if !ok {
+		alias = annotations[RoleAnnotation]
+	}
+	if alias == "" {
+		return "", nil
+	}
+	role, ok := m.Roles[alias]
+	if !ok {
+		return "", fmt.Errorf("role %q is not configured", alias)
+	}
+	return role, nil
+}
@@ -0,0 +1,80 @@
+package cloud
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// roleRecorder returns one endpoint group named after the role of each lookup
+type roleRecorder struct {
+	Fake
+	lookups int
+}
+
+func (r *roleRecorder) GetEndpointGroupsByHostname(ctx context.Context, hostname string) ([]*EndpointGroup, error) {
+	r.lookups++
+	return []*EndpointGroup{{Name: hostname, Role: RoleFrom(ctx)}}, nil
+}
+
+var _ = Describe("Roles", func() {
+	mapping := &RoleMapping{
+		Roles:      map[string]string{"networking": "arn:aws:iam::210987654321:role/readiness"},
+		Namespaces: map[string]string{"tenant-a": "networking"},
+	}
+
+	It("should map namespaces and annotations to roles", func() {
+		Expect(mapping.Role("tenant-a", nil)).To(Equal("arn:aws:iam::210987654321:role/readiness"))
+		Expect(mapping.Role("tenant-b", nil)).To(BeEmpty())
+		Expect(mapping.Role("tenant-b", map[string]string{RoleAnnotation: "networking"})).To(Equal("arn:aws:iam::210987654321:role/readiness"))
+		_, err := mapping.Role("tenant-b", map[string]string{RoleAnnotation: "arn:aws:iam::123456789012:role/admin"})
+		Expect(err).To(HaveOccurred())
+
+		Expect(mapping.Role("tenant-c", map[string]string{RoleAnnotation: ""})).To(BeEmpty())
+
+		updated := &RoleMapping{Roles: mapping.Roles}
+		updated.Update(mapping.Roles, map[string]string{"tenant-b": "networking"})
+		Expect(updated.Role("tenant-b", nil)).To(Equal("arn:aws:iam::210987654321:role/readiness"))
+
+		var unset *RoleMapping
+		Expect(unset.Role("tenant-a", map[string]string{RoleAnnotation: "networking"})).To(BeEmpty())
+	})
+
+	It("should not let the annotation switch the role of a mapped namespace", func() {
+		mapping := &RoleMapping{
+			Roles: map[string]string{
+				"networking": "arn:aws:iam::210987654321:role/readiness",
+				"admin":      "arn:aws:iam::123456789012:role/admin",
+			},
+			Namespaces: map[string]string{"tenant-a": "networking"},
+		}
+		for _, alias := range []string{"admin", "", "unknown"} {
+			Expect(mapping.Role("tenant-a", map[string]string{RoleAnnotation: alias})).To(Equal("arn:aws:iam::210987654321:role/readiness"), alias)
+		}
+	})
+
+	It("should cache endpoint groups per role", func() {
+		sdk := &roleRecorder{}
+		cache := NewEndpointGroupCache(sdk, 0, logf.NullLogger{})
+		ctx := WithRole(context.TODO(), "networking")
+		for i := 0; i < 2; i++ {
+			groups, err := cache.GetEndpointGroupsByHostname(ctx, "web")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups[0].Role).To(Equal("networking"))
+			groups, err = cache.GetEndpointGroupsByHostname(context.TODO(), "web")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(groups[0].Role).To(BeEmpty())
+		}
+		Expect(sdk.lookups).To(Equal(2))
+
+		cache.Refresh(context.TODO())
+		groups, _ := cache.GetEndpointGroupsByHostname(ctx, "web")
+		Expect(groups[0].Role).To(Equal("networking"))
+		Expect(sdk.lookups).To(Equal(4))
+
+		cache.Invalidate("web")
+		Expect(cache.Len()).To(BeZero())
+	})
+})
@@ -0,0 +1,297 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// APIVersion is the only supported version of the configuration file
+	APIVersion = "kube-readiness.io/v1alpha1"
+	// Kind of the configuration file
+	Kind = "ControllerConfiguration"
+)
+
+// Config holds the settings of the controller. Its defaults are the command line flags, the
+// configuration file overrides the fields it sets.
+type Config struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	// SyncPeriod is the minimum interval in which all watched objects are reconciled
+	SyncPeriod  metav1.Duration   `json:"syncPeriod"`
+	AWS         AWSConfig         `json:"aws"`
+	Namespaces  NamespacesConfig  `json:"namespaces"`
+	Cache       CacheConfig       `json:"cache"`
+	Timeouts    TimeoutsConfig    `json:"timeouts"`
+	Concurrency ConcurrencyConfig `json:"concurrency"`
+	Workqueue   WorkqueueConfig   `json:"workqueue"`
+	// CloudRateLimit limits the requests to the load balancer api of all controllers together, every
+	// page and retry takes a token while results served from the cache take none
+	CloudRateLimit RateLimitConfig `json:"cloudRateLimit"`
+}
+
+type AWSConfig struct {
+	// Region of load balancers whose region can not be told from their hostname, eu-west-1 by
+	// default. It is detected from the environment or the instance metadata if set empty.
+	Region        string `json:"region,omitempty"`
+	AssumeRoleArn string `json:"assumeRoleArn,omitempty"`
+	// WebIdentityTokenFile is exchanged for the credentials of WebIdentityRoleArn
+	WebIdentityTokenFile string `json:"webIdentityTokenFile,omitempty"`
+	WebIdentityRoleArn   string `json:"webIdentityRoleArn,omitempty"`
+	// Roles are the role arns ingresses may select by alias, e.g. of the account owning the load
+	// balancers. Unlike the other aws settings they are applied without a restart, as are NamespaceRoles.
+	Roles map[string]string `json:"roles,omitempty"`
+	// NamespaceRoles maps namespaces to the alias of the role of their ingresses, it takes
+	// precedence over the role annotation of the ingresses
+	NamespaceRoles map[string]string `json:"namespaceRoles,omitempty"`
+	Endpoints      EndpointsConfig   `json:"endpoints,omitempty"`
+}
+
+// EndpointsConfig overrides the urls of the aws apis, {region} is replaced with the region of the client
+type EndpointsConfig struct {
+	ELBV2 string `json:"elbv2,omitempty"`
+	EC2   string `json:"ec2,omitempty"`
+	STS   string `json:"sts,omitempty"`
+}
+
+type NamespacesConfig struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	// Selector is a label selector namespaces have to match
+	Selector string `json:"selector,omitempty"`
+}
+
+// CacheConfig configures the sdk cache. A zero ttl disables caching of the operation. Changes apply
+// without a restart, disabling the cache drops the cached results.
+type CacheConfig struct {
+	Enabled         bool            `json:"enabled"`
+	LoadBalancerTTL metav1.Duration `json:"loadBalancerTTL"`
+	TargetGroupTTL  metav1.Duration `json:"targetGroupTTL"`
+	TargetHealthTTL metav1.Duration `json:"targetHealthTTL"`
+	NotFoundTTL     metav1.Duration `json:"notFoundTTL"`
+}
+
+type TimeoutsConfig struct {
+	// AWSCall bounds a single api call including all of its pages, zero disables the timeout
+	AWSCall metav1.Duration `json:"awsCall"`
+	// EndpointGroupRefresh is the interval the endpoint groups of all load balancers are refreshed in
+	EndpointGroupRefresh metav1.Duration `json:"endpointGroupRefresh"`
+}
+
+type ConcurrencyConfig struct {
+	// Pods is the number of pods reconciled in parallel
+	Pods int `json:"pods"`
+	// Services is the number of services reconciled in parallel
+	Services int `json:"services"`
+	// Ingresses is the number of ingresses reconciled in parallel
+	Ingresses int `json:"ingresses"`
+}
+
+// WorkqueueConfig configures the rate limiter of each controller for failed and requeued reconciles.
+// A request is delayed by the maximum of its exponential backoff and the shared token bucket.
+type WorkqueueConfig struct {
+	BaseDelay metav1.Duration `json:"baseDelay"`
+	MaxDelay  metav1.Duration `json:"maxDelay"`
+	QPS       float64         `json:"qps"`
+	Burst     int             `json:"burst"`
+}
+
+// RateLimitConfig is a token bucket, a zero qps disables the limit
+type RateLimitConfig struct {
+	QPS   float64 `json:"qps"`
+	Burst int     `json:"burst"`
+}
+
+// Default returns the configuration used without flags and configuration file
+func Default() Config {
+	return Config{
+		APIVersion: APIVersion,
+		Kind:       Kind,
+		SyncPeriod: metav1.Duration{Duration: time.Minute},
+		AWS:        AWSConfig{Region: "eu-west-1"},
+		Cache: CacheConfig{
+			LoadBalancerTTL: metav1.Duration{Duration: time.Minute},
+			TargetGroupTTL:  metav1.Duration{Duration: time.Minute},
+			TargetHealthTTL: metav1.Duration{Duration: 10 * time.Second},
+			NotFoundTTL:     metav1.Duration{Duration: 30 * time.Second},
+		},
+		Timeouts: TimeoutsConfig{
+			AWSCall:              metav1.Duration{Duration: 10 * time.Second},
+			EndpointGroupRefresh: metav1.Duration{Duration: 5 * time.Minute},
+		},
+		Concurrency: ConcurrencyConfig{Pods: 20, Services: 1, Ingresses: 1},
+		Workqueue: WorkqueueConfig{
+			BaseDelay: metav1.Duration{Duration: 5 * time.Millisecond},
+			MaxDelay:  metav1.Duration{Duration: 1000 * time.Second},
+			QPS:       10,
+			Burst:     100,
+		},
+		CloudRateLimit: RateLimitConfig{Burst: 10},
+	}
+}
+
+// Parse reads a configuration file on top of the given defaults and validates the result.
+// Unknown fields are rejected, so that typos do not go unnoticed.
+func Parse(data []byte, defaults Config) (*Config, error) {
+	cfg := defaults
+	cfg.APIVersion, cfg.Kind = "", ""
+	cfg.Namespaces.Include = append([]string(nil), defaults.Namespaces.Include...)
+	cfg.Namespaces.Exclude = append([]string(nil), defaults.Namespaces.Exclude...)
+	cfg.AWS.Roles = copyMap(defaults.AWS.Roles)
+	cfg.AWS.NamespaceRoles = copyMap(defaults.AWS.NamespaceRoles)
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, fmt.Errorf("unable to parse configuration: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Validate returns all problems of the configuration at once
+func (c *Config) Validate() error {
+	var errs []error
+	if c.APIVersion != APIVersion {
+		errs = append(errs, fmt.Errorf("apiVersion %q is not supported, expected %q", c.APIVersion, APIVersion))
+	}
+	if c.Kind != Kind {
+		errs = append(errs, fmt.Errorf("kind %q is not supported, expected %q", c.Kind, Kind))
+	}
+	if c.SyncPeriod.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("syncPeriod has to be positive"))
+	}
+	if c.AWS.WebIdentityTokenFile != "" && c.AWS.WebIdentityRoleArn == "" {
+		errs = append(errs, fmt.Errorf("aws.webIdentityTokenFile requires aws.webIdentityRoleArn"))
+	}
+	for alias, role := range c.AWS.Roles {
+		if role == "" {
+			errs = append(errs, fmt.Errorf("aws.roles: role %q has no arn", alias))
+		}
+	}
+	for namespace, alias := range c.AWS.NamespaceRoles {
+		if _, ok := c.AWS.Roles[alias]; !ok {
+			errs = append(errs, fmt.Errorf("aws.namespaceRoles: role %q of namespace %q is not configured", alias, namespace))
+		}
+	}
+	for name, endpoint := range map[string]string{
+		"aws.endpoints.elbv2": c.AWS.Endpoints.ELBV2,
+		"aws.endpoints.ec2":   c.AWS.Endpoints.EC2,
+		"aws.endpoints.sts":   c.AWS.Endpoints.STS,
+	} {
+		if endpoint == "" {
+			continue
+		}
+		if u, err := url.Parse(strings.Replace(endpoint, "{region}", "region", -1)); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s has to be an absolute url", name))
+		}
+	}
+	if _, err := labels.Parse(c.Namespaces.Selector); err != nil {
+		errs = append(errs, fmt.Errorf("namespaces.selector is invalid: %v", err))
+	}
+	for name, ttl := range map[string]metav1.Duration{
+		"cache.loadBalancerTTL":         c.Cache.LoadBalancerTTL,
+		"cache.targetGroupTTL":          c.Cache.TargetGroupTTL,
+		"cache.targetHealthTTL":         c.Cache.TargetHealthTTL,
+		"cache.notFoundTTL":             c.Cache.NotFoundTTL,
+		"timeouts.awsCall":              c.Timeouts.AWSCall,
+		"timeouts.endpointGroupRefresh": c.Timeouts.EndpointGroupRefresh,
+		"workqueue.baseDelay":           c.Workqueue.BaseDelay,
+		"workqueue.maxDelay":            c.Workqueue.MaxDelay,
+	} {
+		if ttl.Duration < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", name))
+		}
+	}
+	for name, concurrency := range map[string]int{
+		"concurrency.pods":      c.Concurrency.Pods,
+		"concurrency.services":  c.Concurrency.Services,
+		"concurrency.ingresses": c.Concurrency.Ingresses,
+	} {
+		if concurrency < 1 {
+			errs = append(errs, fmt.Errorf("%s has to be at least 1", name))
+		}
+	}
+	if c.Workqueue.MaxDelay.Duration < c.Workqueue.BaseDelay.Duration {
+		errs = append(errs, fmt.Errorf("workqueue.maxDelay must not be less than workqueue.baseDelay"))
+	}
+	if c.Workqueue.QPS <= 0 || c.Workqueue.Burst < 1 {
+		errs = append(errs, fmt.Errorf("workqueue.qps has to be positive and workqueue.burst at least 1"))
+	}
+	if c.CloudRateLimit.QPS < 0 {
+		errs = append(errs, fmt.Errorf("cloudRateLimit.qps must not be negative"))
+	}
+	if c.CloudRateLimit.QPS > 0 && c.CloudRateLimit.Burst < 1 {
+		errs = append(errs, fmt.Errorf("cloudRateLimit.burst has to be at least 1"))
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// NamespaceSelector returns the parsed namespace selector of a valid configuration
+func (c *Config) NamespaceSelector() labels.Selector {
+	selector, err := labels.Parse(c.Namespaces.Selector)
+	if err != nil {
+		return labels.Nothing()
+	}
+	return selector
+}
+
+// RestartRequired lists the settings which differ from the given configuration but can not be
+// applied to a running controller
+func (c *Config) RestartRequired(running *Config) []string {
+	var fields []string
+	for name, changed := range map[string]bool{
+		"syncPeriod":                    c.SyncPeriod != running.SyncPeriod,
+		"aws":                           !reflect.DeepEqual(c.AWS.withoutRoles(), running.AWS.withoutRoles()),
+		"timeouts.endpointGroupRefresh": c.Timeouts.EndpointGroupRefresh != running.Timeouts.EndpointGroupRefresh,
+		"concurrency":                   c.Concurrency != running.Concurrency,
+		"workqueue":                     c.Workqueue != running.Workqueue,
+		"cloudRateLimit":                c.CloudRateLimit != running.CloudRateLimit,
+	} {
+		if changed {
+			fields = append(fields, name)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// Apply returns the configuration a controller running with the given one ends up with once it
+// applied c: the settings RestartRequired lists keep their running values.
+func (c *Config) Apply(running *Config) *Config {
+	applied := *c
+	applied.SyncPeriod = running.SyncPeriod
+	applied.AWS = running.AWS
+	applied.AWS.Roles = copyMap(c.AWS.Roles)
+	applied.AWS.NamespaceRoles = copyMap(c.AWS.NamespaceRoles)
+	applied.Timeouts.EndpointGroupRefresh = running.Timeouts.EndpointGroupRefresh
+	applied.Concurrency = running.Concurrency
+	applied.Workqueue = running.Workqueue
+	applied.CloudRateLimit = running.CloudRateLimit
+	return &applied
+}
+
+// withoutRoles drops the roles, which a running controller picks up
+func (c AWSConfig) withoutRoles() AWSConfig {
+	c.Roles, c.NamespaceRoles = nil, nil
+	return c
+}
+
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(m))
+	for key, value := range m {
+		copied[key] = value
+	}
+	return copied
+}
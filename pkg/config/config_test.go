@@ -0,0 +1,211 @@
+package config
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const header = `
+apiVersion: kube-readiness.io/v1alpha1
+kind: ControllerConfiguration
+`
+
+var _ = Describe("Config", func() {
+	It("should apply the file on top of the defaults", func() {
+		defaults := Default()
+		defaults.AWS.AssumeRoleArn = "arn:aws:iam::123456789012:role/flag"
+		cfg, err := Parse([]byte(header+`
+syncPeriod: 5m
+aws:
+  region: us-east-1
+  endpoints:
+    elbv2: https://elasticloadbalancing-fips.{region}.amazonaws.com
+namespaces:
+  include: [tenant-a, tenant-b]
+  selector: readiness-gate=enabled
+cache:
+  enabled: true
+  targetHealthTTL: 5s
+`), defaults)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cfg.SyncPeriod.Duration).To(Equal(5 * time.Minute))
+		Expect(cfg.AWS.Region).To(Equal("us-east-1"))
+		Expect(cfg.AWS.AssumeRoleArn).To(Equal(defaults.AWS.AssumeRoleArn))
+		Expect(cfg.Namespaces.Include).To(Equal([]string{"tenant-a", "tenant-b"}))
+		Expect(cfg.NamespaceSelector().String()).To(Equal("readiness-gate=enabled"))
+		Expect(cfg.Cache.Enabled).To(BeTrue())
+		Expect(cfg.Cache.TargetHealthTTL.Duration).To(Equal(5 * time.Second))
+		Expect(cfg.Cache.LoadBalancerTTL).To(Equal(defaults.Cache.LoadBalancerTTL))
+		Expect(cfg.Concurrency.Pods).To(Equal(20))
+	})
+
+	It("should merge the roles without touching the defaults", func() {
+		defaults := Default()
+		defaults.AWS.Roles = map[string]string{"shared": "arn:aws:iam::123456789012:role/shared"}
+		cfg, err := Parse([]byte(header+`
+aws:
+  region: eu-west-1
+  roles:
+    networking: arn:aws:iam::210987654321:role/readiness
+  namespaceRoles:
+    tenant-a: networking
+`), defaults)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cfg.AWS.Roles).To(HaveLen(2))
+		Expect(defaults.AWS.Roles).To(HaveLen(1))
+		// roles are applied while running
+		Expect(cfg.RestartRequired(&defaults)).To(BeEmpty())
+
+		_, err = Parse([]byte(header+"aws:\n  region: eu-west-1\n  namespaceRoles:\n    tenant-a: unknown\n"), defaults)
+		Expect(err).To(MatchError(ContainSubstring("aws.namespaceRoles")))
+	})
+
+	It("should default to eu-west-1 unless the region is cleared for detection", func() {
+		cfg, err := Parse([]byte(header+"syncPeriod: 5m\n"), Default())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cfg.AWS.Region).To(Equal("eu-west-1"))
+		cfg, err = Parse([]byte(header+"aws:\n  region: \"\"\n"), Default())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cfg.AWS.Region).To(BeEmpty())
+	})
+
+	It("should require the supported version", func() {
+		_, err := Parse([]byte("syncPeriod: 5m\n"), Default())
+		Expect(err).To(MatchError(ContainSubstring("apiVersion")))
+		_, err = Parse([]byte("apiVersion: kube-readiness.io/v2\nkind: ControllerConfiguration\n"), Default())
+		Expect(err).To(MatchError(ContainSubstring("not supported")))
+	})
+
+	It("should reject unknown fields", func() {
+		_, err := Parse([]byte(header+"syncPerod: 5m\n"), Default())
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should report all validation errors at once", func() {
+		_, err := Parse([]byte(header+`
+syncPeriod: 0s
+aws:
+  webIdentityTokenFile: /var/run/secrets/eks.amazonaws.com/serviceaccount/token
+  endpoints:
+    sts: sts.amazonaws.com
+namespaces:
+  selector: "a in (b"
+timeouts:
+  awsCall: -1s
+concurrency:
+  pods: 0
+workqueue:
+  baseDelay: 1m
+  maxDelay: 1s
+cloudRateLimit:
+  qps: 5
+  burst: 0
+`), Default())
+		Expect(err).To(HaveOccurred())
+		for _, field := range []string{"syncPeriod", "aws.webIdentityTokenFile", "aws.endpoints.sts", "namespaces.selector", "timeouts.awsCall", "concurrency.pods", "workqueue.maxDelay", "cloudRateLimit.burst"} {
+			Expect(err.Error()).To(ContainSubstring(field))
+		}
+	})
+
+	It("should list the changes which require a restart", func() {
+		running := Default()
+		changed := Default()
+		changed.Namespaces.Exclude = []string{"kube-system"}
+		changed.Cache.TargetHealthTTL.Duration = time.Second
+		changed.Cache.Enabled = !running.Cache.Enabled
+		changed.AWS.Roles = map[string]string{"networking": "arn:aws:iam::210987654321:role/readiness"}
+		Expect(changed.RestartRequired(&running)).To(BeEmpty())
+		changed.AWS.Region = "us-east-1"
+		changed.Concurrency.Pods = 50
+		changed.CloudRateLimit.QPS = 20
+		Expect(changed.RestartRequired(&running)).To(Equal([]string{"aws", "cloudRateLimit", "concurrency"}))
+	})
+})
+
+var _ = Describe("Watcher", func() {
+	var (
+		watcher *Watcher
+		changes []*Config
+	)
+	configMap := func(data string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "kube-readiness"},
+			Data:       map[string]string{DefaultKey: header + data},
+		}
+	}
+	BeforeEach(func() {
+		changes = nil
+		watcher = &Watcher{
+			Client:    fake.NewSimpleClientset(configMap("syncPeriod: 2m\n")),
+			Namespace: "kube-system",
+			Name:      "kube-readiness",
+			Defaults:  Default(),
+			Log:       logf.NullLogger{},
+			OnChange:  func(cfg *Config) { changes = append(changes, cfg) },
+		}
+	})
+
+	It("should load the initial configuration without notifying", func() {
+		cfg, err := watcher.Load()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cfg.SyncPeriod.Duration).To(Equal(2 * time.Minute))
+		Expect(watcher.Current()).To(Equal(cfg))
+		Expect(changes).To(BeEmpty())
+	})
+
+	It("should only notify about valid changes", func() {
+		_, err := watcher.Load()
+		Expect(err).ToNot(HaveOccurred())
+
+		By("applying the same configuration")
+		Expect(watcher.Update(configMap("syncPeriod: 2m\n"))).To(Succeed())
+		Expect(changes).To(BeEmpty())
+
+		By("applying an invalid configuration")
+		Expect(watcher.Update(configMap("syncPeriod: -2m\n"))).ToNot(Succeed())
+		Expect(watcher.Update(&corev1.ConfigMap{})).ToNot(Succeed())
+		Expect(changes).To(BeEmpty())
+		Expect(watcher.Current().SyncPeriod.Duration).To(Equal(2 * time.Minute))
+
+		By("applying a changed configuration")
+		Expect(watcher.Update(configMap("namespaces:\n  exclude: [kube-system]\n"))).To(Succeed())
+		Expect(changes).To(HaveLen(1))
+		Expect(changes[0].Namespaces.Exclude).To(Equal([]string{"kube-system"}))
+		Expect(watcher.Running()).To(Equal(changes[0]))
+		// the dropped sync period falls back to the default, which takes a restart
+		Expect(watcher.Current().SyncPeriod.Duration).To(Equal(time.Minute))
+		Expect(changes[0].SyncPeriod.Duration).To(Equal(2 * time.Minute))
+	})
+
+	It("should keep reporting changes which require a restart until they are reverted", func() {
+		_, err := watcher.Load()
+		Expect(err).ToNot(HaveOccurred())
+
+		By("changing a setting which requires a restart")
+		Expect(watcher.Update(configMap("syncPeriod: 2m\nconcurrency:\n  pods: 50\n"))).To(Succeed())
+		Expect(watcher.RestartRequired()).To(Equal([]string{"concurrency"}))
+		Expect(testutil.ToFloat64(restartRequired)).To(Equal(1.0))
+		Expect(changes[0].Concurrency.Pods).To(Equal(20))
+
+		By("changing settings which are applied while running")
+		Expect(watcher.Update(configMap("syncPeriod: 2m\nconcurrency:\n  pods: 50\naws:\n  roles:\n    networking: arn:aws:iam::210987654321:role/readiness\n"))).To(Succeed())
+		Expect(watcher.RestartRequired()).To(Equal([]string{"concurrency"}))
+		Expect(testutil.ToFloat64(restartRequired)).To(Equal(1.0))
+		Expect(changes).To(HaveLen(2))
+		Expect(changes[1].Concurrency.Pods).To(Equal(20))
+		Expect(changes[1].AWS.Roles).To(HaveKey("networking"))
+		Expect(watcher.Running()).To(Equal(changes[1]))
+
+		By("reverting the setting which requires a restart")
+		Expect(watcher.Update(configMap("syncPeriod: 2m\naws:\n  roles:\n    networking: arn:aws:iam::210987654321:role/readiness\n"))).To(Succeed())
+		Expect(watcher.RestartRequired()).To(BeEmpty())
+		Expect(testutil.ToFloat64(restartRequired)).To(BeZero())
+	})
+})
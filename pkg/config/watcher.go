@@ -0,0 +1,187 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// DefaultKey is the key of the configuration file within the ConfigMap
+const DefaultKey = "config.yaml"
+
+var (
+	reloads = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:      "reloads",
+			Namespace: "config",
+			Help:      "Number of configuration reloads by result",
+		},
+		[]string{"result"},
+	)
+	lastReloadSuccessful = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name:      "last_reload_successful",
+			Namespace: "config",
+			Help:      "Whether the last configuration reload was successful",
+		},
+	)
+	restartRequired = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name:      "restart_required",
+			Namespace: "config",
+			Help:      "Whether the configuration has changes which only take effect after a restart",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(reloads, lastReloadSuccessful, restartRequired)
+}
+
+// Watcher reloads the configuration from a ConfigMap whenever it changes. Invalid configurations are
+// reported and otherwise ignored, the controller keeps running with the last valid one.
+type Watcher struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Name      string
+	// Key of the configuration file within the ConfigMap, DefaultKey if empty
+	Key string
+	// Defaults are the settings the configuration file is applied on
+	Defaults Config
+	Log      logr.Logger
+	// OnChange is called with the running configuration whenever a valid configuration differs from
+	// the current one. Settings which require a restart keep their running values.
+	OnChange func(*Config)
+
+	mu sync.Mutex
+	// current is the last valid configuration, running the one the controller started with and the
+	// settings of current it applied since
+	current *Config
+	running *Config
+}
+
+// Load reads the current configuration once, it does not call OnChange
+func (w *Watcher) Load() (*Config, error) {
+	configMap, err := w.Client.CoreV1().ConfigMaps(w.Namespace).Get(w.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := w.parse(configMap)
+	if err != nil {
+		return nil, err
+	}
+	w.mu.Lock()
+	w.current, w.running = cfg, cfg
+	w.mu.Unlock()
+	restartRequired.Set(0)
+	return cfg, nil
+}
+
+// Current returns the last valid configuration
+func (w *Watcher) Current() *Config {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.current
+}
+
+// Running returns the configuration the controller runs with
+func (w *Watcher) Running() *Config {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.running
+}
+
+// RestartRequired lists the settings of the last valid configuration which only take effect after a restart
+func (w *Watcher) RestartRequired() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.running == nil {
+		return nil
+	}
+	return w.current.RestartRequired(w.running)
+}
+
+func (w *Watcher) Start(stop <-chan struct{}) error {
+	lw := cache.NewFilteredListWatchFromClient(w.Client.CoreV1().RESTClient(), "configmaps", w.Namespace, func(options *metav1.ListOptions) {
+		options.FieldSelector = fields.OneTermEqualSelector("metadata.name", w.Name).String()
+	})
+	_, informer := cache.NewInformer(lw, &corev1.ConfigMap{}, 0, cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			_ = w.Update(obj.(*corev1.ConfigMap))
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			_ = w.Update(obj.(*corev1.ConfigMap))
+		},
+		DeleteFunc: func(interface{}) {
+			w.Log.Info("configuration deleted, keeping the current configuration")
+		},
+	})
+	informer.Run(stop)
+	return nil
+}
+
+// NeedLeaderElection makes sure followers are configured as well
+func (w *Watcher) NeedLeaderElection() bool {
+	return false
+}
+
+// Update applies the configuration within the ConfigMap if it is valid and changed
+func (w *Watcher) Update(configMap *corev1.ConfigMap) error {
+	cfg, err := w.parse(configMap)
+	if err != nil {
+		reloads.WithLabelValues("error").Inc()
+		lastReloadSuccessful.Set(0)
+		w.Log.Error(err, "invalid configuration, keeping the current configuration", "resourceVersion", configMap.ResourceVersion)
+		return err
+	}
+	reloads.WithLabelValues("success").Inc()
+	lastReloadSuccessful.Set(1)
+	w.mu.Lock()
+	previous := w.current
+	w.current = cfg
+	if reflect.DeepEqual(previous, cfg) {
+		w.mu.Unlock()
+		return nil
+	}
+	// compare against what the controller runs with, earlier changes requiring a restart are still pending
+	var fields []string
+	running := cfg
+	if w.running != nil {
+		fields = cfg.RestartRequired(w.running)
+		running = cfg.Apply(w.running)
+	}
+	w.running = running
+	w.mu.Unlock()
+	if len(fields) > 0 {
+		restartRequired.Set(1)
+		w.Log.Info("configuration changes require a restart to take effect", "fields", fields)
+	} else {
+		restartRequired.Set(0)
+	}
+	w.Log.Info("configuration changed", "resourceVersion", configMap.ResourceVersion)
+	if w.OnChange != nil {
+		w.OnChange(running)
+	}
+	return nil
+}
+
+func (w *Watcher) parse(configMap *corev1.ConfigMap) (*Config, error) {
+	key := w.Key
+	if key == "" {
+		key = DefaultKey
+	}
+	data, ok := configMap.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("configmap %s/%s has no key %q", configMap.Namespace, configMap.Name, key)
+	}
+	return Parse([]byte(data), w.Defaults)
+}
@@ -0,0 +1,159 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/nirnanaaa/kube-readiness/pkg/cloud"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+)
+
+const (
+	LivenessPath  = "/healthz"
+	ReadinessPath = "/readyz"
+)
+
+// Checker reports an error if the component it guards is not healthy
+type Checker func(req *http.Request) error
+
+// Ping is a Checker which always succeeds. It is used to signal that the process is alive.
+func Ping(_ *http.Request) error {
+	return nil
+}
+
+// Server serves the liveness and readiness endpoints of the controller
+type Server struct {
+	Addr string
+	Log  logr.Logger
+
+	mu              sync.RWMutex
+	livenessChecks  map[string]Checker
+	readinessChecks map[string]Checker
+}
+
+func NewServer(addr string, log logr.Logger) *Server {
+	return &Server{
+		Addr:            addr,
+		Log:             log,
+		livenessChecks:  map[string]Checker{},
+		readinessChecks: map[string]Checker{},
+	}
+}
+
+// AddLivenessCheck registers a check which is evaluated on the liveness endpoint
+func (s *Server) AddLivenessCheck(name string, check Checker) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.livenessChecks[name] = check
+}
+
+// AddReadinessCheck registers a check which is evaluated on the readiness endpoint
+func (s *Server) AddReadinessCheck(name string, check Checker) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readinessChecks[name] = check
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(LivenessPath, s.handle(s.livenessChecks))
+	mux.Handle(ReadinessPath, s.handle(s.readinessChecks))
+	return mux
+}
+
+func (s *Server) handle(checks map[string]Checker) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		s.mu.RLock()
+		names := make([]string, 0, len(checks))
+		for name := range checks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var out strings.Builder
+		failed := false
+		for _, name := range names {
+			if err := checks[name](req); err != nil {
+				failed = true
+				s.Log.V(1).Info("health check failed", "path", req.URL.Path, "check", name, "error", err.Error())
+				fmt.Fprintf(&out, "[-]%s failed: %v\n", name, err)
+				continue
+			}
+			fmt.Fprintf(&out, "[+]%s ok\n", name)
+		}
+		s.mu.RUnlock()
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if failed {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		fmt.Fprint(w, out.String())
+	}
+}
+
+// Start serves the health endpoints until the stop channel is closed.
+// It is not added to the manager as it has to be reachable before the caches have synced.
+func (s *Server) Start(stop <-chan struct{}) error {
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: s.Handler()}
+	go func() {
+		<-stop
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			s.Log.Error(err, "unable to shut down health server")
+		}
+	}()
+	s.Log.Info("serving health probes", "addr", s.Addr)
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// CacheSync tracks whether the informer caches of the manager have synced.
+// It has to be added to the manager, which starts it once the caches are running.
+type CacheSync struct {
+	Cache  cache.Cache
+	synced int32
+}
+
+func (c *CacheSync) Start(stop <-chan struct{}) error {
+	if c.Cache.WaitForCacheSync(stop) {
+		atomic.StoreInt32(&c.synced, 1)
+	}
+	<-stop
+	return nil
+}
+
+// NeedLeaderElection makes sure the cache state is reported on followers as well
+func (c *CacheSync) NeedLeaderElection() bool {
+	return false
+}
+
+func (c *CacheSync) Check(_ *http.Request) error {
+	if atomic.LoadInt32(&c.synced) == 0 {
+		return errors.New("informer caches not synced")
+	}
+	return nil
+}
+
+// CloudCheck returns a Checker which verifies that the cloud provider is reachable
+func CloudCheck(sdk cloud.SDK, timeout time.Duration) Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		return sdk.Ping(ctx)
+	}
+}
@@ -0,0 +1,53 @@
+package health
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/nirnanaaa/kube-readiness/pkg/cloud"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func probe(server *Server, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+var _ = Describe("Health Server", func() {
+	var server *Server
+	BeforeEach(func() {
+		server = NewServer(":0", logf.NullLogger{})
+		server.AddLivenessCheck("ping", Ping)
+	})
+	It("should report liveness", func() {
+		rec := probe(server, LivenessPath)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(ContainSubstring("[+]ping ok"))
+	})
+	It("should fail readiness when a check fails", func() {
+		server.AddReadinessCheck("ok", Ping)
+		server.AddReadinessCheck("broken", func(_ *http.Request) error {
+			return errors.New("boom")
+		})
+		rec := probe(server, ReadinessPath)
+		Expect(rec.Code).To(Equal(http.StatusInternalServerError))
+		Expect(rec.Body.String()).To(ContainSubstring("[-]broken failed: boom"))
+		Expect(rec.Body.String()).To(ContainSubstring("[+]ok ok"))
+	})
+	It("should not be ready before the caches have synced", func() {
+		server.AddReadinessCheck("informers", (&CacheSync{}).Check)
+		Expect(probe(server, ReadinessPath).Code).To(Equal(http.StatusInternalServerError))
+	})
+	It("should report the cloud provider reachability", func() {
+		sdk := &cloud.Fake{}
+		server.AddReadinessCheck("cloud", CloudCheck(sdk, time.Second))
+		Expect(probe(server, ReadinessPath).Code).To(Equal(http.StatusOK))
+		sdk.Unreachable = true
+		Expect(probe(server, ReadinessPath).Code).To(Equal(http.StatusInternalServerError))
+	})
+})
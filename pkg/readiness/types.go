@@ -2,9 +2,12 @@ package readiness
 
 import (
 	"errors"
+	"sort"
+	"time"
 
 	"github.com/nirnanaaa/kube-readiness/pkg/cloud"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // IngressEndpoint contains the essential information for each pod in a endpoint group.
@@ -13,7 +16,133 @@ type IngressEndpoint struct {
 	Port int32
 }
 
-type EndpointPodMap map[IngressEndpoint]types.NamespacedName
+// PodRef identifies a single incarnation of a pod. Pods of the same name, e.g. of a StatefulSet, differ in their UID.
+type PodRef struct {
+	Name types.NamespacedName
+	UID  types.UID
+}
+
+// Matches reports whether the reference refers to the given incarnation of a pod. References
+// without UID match all pods of the name.
+func (p PodRef) Matches(name types.NamespacedName, uid types.UID) bool {
+	return p.Name == name && (p.UID == "" || p.UID == uid)
+}
+
+// PodNames returns the names of the referenced pods
+func PodNames(refs []PodRef) []types.NamespacedName {
+	names := make([]types.NamespacedName, 0, len(refs))
+	for _, ref := range refs {
+		names = append(names, ref.Name)
+	}
+	return names
+}
+
+// EndpointPodMap maps endpoints to the pod serving them. It keeps a reverse index, so that all
+// endpoints of a pod can be dropped once the pod is gone or got a new ip. It records when an endpoint
+// got first seen for its pod as well.
+type EndpointPodMap struct {
+	// Now is the clock endpoints are first seen with, time.Now if unset
+	Now func() time.Time
+
+	pods      map[IngressEndpoint]PodRef
+	endpoints map[types.NamespacedName][]IngressEndpoint
+	seen      map[IngressEndpoint]time.Time
+}
+
+func NewEndpointPodMap() *EndpointPodMap {
+	return &EndpointPodMap{
+		pods:      map[IngressEndpoint]PodRef{},
+		endpoints: map[types.NamespacedName][]IngressEndpoint{},
+		seen:      map[IngressEndpoint]time.Time{},
+	}
+}
+
+// Get returns the pod serving an endpoint
+func (m *EndpointPodMap) Get(endpoint IngressEndpoint) (PodRef, bool) {
+	pod, ok := m.pods[endpoint]
+	return pod, ok
+}
+
+// SetPod replaces all endpoints of a pod. Endpoints the same incarnation of the pod served before
+// keep the time they got first seen.
+func (m *EndpointPodMap) SetPod(pod PodRef, endpoints []IngressEndpoint) {
+	seen := map[IngressEndpoint]time.Time{}
+	for _, endpoint := range m.endpoints[pod.Name] {
+		if m.pods[endpoint] == pod {
+			seen[endpoint] = m.seen[endpoint]
+		}
+	}
+	m.RemovePod(pod.Name)
+	if len(endpoints) == 0 {
+		return
+	}
+	now := m.now()
+	for _, endpoint := range endpoints {
+		if previous, ok := m.pods[endpoint]; ok && previous.Name != pod.Name {
+			// the ip got recycled, the previous pod does not serve it anymore
+			m.removeEndpoint(previous.Name, endpoint)
+		}
+		m.pods[endpoint] = pod
+		if since, ok := seen[endpoint]; ok {
+			m.seen[endpoint] = since
+		} else {
+			m.seen[endpoint] = now
+		}
+	}
+	m.endpoints[pod.Name] = endpoints
+}
+
+// Age returns the time since the most recently added endpoint of a pod got first seen, false if the
+// pod does not serve any endpoint
+func (m *EndpointPodMap) Age(name types.NamespacedName) (time.Duration, bool) {
+	var latest time.Time
+	for _, endpoint := range m.endpoints[name] {
+		if since := m.seen[endpoint]; since.After(latest) {
+			latest = since
+		}
+	}
+	if latest.IsZero() {
+		return 0, false
+	}
+	return m.now().Sub(latest), true
+}
+
+func (m *EndpointPodMap) now() time.Time {
+	if m.Now != nil {
+		return m.Now()
+	}
+	return time.Now()
+}
+
+// RemovePod drops all endpoints of a pod
+func (m *EndpointPodMap) RemovePod(name types.NamespacedName) {
+	for _, endpoint := range m.endpoints[name] {
+		if m.pods[endpoint].Name == name {
+			delete(m.pods, endpoint)
+			delete(m.seen, endpoint)
+		}
+	}
+	delete(m.endpoints, name)
+}
+
+func (m *EndpointPodMap) removeEndpoint(name types.NamespacedName, endpoint IngressEndpoint) {
+	endpoints := m.endpoints[name][:0]
+	for _, item := range m.endpoints[name] {
+		if item != endpoint {
+			endpoints = append(endpoints, item)
+		}
+	}
+	if len(endpoints) == 0 {
+		delete(m.endpoints, name)
+		return
+	}
+	m.endpoints[name] = endpoints
+}
+
+// Len returns the number of endpoints and pods in the map
+func (m *EndpointPodMap) Len() (endpoints int, pods int) {
+	return len(m.pods), len(m.endpoints)
+}
 
 // ServiceInfoMap stores a service and its ingressdata
 type ServiceInfoMap map[types.NamespacedName]IngressInfo
@@ -21,13 +150,107 @@ type ServiceInfoMap map[types.NamespacedName]IngressInfo
 type IngressInfo struct {
 	Name      string
 	Endpoints []*cloud.EndpointGroup
-	Pods      []types.NamespacedName
+	// Pods behind the service, an entry of a previous pod of the same name does not match a new one
+	Pods []PodRef
+	// Ingresses exposing the service with the endpoint groups of their load balancers. Endpoints
+	// holds the groups of all of them.
+	Ingresses map[types.NamespacedName]IngressBackend
+}
+
+// IngressBackend is the part of an ingress routing to a service
+type IngressBackend struct {
+	// Endpoints are the endpoint groups of the load balancer of the ingress
+	Endpoints []*cloud.EndpointGroup
+	// ServicePorts are the ports of the service the ingress routes to
+	ServicePorts []intstr.IntOrString
+	// NodePorts of the service ports, the ports of instance mode endpoints
+	NodePorts []int32
 }
 
-func (i ServiceInfoMap) GetServiceInfoForPod(name types.NamespacedName) (*IngressInfo, error) {
+// SetIngress records the backend of an ingress exposing the service. The node ports of a backend
+// routing to the same service ports as before are kept until they are resolved again. The
+// ingresses are copied, copies of the info handed out before keep theirs.
+func (i *IngressInfo) SetIngress(ingress types.NamespacedName, backend IngressBackend) {
+	if previous, ok := i.Ingresses[ingress]; ok && equalServicePorts(previous.ServicePorts, backend.ServicePorts) {
+		backend.NodePorts = previous.NodePorts
+	}
+	ingresses := i.copyIngresses(ingress)
+	ingresses[ingress] = backend
+	i.Ingresses = ingresses
+	i.Endpoints = endpointsOfIngresses(ingresses)
+}
+
+// removeIngress drops an ingress the same way SetIngress adds one
+func (i *IngressInfo) removeIngress(ingress types.NamespacedName) {
+	i.Ingresses = i.copyIngresses(ingress)
+	i.Endpoints = endpointsOfIngresses(i.Ingresses)
+}
+
+// ResolveNodePorts sets the node ports of all ingresses from the service ports they route to,
+// service ports without a node port are skipped
+func (i *IngressInfo) ResolveNodePorts(nodePort func(intstr.IntOrString) (int32, bool)) {
+	ingresses := i.copyIngresses(types.NamespacedName{})
+	for name, backend := range ingresses {
+		backend.NodePorts = nil
+		for _, servicePort := range backend.ServicePorts {
+			if port, ok := nodePort(servicePort); ok {
+				backend.NodePorts = append(backend.NodePorts, port)
+			}
+		}
+		ingresses[name] = backend
+	}
+	i.Ingresses = ingresses
+}
+
+// copyIngresses returns a copy of the ingresses without the given one
+func (i *IngressInfo) copyIngresses(without types.NamespacedName) map[types.NamespacedName]IngressBackend {
+	ingresses := make(map[types.NamespacedName]IngressBackend, len(i.Ingresses)+1)
+	for name, backend := range i.Ingresses {
+		if name != without {
+			ingresses[name] = backend
+		}
+	}
+	return ingresses
+}
+
+func equalServicePorts(a, b []intstr.IntOrString) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for index := range a {
+		if a[index] != b[index] {
+			return false
+		}
+	}
+	return true
+}
+
+// endpointsOfIngresses returns the endpoint groups of all ingresses ordered by ingress, a group
+// shared by several ingresses is returned once
+func endpointsOfIngresses(ingresses map[types.NamespacedName]IngressBackend) []*cloud.EndpointGroup {
+	names := make([]types.NamespacedName, 0, len(ingresses))
+	for name := range ingresses {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(a, b int) bool { return names[a].String() < names[b].String() })
+	seen := map[string]bool{}
+	var endpoints []*cloud.EndpointGroup
+	for _, name := range names {
+		for _, group := range ingresses[name].Endpoints {
+			if !seen[group.Name] {
+				seen[group.Name] = true
+				endpoints = append(endpoints, group)
+			}
+		}
+	}
+	return endpoints
+}
+
+// GetServiceInfoForPod returns the service the given incarnation of a pod is behind
+func (i ServiceInfoMap) GetServiceInfoForPod(name types.NamespacedName, uid types.UID) (*IngressInfo, error) {
 	for _, item := range i {
-		for _, podName := range item.Pods {
-			if podName == name {
+		for _, pod := range item.Pods {
+			if pod.Matches(name, uid) {
 				return &item, nil
 			}
 		}
@@ -45,3 +268,26 @@ func (i ServiceInfoMap) Remove(names ...types.NamespacedName) {
 		delete(i, item)
 	}
 }
+
+// RemoveIngress removes the given ingress from all services it exposes, except for the ones listed
+// in keep. Services which are not exposed by any other ingress are dropped. It returns the pods of
+// all services whose ingresses changed.
+func (i ServiceInfoMap) RemoveIngress(ingress types.NamespacedName, keep ...types.NamespacedName) (pods []types.NamespacedName) {
+	kept := map[types.NamespacedName]bool{}
+	for _, name := range keep {
+		kept[name] = true
+	}
+	for name, item := range i {
+		if _, ok := item.Ingresses[ingress]; !ok || kept[name] {
+			continue
+		}
+		pods = append(pods, PodNames(item.Pods)...)
+		item.removeIngress(ingress)
+		if len(item.Ingresses) == 0 {
+			delete(i, name)
+			continue
+		}
+		i[name] = item
+	}
+	return pods
+}
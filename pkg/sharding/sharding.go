@@ -0,0 +1,249 @@
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Mode determines by which key pods are assigned to shards
+type Mode string
+
+const (
+	// ModeNamespace assigns all pods of a namespace to the same replica
+	ModeNamespace Mode = "namespace"
+	// ModeUID spreads pods evenly by their uid
+	ModeUID Mode = "uid"
+)
+
+// groupLabel marks the membership leases of a group of replicas
+const groupLabel = "kube-readiness.io/shard-group"
+
+// ParseMode validates a sharding mode. The empty mode disables sharding.
+func ParseMode(mode string) (Mode, error) {
+	switch Mode(mode) {
+	case "", ModeNamespace, ModeUID:
+		return Mode(mode), nil
+	}
+	return "", fmt.Errorf("unknown sharding mode %q, expected %q or %q", mode, ModeNamespace, ModeUID)
+}
+
+// Coordinator splits the pods between the replicas of a group. Every replica holds a Lease which it
+// renews as long as it is alive; the replicas holding an unexpired Lease are the members of the group.
+// Pods are assigned to members by rendezvous hashing, so that a change of the members only moves the
+// pods of the replica which joined or left.
+type Coordinator struct {
+	// Client has to read leases directly from the api server
+	Client    client.Client
+	Log       logr.Logger
+	Mode      Mode
+	Namespace string
+	Group     string
+	Identity  string
+	// LeaseDuration is the time after which a replica which stopped renewing its Lease leaves the group
+	LeaseDuration time.Duration
+	// RenewInterval is the time between two renewals of the Lease
+	RenewInterval time.Duration
+	// OnRebalance is called once the members changed, the replica might own pods it did not own before
+	OnRebalance func()
+
+	mu      sync.RWMutex
+	members []string
+	now     func() time.Time
+}
+
+func (c *Coordinator) Start(stop <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ticker := time.NewTicker(c.RenewInterval)
+	defer ticker.Stop()
+	for {
+		if err := c.Sync(ctx); err != nil {
+			c.Log.Error(err, "unable to sync shard members")
+		}
+		select {
+		case <-stop:
+			// leave the group right away instead of waiting for the lease to expire
+			if err := c.leave(ctx); err != nil {
+				c.Log.Error(err, "unable to release shard lease")
+			}
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection is false, sharding replaces the leader election
+func (c *Coordinator) NeedLeaderElection() bool {
+	return false
+}
+
+// Sync renews the Lease of the replica and updates the members of the group
+func (c *Coordinator) Sync(ctx context.Context) error {
+	if err := c.renew(ctx); err != nil {
+		return err
+	}
+	var leases coordinationv1.LeaseList
+	if err := c.Client.List(ctx, &leases, client.InNamespace(c.Namespace), client.MatchingLabels{groupLabel: c.Group}); err != nil {
+		return err
+	}
+	members := []string{c.Identity}
+	for _, lease := range leases.Items {
+		identity := leaseIdentity(&lease)
+		if identity == "" || identity == c.Identity || c.expired(&lease) {
+			continue
+		}
+		members = append(members, identity)
+	}
+	sort.Strings(members)
+	if !c.setMembers(members) {
+		return nil
+	}
+	c.Log.Info("shard members changed, rebalancing", "members", members)
+	if c.OnRebalance != nil {
+		go c.OnRebalance()
+	}
+	return nil
+}
+
+func (c *Coordinator) renew(ctx context.Context) error {
+	now := metav1.NewMicroTime(c.clock())
+	seconds := int32(c.LeaseDuration / time.Second)
+	var lease coordinationv1.Lease
+	err := c.Client.Get(ctx, c.leaseName(), &lease)
+	if apierrors.IsNotFound(err) {
+		lease = coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: c.Namespace,
+				Name:      c.leaseName().Name,
+				Labels:    map[string]string{groupLabel: c.Group},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &c.Identity,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		return c.Client.Create(ctx, &lease)
+	}
+	if err != nil {
+		return err
+	}
+	lease.Spec.HolderIdentity = &c.Identity
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &now
+	return c.Client.Update(ctx, &lease)
+}
+
+func (c *Coordinator) leave(ctx context.Context) error {
+	lease := &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Namespace: c.Namespace, Name: c.leaseName().Name}}
+	return client.IgnoreNotFound(c.Client.Delete(ctx, lease))
+}
+
+func (c *Coordinator) leaseName() types.NamespacedName {
+	return types.NamespacedName{Namespace: c.Namespace, Name: c.Group + "-" + c.Identity}
+}
+
+func (c *Coordinator) expired(lease *coordinationv1.Lease) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	duration := time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	return lease.Spec.RenewTime.Add(duration).Before(c.clock())
+}
+
+func (c *Coordinator) clock() time.Time {
+	if c.now == nil {
+		return time.Now()
+	}
+	return c.now()
+}
+
+func leaseIdentity(lease *coordinationv1.Lease) string {
+	if lease.Spec.HolderIdentity == nil {
+		return ""
+	}
+	return *lease.Spec.HolderIdentity
+}
+
+// setMembers reports whether the members changed
+func (c *Coordinator) setMembers(members []string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(members) == len(c.members) {
+		changed := false
+		for i := range members {
+			if members[i] != c.members[i] {
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			return false
+		}
+	}
+	c.members = members
+	return true
+}
+
+// Members returns the replicas of the group
+func (c *Coordinator) Members() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]string{}, c.members...)
+}
+
+// Owns reports whether the replica is responsible for a pod. A missing coordinator owns all pods,
+// a coordinator which did not see its group, yet, none.
+func (c *Coordinator) Owns(pod *corev1.Pod) bool {
+	if c == nil {
+		return true
+	}
+	key := pod.Namespace
+	if c.Mode == ModeUID {
+		key = string(pod.UID)
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return owner(c.members, key) == c.Identity
+}
+
+// owner picks the member with the highest hash of member and key
+func owner(members []string, key string) string {
+	var (
+		best      string
+		bestScore uint64
+	)
+	for _, member := range members {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(member))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(key))
+		if score := mix(h.Sum64()); best == "" || score > bestScore {
+			best, bestScore = member, score
+		}
+	}
+	return best
+}
+
+// mix spreads similar fnv hashes of similar keys over the whole range (murmur3 finalizer)
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
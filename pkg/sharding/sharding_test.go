@@ -0,0 +1,161 @@
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var _ = Describe("Coordinator", func() {
+	var (
+		c          client.Client
+		now        time.Time
+		rebalances int32
+	)
+	newCoordinator := func(identity string, mode Mode) *Coordinator {
+		return &Coordinator{
+			Client:        c,
+			Log:           logf.NullLogger{},
+			Mode:          mode,
+			Namespace:     "kube-system",
+			Group:         "kube-readiness",
+			Identity:      identity,
+			LeaseDuration: 15 * time.Second,
+			RenewInterval: 5 * time.Second,
+			OnRebalance:   func() { atomic.AddInt32(&rebalances, 1) },
+			now:           func() time.Time { return now },
+		}
+	}
+	syncAll := func(coordinators ...*Coordinator) {
+		// twice, so that every replica sees the leases the others created
+		for i := 0; i < 2; i++ {
+			for _, coordinator := range coordinators {
+				Expect(coordinator.Sync(context.TODO())).To(Succeed())
+			}
+		}
+	}
+	pods := func(n int) []*corev1.Pod {
+		var pods []*corev1.Pod
+		for i := 0; i < n; i++ {
+			pods = append(pods, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Namespace: fmt.Sprintf("namespace-%d", i%50),
+				Name:      fmt.Sprintf("pod-%d", i),
+				UID:       types.UID(fmt.Sprintf("uid-%d", i)),
+			}})
+		}
+		return pods
+	}
+	owners := func(pods []*corev1.Pod, coordinators ...*Coordinator) map[string]string {
+		owned := map[string]string{}
+		for _, pod := range pods {
+			for _, coordinator := range coordinators {
+				if coordinator.Owns(pod) {
+					Expect(owned).ToNot(HaveKey(pod.Name), "pod owned twice")
+					owned[pod.Name] = coordinator.Identity
+				}
+			}
+			Expect(owned).To(HaveKey(pod.Name), "pod not owned")
+		}
+		return owned
+	}
+
+	BeforeEach(func() {
+		c = fake.NewFakeClientWithScheme(scheme.Scheme)
+		now = time.Now()
+		atomic.StoreInt32(&rebalances, 0)
+	})
+
+	It("should own all pods without coordinator and none before the first sync", func() {
+		var missing *Coordinator
+		pod := pods(1)[0]
+		Expect(missing.Owns(pod)).To(BeTrue())
+		Expect(newCoordinator("a", ModeUID).Owns(pod)).To(BeFalse())
+	})
+
+	It("should split the pods between the replicas", func() {
+		a, b, d := newCoordinator("a", ModeUID), newCoordinator("b", ModeUID), newCoordinator("c", ModeUID)
+		syncAll(a, b, d)
+		Expect(a.Members()).To(Equal([]string{"a", "b", "c"}))
+		Expect(d.Members()).To(Equal([]string{"a", "b", "c"}))
+
+		counts := map[string]int{}
+		for _, owner := range owners(pods(3000), a, b, d) {
+			counts[owner]++
+		}
+		for _, count := range counts {
+			Expect(count).To(BeNumerically("~", 1000, 150))
+		}
+	})
+
+	It("should keep the pods of a namespace together", func() {
+		a, b := newCoordinator("a", ModeNamespace), newCoordinator("b", ModeNamespace)
+		syncAll(a, b)
+		namespaces := map[string]string{}
+		all := pods(500)
+		owned := owners(all, a, b)
+		for _, pod := range all {
+			if owner, ok := namespaces[pod.Namespace]; ok {
+				Expect(owned[pod.Name]).To(Equal(owner))
+			}
+			namespaces[pod.Namespace] = owned[pod.Name]
+		}
+	})
+
+	It("should only move the pods of a replica which left", func() {
+		a, b, d := newCoordinator("a", ModeUID), newCoordinator("b", ModeUID), newCoordinator("c", ModeUID)
+		syncAll(a, b, d)
+		all := pods(1000)
+		before := owners(all, a, b, d)
+
+		By("stopping a replica")
+		stop := make(chan struct{})
+		close(stop)
+		Expect(d.Start(stop)).To(Succeed())
+		atomic.StoreInt32(&rebalances, 0)
+		syncAll(a, b)
+		Expect(a.Members()).To(Equal([]string{"a", "b"}))
+		Eventually(func() int32 { return atomic.LoadInt32(&rebalances) }).Should(Equal(int32(2)))
+
+		after := owners(all, a, b)
+		for name, owner := range before {
+			if owner != "c" {
+				Expect(after[name]).To(Equal(owner))
+			}
+		}
+	})
+
+	It("should drop replicas whose lease expired", func() {
+		a, b := newCoordinator("a", ModeUID), newCoordinator("b", ModeUID)
+		syncAll(a, b)
+		Expect(a.Members()).To(Equal([]string{"a", "b"}))
+
+		now = now.Add(20 * time.Second)
+		Expect(a.Sync(context.TODO())).To(Succeed())
+		Expect(a.Members()).To(Equal([]string{"a"}))
+		owners(pods(100), a)
+
+		By("rejoining once the replica renews its lease")
+		syncAll(a, b)
+		Expect(a.Members()).To(Equal([]string{"a", "b"}))
+	})
+
+	It("should validate the mode", func() {
+		for _, mode := range []string{"", "namespace", "uid"} {
+			_, err := ParseMode(mode)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		_, err := ParseMode("hash")
+		Expect(err).To(HaveOccurred())
+	})
+})